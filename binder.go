@@ -0,0 +1,575 @@
+package watson
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+var (
+	stringType      = reflect.TypeOf("")
+	unmarshalerType = reflect.TypeOf((*types.Unmarshaler)(nil)).Elem()
+)
+
+// binder executes instructions like vm.VM does, but it binds objects and arrays directly to Go values instead of building Values,
+// so that decoding does not need to build the whole Value before binding it.
+//
+// The type to which an object or an array is bound is expected from where it is built:
+// a value at the bottom of the stack is bound to the destination, a value on top of an object and a key is bound to the corresponding field or element,
+// and a value on top of an array is bound to its element.
+// Values whose types can't be expected, or whose types need the Value itself (e.g. interface{} or types.Unmarshaler), are built as Values and then bound as usual.
+//
+// This works as long as values are built in a tree-like manner, as Encoder does.
+// Once an instruction like Gdup or Gswp moves an object or an array, binder puts all values on the stack into a VM and executes the rest of the instructions with it.
+// Objects and arrays that are partly bound at that point are converted back into Values by types.ToValue.
+type binder struct {
+	dst   interface{}   // the destination
+	to    reflect.Value // reflect.ValueOf(dst)
+	stack []entry
+	size  int // the maximum number of values on the stack
+	opts  []vm.VMOption
+	m     *vm.VM // non-nil if binder has fallen back to VM
+}
+
+// entry is a value on the stack of binder.
+// Unlike VM, binder keeps values on its stack instead of pointers to them, so that executing instructions does not allocate them.
+type entry struct {
+	types.Value            // a scalar, or an object or an array that is built as a Value
+	c           *container // an object or an array that is bound directly, in which case Value only has its kind
+}
+
+// container is an object or an array that is being bound directly.
+type container struct {
+	t    reflect.Type  // the type to which the container is going to be bound
+	base reflect.Value // the value being built; its type is t with all pointers removed
+	n    int           // the number of elements that are added to the array
+	keys []string      // the keys of fields that are set, which are needed to convert the struct back into a Value
+
+	// Where the container is going to be bound, which is reported in errors.
+	parent *container // the container to a member of which the container is going to be bound, or nil if it is bound to the destination
+	key    string     // the key of the member if parent is an object
+	index  int        // the index of the element if parent is an array
+
+	// Errors are not reported until the container is bound, since members may be overwritten and the container may be discarded.
+	err     error            // the first error that occurred while binding an element of the array
+	tooLong bool             // whether the array has more elements than its type can hold
+	failed  []string         // the keys of members that failed to be bound, in the order of failure
+	errs    map[string]error // the errors of members that failed to be bound
+}
+
+// newBinder creates a binder that binds the result to `to`.
+// If direct is false, or `to` can't be bound directly, binder executes all instructions with a VM created with opts.
+func newBinder(to interface{}, direct bool, stackSize int, opts ...vm.VMOption) *binder {
+	if stackSize <= 0 {
+		stackSize = vm.DefaultStackSize
+	}
+	b := &binder{
+		dst:  to,
+		to:   reflect.ValueOf(to),
+		size: stackSize,
+		opts: append([]vm.VMOption{vm.WithStackSize(stackSize)}, opts...),
+	}
+	if !direct || b.to.Kind() != reflect.Ptr || b.to.IsNil() || b.to.Type().Implements(unmarshalerType) {
+		b.m = vm.NewVM(b.opts...)
+	}
+	return b
+}
+
+// Feed executes op. Like vm.VM.Feed, the stack is left as it was if it fails.
+// Instructions that pop and push only scalars are executed according to vm.LookupScalarOp, so that they behave in the same way as VM.
+func (b *binder) Feed(op vm.Op) error {
+	if b.m != nil {
+		return b.m.Feed(op)
+	}
+	switch op {
+	case vm.Onew:
+		return b.feedNew(types.Object)
+	case vm.Oadd:
+		return b.feedOadd()
+	case vm.Anew:
+		return b.feedNew(types.Array)
+	case vm.Aadd:
+		return b.feedAadd()
+	case vm.Gdup:
+		return b.feedGdup()
+	case vm.Gpop:
+		_, err := b.peekAny(0)
+		if err != nil {
+			return err
+		}
+		b.pop()
+		return nil
+	case vm.Gswp:
+		return b.feedGswp()
+	}
+	s := vm.LookupScalarOp(op)
+	if s == nil {
+		panic(fmt.Errorf("invalid opcode: %d", op))
+	}
+	return b.feedScalar(s)
+}
+
+func (b *binder) feedScalar(s *vm.ScalarOp) error {
+	n := len(b.stack)
+	err := s.Check(n, func(i int) types.Kind { return b.stack[n-1-i].Kind })
+	if err != nil {
+		return err
+	}
+	// The result replaces the first operand in place.
+	switch len(s.Operands) {
+	case 0:
+		err = b.push(entry{})
+		if err != nil {
+			return err
+		}
+		s.Apply(&b.stack[n].Value, nil)
+	case 1:
+		s.Apply(&b.stack[n-1].Value, nil)
+	case 2:
+		s.Apply(&b.stack[n-2].Value, &b.stack[n-1].Value)
+		b.pop()
+	}
+	return nil
+}
+
+// feedNew executes Onew or Anew, which pushes an empty value of the given kind.
+func (b *binder) feedNew(kind types.Kind) error {
+	e := entry{Value: types.Value{Kind: kind}}
+	t, parent, key := b.expected()
+	e.c = newContainer(t, kind)
+	if e.c != nil {
+		e.c.parent, e.c.key = parent, key
+		if parent != nil {
+			e.c.index = parent.n
+		}
+	} else if kind == types.Object {
+		e.Object = types.NewMap()
+	} else {
+		e.Array = []*types.Value{}
+	}
+	return b.push(e)
+}
+
+func (b *binder) feedOadd() error {
+	x, err := b.peekAny(0)
+	if err != nil {
+		return err
+	}
+	k, err := b.peek(1, types.String)
+	if err != nil {
+		return err
+	}
+	o, err := b.peekAny(2)
+	if err != nil {
+		return err
+	}
+	if o.Kind != types.Object {
+		return &vm.TypeMismatchError{Expected: types.Object, Actual: o.Kind}
+	}
+	if o.c != nil {
+		o.c.set(string(k.String), x)
+	} else {
+		o.Object.Set(string(k.String), x.toValue())
+	}
+	b.pop()
+	b.pop()
+	return nil
+}
+
+func (b *binder) feedAadd() error {
+	x, err := b.peekAny(0)
+	if err != nil {
+		return err
+	}
+	a, err := b.peekAny(1)
+	if err != nil {
+		return err
+	}
+	if a.Kind != types.Array {
+		return &vm.TypeMismatchError{Expected: types.Array, Actual: a.Kind}
+	}
+	if a.c != nil {
+		a.c.add(x)
+	} else {
+		a.Array = append(a.Array, x.toValue())
+	}
+	b.pop()
+	return nil
+}
+
+func (b *binder) feedGdup() error {
+	x, err := b.peekAny(0)
+	if err != nil {
+		return err
+	}
+	if x.isContainer() {
+		return b.fallback(vm.Gdup)
+	}
+	// Limit the capacity of both strings so that appending to one does not affect the other.
+	x.String = x.String[:len(x.String):len(x.String)]
+	return b.push(*x)
+}
+
+func (b *binder) feedGswp() error {
+	x, err := b.peekAny(0)
+	if err != nil {
+		return err
+	}
+	y, err := b.peekAny(1)
+	if err != nil {
+		return err
+	}
+	if x.isContainer() || y.isContainer() {
+		return b.fallback(vm.Gswp)
+	}
+	*x, *y = *y, *x
+	return nil
+}
+
+// fallback moves all values on the stack to a new VM and executes op with it.
+func (b *binder) fallback(op vm.Op) error {
+	b.m = vm.NewVM(b.opts...)
+	for i := range b.stack {
+		err := b.m.Push(b.stack[i].toValue())
+		if err != nil {
+			return err
+		}
+	}
+	b.stack = nil
+	return b.m.Feed(op)
+}
+
+// expected returns the type to which a value that is going to be pushed is expected to be bound, or nil if it is unknown.
+// If the value is expected to be bound to a member of a container, it also returns the container and the key of the member.
+func (b *binder) expected() (reflect.Type, *container, string) {
+	n := len(b.stack)
+	if n == 0 {
+		return b.to.Type().Elem(), nil, ""
+	}
+	top := &b.stack[n-1]
+	if top.c != nil && top.Kind == types.Array {
+		return top.c.base.Type().Elem(), top.c, ""
+	}
+	if top.Kind == types.String && n >= 2 {
+		if o := &b.stack[n-2]; o.c != nil && o.Kind == types.Object {
+			key := string(top.String)
+			return o.c.memberType(key), o.c, key
+		}
+	}
+	return nil, nil, ""
+}
+
+// Stack returns all values on the stack, from the bottom to the top.
+// Objects and arrays that are bound directly are converted back into Values.
+func (b *binder) Stack() []*types.Value {
+	if b.m != nil {
+		return b.m.Stack()
+	}
+	stack := make([]*types.Value, 0, len(b.stack))
+	for i := range b.stack {
+		stack = append(stack, b.stack[i].toValue())
+	}
+	return stack
+}
+
+// Bind binds the value on the top of the stack to the destination.
+func (b *binder) Bind() error {
+	if b.m != nil {
+		top, err := b.m.Top()
+		if err != nil {
+			return err
+		}
+		return top.Bind(b.dst)
+	}
+	if len(b.stack) == 0 {
+		return vm.ErrStackEmpty
+	}
+	top := &b.stack[len(b.stack)-1]
+	if top.c != nil && top.c.parent != nil {
+		// The result is expected to be a member of an object or an array that is not completed.
+		// It is bound as a Value so that errors tell where it is in the result rather than in its parent.
+		return top.toValue().Bind(b.dst)
+	}
+	return assign(b.to.Elem(), top, types.RootPath)
+}
+
+func (b *binder) push(e entry) error {
+	if len(b.stack) >= b.size {
+		return vm.ErrMaximumStackSizeExceeded
+	}
+	b.stack = append(b.stack, e)
+	return nil
+}
+
+func (b *binder) pop() {
+	n := len(b.stack) - 1
+	b.stack[n] = entry{}
+	b.stack = b.stack[:n]
+}
+
+// peekAny returns the i-th value from the top of the stack.
+func (b *binder) peekAny(i int) (*entry, error) {
+	n := len(b.stack) - 1 - i
+	if n < 0 {
+		return nil, vm.ErrStackEmpty
+	}
+	return &b.stack[n], nil
+}
+
+// peek returns the i-th value from the top of the stack, which must be of the given kind.
+func (b *binder) peek(i int, kind types.Kind) (*entry, error) {
+	e, err := b.peekAny(i)
+	if err != nil {
+		return nil, err
+	}
+	if e.Kind != kind {
+		return nil, &vm.TypeMismatchError{Expected: kind, Actual: e.Kind}
+	}
+	return e, nil
+}
+
+func (e *entry) isContainer() bool {
+	return e.Kind == types.Object || e.Kind == types.Array
+}
+
+// toValue converts e into a Value.
+func (e *entry) toValue() *types.Value {
+	if e.c != nil {
+		return e.c.toValue()
+	}
+	v := e.Value
+	return &v
+}
+
+// newContainer creates a container that is going to be bound to t, or returns nil if a value of the given kind can't be bound to t directly.
+func newContainer(t reflect.Type, kind types.Kind) *container {
+	if t == nil {
+		return nil
+	}
+	base := t
+	for {
+		if base.Implements(unmarshalerType) {
+			return nil
+		}
+		if base.Kind() != reflect.Ptr {
+			break
+		}
+		base = base.Elem()
+	}
+	switch {
+	case kind == types.Object && base.Kind() == reflect.Struct && !types.HasInlineFields(base):
+		return &container{t: t, base: reflect.New(base).Elem()}
+	case kind == types.Object && base.Kind() == reflect.Map && base.Key() == stringType:
+		return &container{t: t, base: reflect.MakeMap(base)}
+	case kind == types.Array && base.Kind() == reflect.Slice:
+		return &container{t: t, base: reflect.MakeSlice(base, 0, 0)}
+	case kind == types.Array && base.Kind() == reflect.Array:
+		return &container{t: t, base: reflect.New(base).Elem()}
+	}
+	return nil
+}
+
+// path returns where c is going to be bound.
+func (c *container) path() types.Path {
+	switch {
+	case c.parent == nil:
+		return types.RootPath()
+	case c.parent.base.Kind() == reflect.Slice || c.parent.base.Kind() == reflect.Array:
+		return c.parent.path().Index(c.index)
+	default:
+		return c.parent.path().Field(c.key)
+	}
+}
+
+// memberType returns the type to which a member named key is expected to be bound, or nil if it is unknown.
+func (c *container) memberType(key string) reflect.Type {
+	if c.base.Kind() == reflect.Map {
+		return c.base.Type().Elem()
+	}
+	f, ok := types.LookupField(c.base.Type(), key)
+	if !ok || reflect.PtrTo(f.Type).Implements(unmarshalerType) {
+		return nil
+	}
+	return f.Type
+}
+
+// set binds e to the member named key.
+func (c *container) set(key string, e *entry) {
+	var err error
+	if c.base.Kind() == reflect.Map {
+		v := reflect.New(c.base.Type().Elem()).Elem()
+		err = assign(v, e, func() types.Path { return c.path().Field(key) })
+		if err == nil {
+			c.base.SetMapIndex(reflect.ValueOf(key), v)
+		}
+	} else {
+		f, ok := types.LookupField(c.base.Type(), key)
+		if !ok {
+			return
+		}
+		c.keys = append(c.keys, key)
+		field := c.base.FieldByIndex(f.Index)
+		if reflect.PtrTo(f.Type).Implements(unmarshalerType) {
+			err = e.toValue().BindByReflection(field.Addr())
+		} else {
+			err = assign(field, e, func() types.Path { return c.path().Field(key) })
+		}
+	}
+	if err != nil {
+		if c.errs == nil {
+			c.errs = make(map[string]error)
+		}
+		if _, ok := c.errs[key]; !ok {
+			c.failed = append(c.failed, key)
+		}
+		c.errs[key] = err
+	} else if c.errs != nil {
+		delete(c.errs, key)
+	}
+}
+
+// add binds e to the next element of the array.
+func (c *container) add(e *entry) {
+	defer func() { c.n++ }()
+	if c.err != nil || c.tooLong {
+		return
+	}
+	at := func() types.Path { return c.path().Index(c.n) }
+	if c.base.Kind() == reflect.Array {
+		if c.n >= c.base.Len() {
+			c.tooLong = true
+			return
+		}
+		c.err = assign(c.base.Index(c.n), e, at)
+		return
+	}
+	if c.n == c.base.Cap() {
+		grown := reflect.MakeSlice(c.base.Type(), c.n, 2*c.n+4)
+		reflect.Copy(grown, c.base)
+		c.base = grown
+	}
+	c.base = c.base.Slice(0, c.n+1)
+	c.err = assign(c.base.Index(c.n), e, at)
+}
+
+// value returns the bound value of type c.t, or the error that occurred while binding members.
+func (c *container) value() (reflect.Value, error) {
+	if c.tooLong {
+		// Let types report the error in the same way as it does for a Value.
+		return types.NewArrayValue(make([]*types.Value, c.n)).CastAt(c.base.Type(), c.path())
+	}
+	if c.err != nil {
+		return reflect.Value{}, c.err
+	}
+	for _, key := range c.failed {
+		if err, ok := c.errs[key]; ok {
+			return reflect.Value{}, err
+		}
+	}
+	return wrap(c.t, c.base), nil
+}
+
+// toValue converts c back into a Value.
+// Since c does not keep members that are not bound, or values that are converted to narrower types, the result may differ from the one built by VM.
+func (c *container) toValue() *types.Value {
+	switch c.base.Kind() {
+	case reflect.Struct:
+		obj := types.NewMap()
+		for _, key := range c.keys {
+			f, _ := types.LookupField(c.base.Type(), key)
+			obj.Set(key, toValue(c.base.FieldByIndex(f.Index)))
+		}
+		return types.NewOrderedObjectValue(obj)
+	case reflect.Map:
+		obj := make(map[string]*types.Value, c.base.Len())
+		iter := c.base.MapRange()
+		for iter.Next() {
+			obj[iter.Key().String()] = toValue(iter.Value())
+		}
+		return types.NewObjectValue(obj)
+	default:
+		arr := make([]*types.Value, 0, c.n)
+		for i := 0; i < c.n && i < c.base.Len(); i++ {
+			arr = append(arr, toValue(c.base.Index(i)))
+		}
+		return types.NewArrayValue(arr)
+	}
+}
+
+// toValue converts v, which is bound from a Value, back into a Value.
+func toValue(v reflect.Value) *types.Value {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		// Nil is checked first so that types.Marshaler is not called with nil.
+		if v.IsNil() {
+			return types.NewNilValue()
+		}
+	}
+	val, err := types.ToValueByReflection(v)
+	if err != nil {
+		// This happens only if v has fields that can't be converted; such fields can't be bound either.
+		return types.NewNilValue()
+	}
+	return val
+}
+
+// wrap returns a value of type t that points to base through zero or more pointers.
+func wrap(t reflect.Type, base reflect.Value) reflect.Value {
+	if t.Kind() != reflect.Ptr {
+		return base
+	}
+	p := reflect.New(t.Elem())
+	p.Elem().Set(wrap(t.Elem(), base))
+	return p
+}
+
+// assign binds e to dst, which must be settable. Errors report that e is located at at(), which is called only if e can't be bound.
+func assign(dst reflect.Value, e *entry, at func() types.Path) error {
+	t := dst.Type()
+	if e.c != nil && e.c.t == t {
+		v, err := e.c.value()
+		if err != nil {
+			return err
+		}
+		dst.Set(v)
+		return nil
+	}
+	// Named types are left to types so that they behave in exactly the same way.
+	if t.PkgPath() == "" {
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if e.Kind == types.Int {
+				dst.SetInt(e.Int)
+				return nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if e.Kind == types.Uint {
+				dst.SetUint(e.Uint)
+				return nil
+			}
+		case reflect.Float32, reflect.Float64:
+			if e.Kind == types.Float {
+				dst.SetFloat(e.Float)
+				return nil
+			}
+		case reflect.String:
+			if e.Kind == types.String {
+				dst.SetString(string(e.String))
+				return nil
+			}
+		case reflect.Bool:
+			if e.Kind == types.Bool {
+				dst.SetBool(e.Bool)
+				return nil
+			}
+		}
+	}
+	v, err := e.toValue().CastAt(t, at())
+	if err != nil {
+		return err
+	}
+	dst.Set(v)
+	return nil
+}
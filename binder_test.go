@@ -0,0 +1,298 @@
+package watson_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson"
+	"github.com/genkami/watson/pkg/dumper"
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+type Score struct {
+	Name  string
+	Point float64
+}
+
+type Record struct {
+	ID      int64 `watson:"id"`
+	Small   int8
+	Count   uint16
+	Ratio   float32
+	Ok      bool
+	Tags    []string
+	Fixed   [2]int
+	Best    Score
+	Last    *Score
+	LastPtr **Score
+	Scores  []*Score
+	Attrs   map[string]int
+	Groups  map[string][]Score
+	Any     interface{}
+	Dept    *DepartmentName
+	DeptVal DepartmentName
+	Ignored string `watson:"-"`
+}
+
+// buildOps concatenates instructions; each of args is either a vm.Op or a value that is converted by types.ToValue.
+func buildOps(t *testing.T, args ...interface{}) []vm.Op {
+	t.Helper()
+	w := lexer.NewSliceWriter()
+	d := dumper.NewDumper(w)
+	for _, arg := range args {
+		if op, ok := arg.(vm.Op); ok {
+			w.Write(op)
+			continue
+		}
+		v, err := types.ToValue(arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.Dump(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Ops()
+}
+
+func unlexOps(t *testing.T, ops []vm.Op) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	u := lexer.NewUnlexer(buf)
+	err := u.WriteOps(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// assertDecodeIsSameAsBind checks that decoding ops gives the same result as binding the Value built by VM.
+func assertDecodeIsSameAsBind(t *testing.T, ops []vm.Op, newDst func() interface{}) {
+	t.Helper()
+	m := vm.NewVM()
+	err := m.FeedMulti(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := m.Top()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := newDst()
+	wantErr := top.Bind(want)
+
+	got := newDst()
+	gotErr := watson.Unmarshal(unlexOps(t, ops), got)
+	if (wantErr == nil) != (gotErr == nil) || (wantErr != nil && wantErr.Error() != gotErr.Error()) {
+		t.Fatalf("expected error %v but got %v", wantErr, gotErr)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDecodeBindsStructsDirectly(t *testing.T) {
+	ops := buildOps(t, map[string]interface{}{
+		"id":      int64(12345),
+		"small":   int64(300),
+		"count":   uint64(65),
+		"ratio":   1.5,
+		"ok":      true,
+		"tags":    []interface{}{"a", "bc", ""},
+		"fixed":   []interface{}{int64(1)},
+		"best":    map[string]interface{}{"name": "x", "point": 2.5, "unknown": "y"},
+		"last":    map[string]interface{}{"name": "last"},
+		"lastptr": map[string]interface{}{"point": -1.0},
+		"scores":  []interface{}{map[string]interface{}{"name": "s1"}, nil},
+		"attrs":   map[string]interface{}{"a": int64(1), "b": int64(-2)},
+		"groups":  map[string]interface{}{"g": []interface{}{map[string]interface{}{"name": "m"}}},
+		"any":     map[string]interface{}{"k": []interface{}{int64(1), "v", nil}},
+		"dept":    "sales",
+		"deptval": "marketing",
+		"ignored": "ignored",
+		"other":   []interface{}{int64(1)},
+	})
+	assertDecodeIsSameAsBind(t, ops, func() interface{} { return &Record{} })
+	assertDecodeIsSameAsBind(t, ops, func() interface{} { return &map[string]interface{}{} })
+	assertDecodeIsSameAsBind(t, ops, func() interface{} { var v interface{}; return &v })
+	assertDecodeIsSameAsBind(t, ops, func() interface{} { var v *Record; return &v })
+}
+
+func TestDecodeBindsPrimitivesDirectly(t *testing.T) {
+	assertDecodeIsSameAsBind(t, buildOps(t, int64(-42)), func() interface{} { var v int16; return &v })
+	assertDecodeIsSameAsBind(t, buildOps(t, uint64(42)), func() interface{} { var v uint; return &v })
+	assertDecodeIsSameAsBind(t, buildOps(t, 3.25), func() interface{} { var v float32; return &v })
+	assertDecodeIsSameAsBind(t, buildOps(t, "hello"), func() interface{} { var v string; return &v })
+	assertDecodeIsSameAsBind(t, buildOps(t, true), func() interface{} { var v bool; return &v })
+	assertDecodeIsSameAsBind(t, buildOps(t, nil), func() interface{} { var v *int; return &v })
+	assertDecodeIsSameAsBind(t, buildOps(t, "hello"), func() interface{} { return &DepartmentName{} })
+}
+
+func TestDecodeReportsTheSameErrorsAsBind(t *testing.T) {
+	assertDecodeIsSameAsBind(t, buildOps(t, "str"), func() interface{} { var v int; return &v })
+	assertDecodeIsSameAsBind(t, buildOps(t, map[string]interface{}{"id": "str"}), func() interface{} { return &Record{} })
+	assertDecodeIsSameAsBind(t, buildOps(t, map[string]interface{}{"attrs": map[string]interface{}{"a": true}}), func() interface{} { return &Record{} })
+	assertDecodeIsSameAsBind(t, buildOps(t, map[string]interface{}{"tags": []interface{}{"a", int64(1)}}), func() interface{} { return &Record{} })
+	assertDecodeIsSameAsBind(t, buildOps(t, map[string]interface{}{"fixed": []interface{}{int64(1), int64(2), int64(3)}}), func() interface{} { return &Record{} })
+	assertDecodeIsSameAsBind(t, buildOps(t, []interface{}{int64(1)}), func() interface{} { return &Record{} })
+	assertDecodeIsSameAsBind(t, buildOps(t, []interface{}{"a"}), func() interface{} { var v []int; return &v })
+	assertDecodeIsSameAsBind(t, buildOps(t, map[string]interface{}{"groups": map[string]interface{}{"g": []interface{}{map[string]interface{}{"point": "x"}}}}), func() interface{} { return &Record{} })
+}
+
+func TestDecodeReportsWhereBindingFails(t *testing.T) {
+	buf := unlexOps(t, buildOps(t, map[string]interface{}{"scores": []interface{}{nil, map[string]interface{}{"name": int64(1)}}}))
+	var got Record
+	err := watson.Unmarshal(buf, &got)
+	if err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+	want := "can't convert Int to string (at <root>.scores[1].name)"
+	if err.Error() != want {
+		t.Errorf("expected %q but got %q", want, err.Error())
+	}
+}
+
+func TestDecodeIgnoresErrorsOfOverwrittenMembers(t *testing.T) {
+	ops := buildOps(t, vm.Onew, "id", "str", vm.Oadd, "id", int64(1), vm.Oadd)
+	var got Record
+	err := watson.Unmarshal(unlexOps(t, ops), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 1 {
+		t.Errorf("expected 1 but got %d", got.ID)
+	}
+	assertDecodeIsSameAsBind(t, ops, func() interface{} { return &Record{} })
+}
+
+func TestDecodeIgnoresErrorsOfDiscardedValues(t *testing.T) {
+	// The first object is expected to be bound to Record, but the second one is the result.
+	ops := buildOps(t, vm.Onew, "id", "str", vm.Oadd, vm.Onew, "id", int64(2), vm.Oadd)
+	var got Record
+	err := watson.Unmarshal(unlexOps(t, ops), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 2 {
+		t.Errorf("expected 2 but got %d", got.ID)
+	}
+	assertDecodeIsSameAsBind(t, ops, func() interface{} { return &Record{} })
+}
+
+func TestDecodeBindsAValueThatIsNotExpected(t *testing.T) {
+	// The result is a member of an object that is not completed.
+	ops := buildOps(t, vm.Onew, "best", vm.Onew, "name", "x", vm.Oadd)
+	assertDecodeIsSameAsBind(t, ops, func() interface{} { return &Record{} })
+	assertDecodeIsSameAsBind(t, ops, func() interface{} { return &Score{} })
+}
+
+func TestDecodeFallsBackWhenContainersAreMoved(t *testing.T) {
+	ops := buildOps(t,
+		vm.Onew, "id", int64(1), vm.Oadd,
+		"best", vm.Gswp, vm.Gswp,
+		vm.Onew, "name", "x", vm.Oadd, vm.Gdup, vm.Gpop,
+		vm.Oadd,
+		"tags", vm.Anew, "a", vm.Aadd, vm.Oadd,
+	)
+	var got Record
+	err := watson.Unmarshal(unlexOps(t, ops), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Record{ID: 1, Best: Score{Name: "x"}, Tags: []string{"a"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	assertDecodeIsSameAsBind(t, ops, func() interface{} { return &Record{} })
+}
+
+func TestDecodeDoesNotShareDuplicatedStrings(t *testing.T) {
+	// This builds "ab" and "ac" from the same "a".
+	a, b, c := int64('a'), int64('b'), int64('c')
+	ops := buildOps(t, vm.Snew, a, vm.Sadd, vm.Gdup, b, vm.Sadd, vm.Gswp, c, vm.Sadd)
+	var got string
+	err := watson.Unmarshal(unlexOps(t, ops), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "ac" {
+		t.Errorf("expected %q but got %q", "ac", got)
+	}
+	ops = append(ops, vm.Gpop)
+	err = watson.Unmarshal(unlexOps(t, ops), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "ab" {
+		t.Errorf("expected %q but got %q", "ab", got)
+	}
+}
+
+func newScores(n int) []Score {
+	scores := make([]Score, n)
+	for i := range scores {
+		scores[i] = Score{Name: "player", Point: float64(i)}
+	}
+	return scores
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	buf, err := watson.Marshal(newScores(1000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var scores []Score
+		err := watson.Unmarshal(buf, &scores)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUnmarshalViaValue measures the way Unmarshal used to work: building the whole Value and then binding it.
+func BenchmarkUnmarshalViaValue(b *testing.B) {
+	buf, err := watson.Marshal(newScores(1000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var scores []Score
+		m := vm.NewVM()
+		l := lexer.NewLexer(bytes.NewReader(buf))
+		ops := make([]vm.Op, 256)
+		for {
+			n, err := l.ReadOps(ops)
+			if err != nil {
+				break
+			}
+			err = m.FeedMulti(ops[:n])
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		top, err := m.Top()
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = top.Bind(&scores)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package debug
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/genkami/watson/cmd/watson/util"
+	"github.com/genkami/watson/pkg/debugger"
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+const prompt = "(watson) "
+
+type Runner struct {
+	mode      util.Mode
+	stackSize int
+	script    string
+	file      util.Opener
+}
+
+func NewRunner() *Runner {
+	return &Runner{}
+}
+
+func (r *Runner) parseArgs(args []string) {
+	fs := flag.NewFlagSet("watson debug", flag.ExitOnError)
+	fs.Var(&r.mode, "initial-mode", "initial mode of the lexer")
+	fs.IntVar(&r.stackSize, "stack-size", vm.DefaultStackSize, "stack size of the Watson VM")
+	fs.StringVar(&r.script, "script", "", "file that contains commands to execute instead of the standard input")
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err.Error())
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	files := fs.Args()
+	if len(files) != 1 {
+		fmt.Fprintf(os.Stderr, "expected exactly one file to debug\n")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	r.file = util.NewFileOpener(files[0], os.O_RDONLY, 0)
+}
+
+func (r *Runner) Run(args []string) {
+	r.parseArgs(args)
+	d, err := r.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't load %s: %s\n", r.file.Name(), err.Error())
+		os.Exit(1)
+	}
+	var opts []debugger.SessionOption
+	var commands io.Reader = os.Stdin
+	if r.script != "" {
+		f, err := os.Open(r.script)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "can't open %s: %s\n", r.script, err.Error())
+			os.Exit(1)
+		}
+		defer f.Close()
+		commands = f
+	} else if util.IsTerminal(os.Stdin) {
+		opts = append(opts, debugger.WithPrompt(prompt))
+	}
+	err = debugger.NewSession(d, commands, os.Stdout, opts...).Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func (r *Runner) load() (*debugger.Debugger, error) {
+	file, err := r.file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	lex := lexer.NewLexer(file, lexer.WithFileName(r.file.Name()), lexer.WithInitialLexerMode(lexer.Mode(r.mode)))
+	return debugger.NewDebugger(lex, debugger.WithVMOptions(vm.WithStackSize(r.stackSize)))
+}
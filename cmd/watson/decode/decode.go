@@ -1,12 +1,14 @@
 package decode
 
 import (
+	"bufio"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 
+	"github.com/genkami/watson"
 	"github.com/genkami/watson/cmd/watson/util"
 	"github.com/genkami/watson/pkg/converter/cbor"
 	"github.com/genkami/watson/pkg/converter/json"
@@ -19,10 +21,19 @@ import (
 
 type Runner struct {
 	outType   util.Type
-	mode      util.Mode
+	mode      util.AutoMode
 	files     []string
 	m         *vm.VM
 	stackSize int
+	stream    bool
+	parallel  int
+	tracer    *vm.Tracer
+
+	maxInstructions int
+	maxAllocation   int
+	maxStringLength int
+	maxContainerLen int
+	maxDepth        int
 }
 
 func NewRunner() *Runner {
@@ -32,8 +43,16 @@ func NewRunner() *Runner {
 func (r *Runner) parseArgs(args []string) {
 	fs := flag.NewFlagSet("watson decode", flag.ExitOnError)
 	fs.Var(&r.outType, "t", "input type")
-	fs.Var(&r.mode, "initial-mode", "initial mode of the lexer")
+	fs.Var(&r.mode, "initial-mode", "initial mode of the lexer (A, S, or auto)")
 	fs.IntVar(&r.stackSize, "stack-size", vm.DefaultStackSize, "stack size of the Watson VM")
+	fs.IntVar(&r.maxInstructions, "max-instructions", 0, "maximum number of instructions to execute (0 means unlimited)")
+	fs.IntVar(&r.maxAllocation, "max-allocation", 0, "maximum number of bytes to allocate (0 means unlimited)")
+	fs.IntVar(&r.maxStringLength, "max-string-length", 0, "maximum length of strings (0 means unlimited)")
+	fs.IntVar(&r.maxContainerLen, "max-container-size", 0, "maximum size of arrays and objects (0 means unlimited)")
+	fs.IntVar(&r.maxDepth, "max-depth", 0, "maximum nesting depth of values (0 means unlimited)")
+	fs.BoolVar(&r.stream, "stream", false, "decode a stream of newline-delimited values")
+	trace := fs.Bool("trace", false, "write each executed instruction to the standard error")
+	fs.IntVar(&r.parallel, "parallel", 0, "number of goroutines that lex the input (0 means lexing on a single goroutine)")
 	err := fs.Parse(args)
 	if errors.Is(err, flag.ErrHelp) {
 		os.Exit(0)
@@ -42,14 +61,61 @@ func (r *Runner) parseArgs(args []string) {
 		fs.PrintDefaults()
 		os.Exit(1)
 	}
-	r.m = vm.NewVM(vm.WithStackSize(r.stackSize))
+	if r.parallel > 0 && (r.stream || r.mode.Auto) {
+		fmt.Fprintf(os.Stderr, "-parallel can't be used with -stream or -initial-mode=auto\n")
+		os.Exit(1)
+	}
+	if *trace {
+		if r.mode.Auto {
+			fmt.Fprintf(os.Stderr, "-trace can't be used with -initial-mode=auto\n")
+			os.Exit(1)
+		}
+		r.tracer = vm.NewTracer(os.Stderr)
+	}
+	r.m = r.newVM()
 	r.files = fs.Args()
 }
 
+func (r *Runner) newVM() *vm.VM {
+	return vm.NewVM(r.vmOptions()...)
+}
+
+func (r *Runner) vmOptions() []vm.VMOption {
+	opts := []vm.VMOption{
+		vm.WithStackSize(r.stackSize),
+		vm.WithMaxInstructions(r.maxInstructions),
+		vm.WithMaxAllocation(r.maxAllocation),
+		vm.WithMaxStringLength(r.maxStringLength),
+		vm.WithMaxContainerSize(r.maxContainerLen),
+		vm.WithMaxDepth(r.maxDepth),
+	}
+	if r.tracer != nil {
+		opts = append(opts, vm.WithObserver(r.tracer))
+	}
+	return opts
+}
+
+// feed executes tok on m, telling the tracer where it is.
+func (r *Runner) feed(m *vm.VM, tok *lexer.Token) error {
+	if r.tracer != nil {
+		r.tracer.SetPosition(tok.FileName, tok.Line, tok.Column)
+	}
+	return m.Feed(tok.Op)
+}
+
 func (r *Runner) Run(args []string) {
 	var err error
 	r.parseArgs(args)
 
+	if r.stream {
+		err = r.decodeAllStreams()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	err = r.parseAllFiles()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "parse error: %s\n", err)
@@ -81,57 +147,206 @@ func (r *Runner) openers() []util.Opener {
 	return openers
 }
 
-func (rn *Runner) buildLexer(r io.Reader, name string) *lexer.Lexer {
-	return lexer.NewLexer(
-		r,
+func (rn *Runner) buildLexer(r io.Reader, name string, opts ...lexer.LexerOption) *lexer.Lexer {
+	opts = append([]lexer.LexerOption{
 		lexer.WithFileName(name),
-		lexer.WithInitialLexerMode(lexer.Mode(rn.mode)),
-	)
+		lexer.WithInitialLexerMode(lexer.Mode(rn.mode.Mode)),
+	}, opts...)
+	return lexer.NewLexer(r, opts...)
 }
 
-type parseError struct {
-	tok *lexer.Token
-	err error
+// tokenReader is implemented by both lexer.Lexer and lexer.ParallelLexer.
+type tokenReader interface {
+	ReadTokens(toks []lexer.Token) (int, error)
+	Mode() lexer.Mode
 }
 
-func (p *parseError) Error() string {
-	return fmt.Sprintf("error %+v\n at %#v line %d, column %d\n",
-		p.err, p.tok.FileName, p.tok.Line+1, p.tok.Column+1)
+func (rn *Runner) buildTokenReader(r io.Reader, name string) tokenReader {
+	if rn.parallel <= 0 {
+		return rn.buildLexer(r, name)
+	}
+	return lexer.NewParallelLexer(
+		r,
+		lexer.WithFileName(name),
+		lexer.WithInitialLexerMode(lexer.Mode(rn.mode.Mode)),
+		lexer.WithParallelism(rn.parallel),
+	)
 }
 
 func (r *Runner) parseAllFiles() error {
+	if r.mode.Auto {
+		return r.detectAllFiles()
+	}
 	for _, o := range r.openers() {
 		file, err := o.Open()
 		if err != nil {
 			return err
 		}
-		lex := r.buildLexer(file, o.Name())
+		lex := r.buildTokenReader(file, o.Name())
 		err = r.parseWatson(lex)
+		if pl, ok := lex.(*lexer.ParallelLexer); ok {
+			pl.Close()
+		}
 		file.Close()
 		if err != nil {
 			return err
 		}
-		r.mode = util.Mode(lex.Mode())
+		r.mode.Mode = util.Mode(lex.Mode())
 	}
 	return nil
 }
 
-func (r *Runner) parseWatson(lex *lexer.Lexer) error {
+// detectAllFiles does the same thing as parseAllFiles, but it executes them from both initial modes and uses the VM of the one that is valid.
+func (r *Runner) detectAllFiles() error {
+	d := watson.NewModeDetector(r.vmOptions()...)
+	for _, o := range r.openers() {
+		file, err := o.Open()
+		if err != nil {
+			return err
+		}
+		d.SetPosition(o.Name(), 0, 0)
+		_, err = io.Copy(d, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	_, m, err := d.Result()
+	if err != nil {
+		return err
+	}
+	r.m = m
+	return nil
+}
+
+func (r *Runner) parseWatson(lex tokenReader) error {
+	toks := make([]lexer.Token, 256)
 	for {
-		tok, err := lex.Next()
+		n, err := lex.ReadTokens(toks)
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return &parseError{tok: tok, err: err}
+			return err
+		}
+		for i := range toks[:n] {
+			tok := &toks[i]
+			err = r.feed(r.m, tok)
+			if err != nil {
+				return watson.NewDecodeError(tok, r.m.Stack(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeAllStreams decodes each newline-delimited value of all files and writes them to the standard output one by one.
+// Unlike parseAllFiles, every value is executed by a fresh VM and starts with the initial mode.
+func (r *Runner) decodeAllStreams() error {
+	var write func(*types.Value) error
+	if r.outType == util.Yaml {
+		sd := yaml.NewStreamDecoder(os.Stdout)
+		defer sd.Close()
+		write = sd.Decode
+	} else {
+		write = func(v *types.Value) error {
+			return r.decode(os.Stdout, v)
 		}
-		err = r.m.Feed(tok.Op)
+	}
+	for _, o := range r.openers() {
+		file, err := o.Open()
 		if err != nil {
-			return &parseError{tok: tok, err: err}
+			return err
+		}
+		if r.mode.Auto {
+			err = r.detectStream(file, o.Name(), write)
+		} else {
+			lex := r.buildLexer(file, o.Name(), lexer.WithDelimiter(lexer.DefaultDelimiter))
+			err = r.decodeStream(lex, write)
+		}
+		file.Close()
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+func (r *Runner) decodeStream(lex *lexer.Lexer, write func(*types.Value) error) error {
+	toks := make([]lexer.Token, 256)
+	m := r.newVM()
+	empty := true
+	for {
+		n, err := lex.ReadTokens(toks)
+		if err == lexer.ErrEndOfRecord || err == io.EOF {
+			if !empty {
+				v, topErr := m.Top()
+				if topErr != nil {
+					return topErr
+				}
+				if werr := write(v); werr != nil {
+					return fmt.Errorf("can't write Watson: %w", werr)
+				}
+				m = r.newVM()
+				empty = true
+			}
+			if err == io.EOF {
+				return nil
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		empty = false
+		for i := range toks[:n] {
+			tok := &toks[i]
+			err = r.feed(m, tok)
+			if err != nil {
+				return fmt.Errorf("parse error: %w", watson.NewDecodeError(tok, m.Stack(), err))
+			}
+		}
+	}
+}
+
+// detectStream does the same thing as decodeStream, but it detects the initial mode of each value.
+func (r *Runner) detectStream(file io.Reader, name string, write func(*types.Value) error) error {
+	br := bufio.NewReader(file)
+	for line := 0; ; line++ {
+		record, err := br.ReadBytes(lexer.DefaultDelimiter)
+		if hasOps(record) {
+			d := watson.NewModeDetector(r.vmOptions()...)
+			d.SetPosition(name, line, 0)
+			// ModeDetector never fails to write.
+			_, _ = d.Write(record)
+			_, m, derr := d.Result()
+			if derr != nil {
+				return fmt.Errorf("parse error: %w", derr)
+			}
+			v, derr := m.Top()
+			if derr != nil {
+				return derr
+			}
+			if werr := write(v); werr != nil {
+				return fmt.Errorf("can't write Watson: %w", werr)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// hasOps reports whether record contains any byte that corresponds to an Op in either mode.
+func hasOps(record []byte) bool {
+	for _, b := range record {
+		if !lexer.IsDelimiter(b) {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Runner) decode(w io.Writer, v *types.Value) error {
 	switch r.outType {
 	case util.Yaml:
@@ -19,9 +19,19 @@ import (
 )
 
 type Runner struct {
-	inType util.Type
-	mode   util.Mode
-	opener util.Opener
+	inType   util.Type
+	mode     util.Mode
+	opener   util.Opener
+	stream   bool
+	optimize bool
+	dedup    bool
+	compact  bool
+	themeSrc string
+	theme    *prettifier.Theme
+	wrap     int
+	breaks   bool
+	annotate bool
+	parallel int
 }
 
 func NewRunner() *Runner {
@@ -32,6 +42,15 @@ func (r *Runner) parseArgs(args []string) {
 	fs := flag.NewFlagSet("watson encode", flag.ExitOnError)
 	fs.Var(&r.inType, "t", "input type")
 	fs.Var(&r.mode, "initial-mode", "initial mode of the unlexer")
+	fs.BoolVar(&r.stream, "stream", false, "encode a stream of values into newline-delimited Watson")
+	fs.BoolVar(&r.optimize, "optimize", false, "encode numbers in fewer characters")
+	fs.BoolVar(&r.dedup, "dedup", false, "build repeated values only once")
+	fs.BoolVar(&r.compact, "compact-strings", false, "encode strings in fewer characters")
+	fs.StringVar(&r.themeSrc, "theme", "", "JSON file that defines how the output is decorated")
+	fs.IntVar(&r.wrap, "wrap", 0, "maximum number of characters in each line (0 means unlimited)")
+	fs.BoolVar(&r.breaks, "break-top-level", false, "start a new line after each member of the outermost object or array")
+	fs.BoolVar(&r.annotate, "annotate", false, "write comments that tell the path and the literal of each value")
+	fs.IntVar(&r.parallel, "parallel", 0, "number of goroutines that dump the members of the outermost object or array (0 means dumping on a single goroutine)")
 	err := fs.Parse(args)
 	if errors.Is(err, flag.ErrHelp) {
 		os.Exit(0)
@@ -40,6 +59,11 @@ func (r *Runner) parseArgs(args []string) {
 		fs.PrintDefaults()
 		os.Exit(1)
 	}
+	if r.stream && (r.wrap > 0 || r.breaks || r.annotate) {
+		fmt.Fprintf(os.Stderr, "can't break lines with -stream since each line is a separate value")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
 	files := fs.Args()
 	if len(files) == 0 {
 		r.opener = util.NewRWCOpener("<stdin>", os.Stdin)
@@ -55,12 +79,27 @@ func (r *Runner) parseArgs(args []string) {
 func (r *Runner) Run(args []string) {
 	var err error
 	r.parseArgs(args)
+	if r.themeSrc != "" {
+		r.theme, err = loadTheme(r.themeSrc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "can't load theme %s: %s\n", r.themeSrc, err.Error())
+			os.Exit(1)
+		}
+	}
 	file, err := r.opener.Open()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "can't open %s: %s\n", r.opener.Name(), err.Error())
 		os.Exit(1)
 	}
 	defer file.Close()
+	if r.stream {
+		err = r.dumpStream(os.Stdout, file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error encoding %s: %s\n", r.opener.Name(), err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 	val, err := r.encode(file)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error loading %s: %s\n", r.opener.Name(), err.Error())
@@ -73,6 +112,15 @@ func (r *Runner) Run(args []string) {
 	}
 }
 
+func loadTheme(path string) (*prettifier.Theme, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return prettifier.LoadTheme(file)
+}
+
 func (rn *Runner) encode(r io.Reader) (*types.Value, error) {
 	switch rn.inType {
 	case util.Yaml:
@@ -88,8 +136,75 @@ func (rn *Runner) encode(r io.Reader) (*types.Value, error) {
 	}
 }
 
+func (rn *Runner) encodeStream(r io.Reader, f func(*types.Value) error) error {
+	switch rn.inType {
+	case util.Yaml:
+		return yaml.EncodeStream(r, f)
+	case util.Json:
+		return json.EncodeStream(r, f)
+	case util.Msgpack:
+		return msgpack.EncodeStream(r, f)
+	case util.Cbor:
+		return cbor.EncodeStream(r, f)
+	default:
+		panic("unknown input type")
+	}
+}
+
+func (r *Runner) dumperOptions() []dumper.DumperOption {
+	var opts []dumper.DumperOption
+	if r.optimize {
+		opts = append(opts, dumper.WithOptimizedNumbers())
+	}
+	if r.dedup {
+		opts = append(opts, dumper.WithDeduplication())
+	}
+	if r.compact {
+		opts = append(opts, dumper.WithCompactStrings())
+	}
+	if r.annotate {
+		opts = append(opts, dumper.WithAnnotations())
+	}
+	if r.parallel > 0 {
+		opts = append(opts, dumper.WithParallelism(r.parallel))
+	}
+	return opts
+}
+
+func (r *Runner) newPrettifier(w lexer.OpWriter) *prettifier.Prettifier {
+	if r.theme != nil {
+		return prettifier.NewPrettifier(w, prettifier.WithTheme(r.theme))
+	}
+	return prettifier.NewPrettifier(w)
+}
+
+// dumpStream writes each value in r as a separate line of Watson.
+func (rn *Runner) dumpStream(w io.Writer, r io.Reader) error {
+	unl := lexer.NewUnlexer(w, lexer.WithInitialUnlexerMode(lexer.Mode(rn.mode)))
+	d := dumper.NewDumper(rn.newPrettifier(unl), rn.dumperOptions()...)
+	err := rn.encodeStream(r, func(v *types.Value) error {
+		if err := d.Dump(v); err != nil {
+			return err
+		}
+		return unl.WriteDelimiter(lexer.DefaultDelimiter)
+	})
+	if err != nil {
+		return err
+	}
+	return unl.Flush()
+}
+
 func (r *Runner) dump(w io.Writer, v *types.Value) error {
-	unl := prettifier.NewPrettifier(lexer.NewUnlexer(w, lexer.WithInitialUnlexerMode(lexer.Mode(r.mode))))
-	d := dumper.NewDumper(unl)
-	return d.Dump(v)
+	unl := lexer.NewUnlexer(w, lexer.WithInitialUnlexerMode(lexer.Mode(r.mode)))
+	opts := []lexer.LayoutOption{lexer.WithWrapColumn(r.wrap)}
+	if r.breaks {
+		opts = append(opts, lexer.WithTopLevelBreaks())
+	}
+	layout := lexer.NewLayoutWriter(unl, opts...)
+	d := dumper.NewDumper(r.newPrettifier(layout), r.dumperOptions()...)
+	err := d.Dump(v)
+	if err != nil {
+		return err
+	}
+	return layout.Flush()
 }
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/genkami/watson/cmd/watson/debug"
 	"github.com/genkami/watson/cmd/watson/decode"
 	"github.com/genkami/watson/cmd/watson/encode"
+	"github.com/genkami/watson/cmd/watson/minify"
+	"github.com/genkami/watson/cmd/watson/repl"
+	"github.com/genkami/watson/cmd/watson/transcode"
 )
 
 type Runner interface {
@@ -13,8 +17,12 @@ type Runner interface {
 }
 
 var allCmds = map[string]Runner{
-	"decode": decode.NewRunner(),
-	"encode": encode.NewRunner(),
+	"debug":     debug.NewRunner(),
+	"decode":    decode.NewRunner(),
+	"encode":    encode.NewRunner(),
+	"minify":    minify.NewRunner(),
+	"repl":      repl.NewRunner(),
+	"transcode": transcode.NewRunner(),
 }
 
 func main() {
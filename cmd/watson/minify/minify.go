@@ -0,0 +1,88 @@
+package minify
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/genkami/watson/cmd/watson/util"
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/optimizer"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+type Runner struct {
+	mode   util.Mode
+	opener util.Opener
+}
+
+func NewRunner() *Runner {
+	return &Runner{}
+}
+
+func (r *Runner) parseArgs(args []string) {
+	fs := flag.NewFlagSet("watson minify", flag.ExitOnError)
+	fs.Var(&r.mode, "initial-mode", "initial mode of the lexer and the unlexer")
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err.Error())
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	files := fs.Args()
+	if len(files) == 0 {
+		r.opener = util.NewRWCOpener("<stdin>", os.Stdin)
+	} else if len(files) == 1 {
+		r.opener = util.NewFileOpener(files[0], os.O_RDONLY, 0)
+	} else {
+		fmt.Fprintf(os.Stderr, "too many arguments")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+}
+
+func (r *Runner) Run(args []string) {
+	r.parseArgs(args)
+	file, err := r.opener.Open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open %s: %s\n", r.opener.Name(), err.Error())
+		os.Exit(1)
+	}
+	defer file.Close()
+	err = r.minify(os.Stdout, file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error minifying %s: %s\n", r.opener.Name(), err.Error())
+		os.Exit(1)
+	}
+}
+
+// minify reads Watson from r and writes a shorter one that has the same effect to w.
+// The output starts with the same mode as the input.
+func (rn *Runner) minify(w io.Writer, r io.Reader) error {
+	mode := lexer.Mode(rn.mode)
+	lex := lexer.NewLexer(r, lexer.WithFileName(rn.opener.Name()), lexer.WithInitialLexerMode(mode))
+	unl := lexer.NewUnlexer(w, lexer.WithInitialUnlexerMode(mode))
+	o := optimizer.NewOptimizer(unl)
+	ops := make([]vm.Op, 256)
+	for {
+		n, err := lex.ReadOps(ops)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		err = o.WriteOps(ops[:n])
+		if err != nil {
+			return err
+		}
+	}
+	err := o.Flush()
+	if err != nil {
+		return err
+	}
+	return unl.Flush()
+}
@@ -0,0 +1,57 @@
+package repl
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/genkami/watson/cmd/watson/util"
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/repl"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+type Runner struct {
+	mode      util.Mode
+	stackSize int
+}
+
+func NewRunner() *Runner {
+	return &Runner{}
+}
+
+func (r *Runner) parseArgs(args []string) {
+	fs := flag.NewFlagSet("watson repl", flag.ExitOnError)
+	fs.Var(&r.mode, "initial-mode", "initial mode of the lexer")
+	fs.IntVar(&r.stackSize, "stack-size", vm.DefaultStackSize, "stack size of the Watson VM")
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err.Error())
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "too many arguments\n")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+}
+
+func (r *Runner) Run(args []string) {
+	r.parseArgs(args)
+	opts := []repl.ReplOption{
+		repl.WithInitialMode(lexer.Mode(r.mode)),
+		repl.WithVMOptions(vm.WithStackSize(r.stackSize)),
+	}
+	if util.IsTerminal(os.Stdin) {
+		opts = append(opts, repl.WithPrompt())
+	}
+	err := repl.NewRepl(os.Stdin, os.Stdout, opts...).Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,71 @@
+package transcode
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/genkami/watson/cmd/watson/util"
+	"github.com/genkami/watson/pkg/lexer"
+)
+
+type Runner struct {
+	from   util.Mode
+	to     util.Mode
+	relex  bool
+	stream bool
+	opener util.Opener
+}
+
+func NewRunner() *Runner {
+	return &Runner{}
+}
+
+func (r *Runner) parseArgs(args []string) {
+	fs := flag.NewFlagSet("watson transcode", flag.ExitOnError)
+	fs.Var(&r.from, "from", "initial mode of the input")
+	fs.Var(&r.to, "to", "initial mode of the output")
+	fs.BoolVar(&r.relex, "relex", false, "rewrite each character instead of adding a prefix")
+	fs.BoolVar(&r.stream, "stream", false, "transcode each line as a separate value")
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err.Error())
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	files := fs.Args()
+	if len(files) == 0 {
+		r.opener = util.NewRWCOpener("<stdin>", os.Stdin)
+	} else if len(files) == 1 {
+		r.opener = util.NewFileOpener(files[0], os.O_RDONLY, 0)
+	} else {
+		fmt.Fprintf(os.Stderr, "too many arguments")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+}
+
+func (r *Runner) Run(args []string) {
+	r.parseArgs(args)
+	file, err := r.opener.Open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open %s: %s\n", r.opener.Name(), err.Error())
+		os.Exit(1)
+	}
+	defer file.Close()
+	var opts []lexer.TranscodeOption
+	if r.relex {
+		opts = append(opts, lexer.WithRelexing())
+	}
+	if r.stream {
+		opts = append(opts, lexer.WithTranscodeDelimiter(lexer.DefaultDelimiter))
+	}
+	err = lexer.Transcode(os.Stdout, file, lexer.Mode(r.from), lexer.Mode(r.to), opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error transcoding %s: %s\n", r.opener.Name(), err.Error())
+		os.Exit(1)
+	}
+}
@@ -44,6 +44,32 @@ func (m *Mode) Set(s string) error {
 var assertModeIsValue = Mode(0)
 var _ flag.Value = &assertModeIsValue
 
+const modeNameAuto = "auto"
+
+// AutoMode is a Mode that can also be "auto", which means that the mode should be detected from the input.
+type AutoMode struct {
+	Mode Mode
+	Auto bool
+}
+
+func (m *AutoMode) String() string {
+	if m.Auto {
+		return modeNameAuto
+	}
+	return m.Mode.String()
+}
+
+func (m *AutoMode) Set(s string) error {
+	if s == modeNameAuto {
+		m.Auto = true
+		return nil
+	}
+	m.Auto = false
+	return m.Mode.Set(s)
+}
+
+var _ flag.Value = &AutoMode{}
+
 type Type int
 
 const (
@@ -146,3 +172,12 @@ func (o *FileOpener) Open() (io.ReadWriteCloser, error) {
 }
 
 var _ Opener = &FileOpener{}
+
+// IsTerminal reports whether f is a terminal, in which case its input is typed by a user.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,146 @@
+package watson
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// ErrAmbiguousMode is returned by ModeDetector when the input is valid in both modes.
+var ErrAmbiguousMode = errors.New("the input is valid in both modes")
+
+// ModeError is returned by ModeDetector when the input is valid in neither mode.
+type ModeError struct {
+	Errs [2]error // the reason why the input is invalid, indexed by the initial mode
+}
+
+func (e *ModeError) Error() string {
+	return fmt.Sprintf("the input is valid in neither mode (A: %s, S: %s)", e.Errs[lexer.A], e.Errs[lexer.S])
+}
+
+// ModeDetector finds out the initial mode in which Watson Representation is written.
+//
+// It executes its input from both modes in lock-step, each on its own VM, without keeping the input in memory.
+// The input is regarded as valid in a mode if it is executed without errors and leaves exactly one value on the stack.
+//
+// ModeDetector is an io.Writer, so the input can be written by io.Copy.
+type ModeDetector struct {
+	runs     [2]*modeRun
+	fileName string
+	line     int
+	column   int
+	toks     []lexer.Token
+}
+
+// modeRun is the execution of the input from one of the initial modes.
+type modeRun struct {
+	src bytes.Reader // the chunk that is being written
+	l   *lexer.Lexer // this is reused for every chunk, keeping its mode
+	vm  *vm.VM
+	err error
+}
+
+// NewModeDetector returns a new ModeDetector whose VMs are configured by opts.
+func NewModeDetector(opts ...vm.VMOption) *ModeDetector {
+	d := &ModeDetector{toks: make([]lexer.Token, tokenBufferSize)}
+	for _, mode := range []lexer.Mode{lexer.A, lexer.S} {
+		run := &modeRun{vm: vm.NewVM(opts...)}
+		run.l = lexer.NewLexer(&run.src, lexer.WithInitialLexerMode(mode))
+		d.runs[mode] = run
+	}
+	return d
+}
+
+// SetPosition sets the position of the next byte that is written, which is used to report errors.
+func (d *ModeDetector) SetPosition(fileName string, line, column int) {
+	d.fileName = fileName
+	d.line = line
+	d.column = column
+}
+
+// Write executes p in both modes. It never fails; errors are reported by Result.
+func (d *ModeDetector) Write(p []byte) (int, error) {
+	for _, run := range d.runs {
+		if run.err != nil {
+			continue
+		}
+		run.src.Reset(p)
+		run.l.Reset(&run.src)
+		run.err = d.execute(run)
+	}
+	for _, b := range p {
+		if b == '\n' {
+			d.line++
+			d.column = 0
+		} else {
+			d.column++
+		}
+	}
+	return len(p), nil
+}
+
+func (d *ModeDetector) execute(run *modeRun) error {
+	for {
+		n, err := run.l.ReadTokens(d.toks)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for i := range d.toks[:n] {
+			tok := &d.toks[i]
+			err = run.vm.Feed(tok.Op)
+			if err != nil {
+				// The lexer does not know where the chunk starts.
+				if tok.Line == 0 {
+					tok.Column += d.column
+				}
+				tok.Line += d.line
+				tok.FileName = d.fileName
+				return NewDecodeError(tok, run.vm.Stack(), err)
+			}
+		}
+	}
+}
+
+// Result returns the initial mode in which the input is valid and the VM that has executed the input from that mode.
+//
+// If the input is valid in both modes, it returns ErrAmbiguousMode. If the input is valid in neither mode, it returns *ModeError.
+func (d *ModeDetector) Result() (lexer.Mode, *vm.VM, error) {
+	var valid []lexer.Mode
+	var errs [2]error
+	for mode, run := range d.runs {
+		errs[mode] = run.err
+		if errs[mode] == nil {
+			if n := len(run.vm.Stack()); n != 1 {
+				errs[mode] = fmt.Errorf("expected exactly one value on the stack but got %d", n)
+			}
+		}
+		if errs[mode] == nil {
+			valid = append(valid, lexer.Mode(mode))
+		}
+	}
+	switch len(valid) {
+	case 1:
+		return valid[0], d.runs[valid[0]].vm, nil
+	case 2:
+		return lexer.A, nil, ErrAmbiguousMode
+	default:
+		return lexer.A, nil, &ModeError{Errs: errs}
+	}
+}
+
+// DetectInitialMode reads Watson Representation from r and returns the initial mode in which it is written, in the same way as ModeDetector.
+func DetectInitialMode(r io.Reader, opts ...vm.VMOption) (lexer.Mode, error) {
+	d := NewModeDetector(opts...)
+	_, err := io.Copy(d, r)
+	if err != nil {
+		return lexer.A, err
+	}
+	mode, _, err := d.Result()
+	return mode, err
+}
@@ -0,0 +1,136 @@
+package watson_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson"
+	"github.com/genkami/watson/pkg/dumper"
+	"github.com/genkami/watson/pkg/lexer"
+)
+
+func TestModeDetectorDetectsInitialMode(t *testing.T) {
+	want := map[string]interface{}{"name": "nginx", "replicas": int64(3), "labels": []interface{}{"a", "b"}}
+	for _, mode := range []lexer.Mode{lexer.A, lexer.S} {
+		buf := bytes.NewBuffer(nil)
+		u := lexer.NewUnlexer(buf, lexer.WithInitialUnlexerMode(mode))
+		err := dumper.NewDumper(u).DumpGo(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = u.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d := watson.NewModeDetector()
+		_, err = d.Write(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, m, err := d.Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != mode {
+			t.Errorf("expected %d but got %d", mode, got)
+		}
+		top, err := m.Top()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v interface{}
+		err = top.Bind(&v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, v); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+
+		// The result does not depend on how the input is split.
+		got, err = watson.DetectInitialMode(iotest.OneByteReader(bytes.NewReader(buf.Bytes())))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != mode {
+			t.Errorf("expected %d but got %d", mode, got)
+		}
+	}
+}
+
+func TestModeDetectorReportsAmbiguity(t *testing.T) {
+	// "B" is Inew in mode A and "S" is Inew in mode S, and each of them is ignored in the other mode.
+	_, err := watson.DetectInitialMode(strings.NewReader("BS"))
+	if !errors.Is(err, watson.ErrAmbiguousMode) {
+		t.Errorf("expected ErrAmbiguousMode but got %v", err)
+	}
+}
+
+func TestModeDetectorReportsErrorsOfBothModes(t *testing.T) {
+	_, err := watson.DetectInitialMode(iotest.OneByteReader(strings.NewReader("z\nBu a")))
+	var modeErr *watson.ModeError
+	if !errors.As(err, &modeErr) {
+		t.Fatalf("expected ModeError but got %v", err)
+	}
+	var decErr *watson.DecodeError
+	if !errors.As(modeErr.Errs[lexer.A], &decErr) {
+		t.Fatalf("expected DecodeError but got %v", modeErr.Errs[lexer.A])
+	}
+	if decErr.Line != 1 || decErr.Column != 3 {
+		t.Errorf("expected 1:3 but got %d:%d", decErr.Line, decErr.Column)
+	}
+	if modeErr.Errs[lexer.S] == nil {
+		t.Errorf("expected error but got nil")
+	}
+}
+
+func TestModeDetectorRequiresExactlyOneValue(t *testing.T) {
+	_, err := watson.DetectInitialMode(strings.NewReader("BB"))
+	var modeErr *watson.ModeError
+	if !errors.As(err, &modeErr) {
+		t.Fatalf("expected ModeError but got %v", err)
+	}
+	_, err = watson.DetectInitialMode(strings.NewReader(""))
+	if !errors.As(err, &modeErr) {
+		t.Fatalf("expected ModeError but got %v", err)
+	}
+}
+
+func TestModeDetectorReportsTheFileNameSetAfterItIsCreated(t *testing.T) {
+	d := watson.NewModeDetector()
+	d.SetPosition("test.watson", 2, 0)
+	_, err := d.Write([]byte("Bu a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = d.Result()
+	var modeErr *watson.ModeError
+	if !errors.As(err, &modeErr) {
+		t.Fatalf("expected ModeError but got %v", err)
+	}
+	var decErr *watson.DecodeError
+	if !errors.As(modeErr.Errs[lexer.A], &decErr) {
+		t.Fatalf("expected DecodeError but got %v", modeErr.Errs[lexer.A])
+	}
+	if decErr.FileName != "test.watson" || decErr.Line != 2 || decErr.Column != 3 {
+		t.Errorf("expected test.watson:2:3 but got %s:%d:%d", decErr.FileName, decErr.Line, decErr.Column)
+	}
+}
+
+func TestModeDetectorDoesNotAllocateForEachWrite(t *testing.T) {
+	d := watson.NewModeDetector()
+	// Spaces correspond to Ops in neither mode, so only lexing costs.
+	p := []byte(" ")
+	allocs := testing.AllocsPerRun(100, func() {
+		d.Write(p)
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations but got %v", allocs)
+	}
+}
@@ -0,0 +1,75 @@
+package watson
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// maxStackSummary is the number of values that DecodeError keeps from the top of the stack.
+const maxStackSummary = 3
+
+// DecodeError is an error that occurs while executing an instruction read by Decoder.
+//
+// The underlying error, usually one of the errors defined in watson/pkg/vm, can be examined by using errors.Is and errors.As.
+type DecodeError struct {
+	FileName string // the name of the file in which the instruction appeared
+	Line     int    // the line number of the instruction (zero-origin)
+	Column   int    // the column number of the instruction (zero-origin)
+	Op       vm.Op  // the instruction that failed
+
+	// Expected and Actual are the kinds of the operand that the instruction expected and the one that was actually on the stack.
+	// They are meaningful only if the error is vm.ErrTypeMismatch.
+	Expected types.Kind
+	Actual   types.Kind
+
+	// Stack contains at most a few values from the top of the stack at the time the instruction was executed, the topmost one first.
+	Stack []*types.Value
+
+	Err error // the underlying error
+}
+
+// NewDecodeError creates a new DecodeError that reports the failure of tok.
+// stack is the contents of the stack from the bottom to the top, as returned by `vm.VM.Stack`.
+func NewDecodeError(tok *lexer.Token, stack []*types.Value, err error) *DecodeError {
+	e := &DecodeError{
+		FileName: tok.FileName,
+		Line:     tok.Line,
+		Column:   tok.Column,
+		Op:       tok.Op,
+		Err:      err,
+	}
+	var mismatch *vm.TypeMismatchError
+	if errors.As(err, &mismatch) {
+		e.Expected = mismatch.Expected
+		e.Actual = mismatch.Actual
+	}
+	for i := len(stack) - 1; i >= 0 && len(e.Stack) < maxStackSummary; i-- {
+		e.Stack = append(e.Stack, stack[i])
+	}
+	return e
+}
+
+func (e *DecodeError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%#v: %s", e.Op, e.Err.Error())
+	if e.FileName != "" {
+		fmt.Fprintf(&b, " at %s:%d:%d", e.FileName, e.Line+1, e.Column+1)
+	} else {
+		fmt.Fprintf(&b, " at line %d, column %d", e.Line+1, e.Column+1)
+	}
+	summaries := make([]string, 0, len(e.Stack))
+	for _, v := range e.Stack {
+		summaries = append(summaries, v.Summary())
+	}
+	fmt.Fprintf(&b, " (stack: [%s])", strings.Join(summaries, ", "))
+	return b.String()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,82 @@
+package watson_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+func TestDecodeReportsThePositionOfTypeMismatch(t *testing.T) {
+	// "z" pushes false, "Bu" pushes 1, and "a" (Iadd) fails because false is not an Int.
+	src := "z\nBu a"
+	var v interface{}
+	err := watson.Unmarshal([]byte(src), &v)
+	if !errors.Is(err, vm.ErrTypeMismatch) {
+		t.Fatalf("expected ErrTypeMismatch but got %v", err)
+	}
+	var decErr *watson.DecodeError
+	if !errors.As(err, &decErr) {
+		t.Fatalf("expected DecodeError but got %v", err)
+	}
+
+	want := &watson.DecodeError{
+		Line:     1,
+		Column:   3,
+		Op:       vm.Iadd,
+		Expected: types.Int,
+		Actual:   types.Bool,
+		Stack:    []*types.Value{types.NewIntValue(1), types.NewBoolValue(false)},
+		Err:      decErr.Err,
+	}
+	if diff := cmp.Diff(want, decErr); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDecodeReportsStackUnderflow(t *testing.T) {
+	var v interface{}
+	err := watson.Unmarshal([]byte(" u"), &v)
+	if !errors.Is(err, vm.ErrStackEmpty) {
+		t.Fatalf("expected ErrStackEmpty but got %v", err)
+	}
+	var decErr *watson.DecodeError
+	if !errors.As(err, &decErr) {
+		t.Fatalf("expected DecodeError but got %v", err)
+	}
+	if decErr.Op != vm.Iinc || decErr.Line != 0 || decErr.Column != 1 {
+		t.Errorf("unexpected position: %v", decErr)
+	}
+}
+
+func TestDecodeErrorMessage(t *testing.T) {
+	err := &watson.DecodeError{
+		FileName: "hoge.watson",
+		Line:     2,
+		Column:   4,
+		Op:       vm.Oadd,
+		Stack:    []*types.Value{types.NewStringValue([]byte("key")), types.NewArrayValue(nil)},
+		Err:      vm.ErrTypeMismatch,
+	}
+	want := `Oadd: type mismatch at hoge.watson:3:5 (stack: [String("key"), Array(0 elements)])`
+	if got := err.Error(); got != want {
+		t.Errorf("expected %q but got %q", want, got)
+	}
+}
+
+func TestDecoderFailsWhenALimitIsExceeded(t *testing.T) {
+	// "@" (Anew) followed by "Es" (Gdup Aadd) doubles the size of the array in each iteration.
+	src := "@" + strings.Repeat("Es", 64)
+	dec := watson.NewDecoder(strings.NewReader(src))
+	dec.SetMaxAllocation(1 << 16)
+	var v interface{}
+	err := dec.Decode(&v)
+	if !errors.Is(err, vm.ErrMaximumAllocationExceeded) {
+		t.Fatalf("expected ErrMaximumAllocationExceeded but got %v", err)
+	}
+}
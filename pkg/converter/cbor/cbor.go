@@ -2,6 +2,10 @@
 package cbor
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 
 	"github.com/fxamacker/cbor/v2"
@@ -9,18 +13,214 @@ import (
 	"github.com/genkami/watson/pkg/types"
 )
 
+const (
+	majorTypeArray = 4
+	majorTypeMap   = 5
+
+	additionalInfoUint8      = 24
+	additionalInfoUint16     = 25
+	additionalInfoUint32     = 26
+	additionalInfoUint64     = 27
+	additionalInfoIndefinite = 31
+
+	breakCode = 0xff
+)
+
 func Decode(w io.Writer, val *types.Value) error {
-	obj := val.ToGoObject()
+	obj := toCbor(val)
 	enc := cbor.NewEncoder(w)
 	return enc.Encode(obj)
 }
 
+// toCbor is almost the same as `types.Value.ToGoObject` but it converts objects into `object` so as to preserve the order of their keys.
+func toCbor(val *types.Value) interface{} {
+	switch val.Kind {
+	case types.Object:
+		return &object{val: val.Object}
+	case types.Array:
+		arr := make([]interface{}, 0, len(val.Array))
+		for _, v := range val.Array {
+			arr = append(arr, toCbor(v))
+		}
+		return arr
+	default:
+		return val.ToGoObject()
+	}
+}
+
+// object is a CBOR map whose members are written in the same order as the underlying `types.Map`.
+type object struct {
+	val *types.Map
+}
+
+func (o *object) MarshalCBOR() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	writeHead(buf, majorTypeMap, uint64(o.val.Len()))
+	o.val.Range(func(k string, v *types.Value) bool {
+		var b []byte
+		b, err = cbor.Marshal(k)
+		if err != nil {
+			return false
+		}
+		buf.Write(b)
+		b, err = cbor.Marshal(toCbor(v))
+		if err != nil {
+			return false
+		}
+		buf.Write(b)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var _ cbor.Marshaler = &object{}
+
+func writeHead(buf *bytes.Buffer, major byte, n uint64) {
+	var b [8]byte
+	switch {
+	case n < additionalInfoUint8:
+		buf.WriteByte(major<<5 | byte(n))
+	case n <= 0xff:
+		buf.WriteByte(major<<5 | additionalInfoUint8)
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(major<<5 | additionalInfoUint16)
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:2])
+	case n <= 0xffffffff:
+		buf.WriteByte(major<<5 | additionalInfoUint32)
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:4])
+	default:
+		buf.WriteByte(major<<5 | additionalInfoUint64)
+		binary.BigEndian.PutUint64(b[:], n)
+		buf.Write(b[:8])
+	}
+}
+
 func Encode(r io.Reader) (*types.Value, error) {
-	var any interface{}
+	var item item
 	dec := cbor.NewDecoder(r)
-	err := dec.Decode(&any)
+	err := dec.Decode(&item)
 	if err != nil {
 		return nil, err
 	}
-	return types.ToValue(any)
+	return item.val, nil
+}
+
+// EncodeStream reads a sequence of concatenated CBOR data items and calls f for each of them.
+func EncodeStream(r io.Reader, f func(*types.Value) error) error {
+	dec := cbor.NewDecoder(r)
+	for {
+		var item item
+		err := dec.Decode(&item)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = f(item.val)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// item is a CBOR data item that is decoded without losing the order of keys.
+type item struct {
+	val *types.Value
+}
+
+func (it *item) UnmarshalCBOR(data []byte) error {
+	if len(data) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	major := data[0] >> 5
+	if major != majorTypeArray && major != majorTypeMap {
+		var any interface{}
+		err := cbor.Unmarshal(data, &any)
+		if err != nil {
+			return err
+		}
+		it.val, err = types.ToValue(any)
+		return err
+	}
+	size, offset, err := readHead(data)
+	if err != nil {
+		return err
+	}
+	dec := cbor.NewDecoder(bytes.NewReader(data[offset:]))
+	more := func(i int) bool {
+		if size < 0 {
+			pos := offset + dec.NumBytesRead()
+			return pos < len(data) && data[pos] != breakCode
+		}
+		return i < size
+	}
+	if major == majorTypeArray {
+		arr := make([]*types.Value, 0)
+		for i := 0; more(i); i++ {
+			var elem item
+			err = dec.Decode(&elem)
+			if err != nil {
+				return err
+			}
+			arr = append(arr, elem.val)
+		}
+		it.val = types.NewArrayValue(arr)
+		return nil
+	}
+	obj := types.NewMap()
+	for i := 0; more(i); i++ {
+		var key interface{}
+		err = dec.Decode(&key)
+		if err != nil {
+			return err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return fmt.Errorf("can't convert %T to string", key)
+		}
+		var elem item
+		err = dec.Decode(&elem)
+		if err != nil {
+			return err
+		}
+		obj.Set(k, elem.val)
+	}
+	it.val = types.NewOrderedObjectValue(obj)
+	return nil
+}
+
+var _ cbor.Unmarshaler = &item{}
+
+// readHead reads the head of an array or a map and returns the number of its elements and the length of the head.
+// The number of elements is negative if the item has indefinite length.
+func readHead(data []byte) (size int, offset int, err error) {
+	info := data[0] & 0x1f
+	var n uint64
+	switch {
+	case info < additionalInfoUint8:
+		return int(info), 1, nil
+	case info == additionalInfoIndefinite:
+		return -1, 1, nil
+	case info == additionalInfoUint8 && len(data) >= 2:
+		n, offset = uint64(data[1]), 2
+	case info == additionalInfoUint16 && len(data) >= 3:
+		n, offset = uint64(binary.BigEndian.Uint16(data[1:])), 3
+	case info == additionalInfoUint32 && len(data) >= 5:
+		n, offset = uint64(binary.BigEndian.Uint32(data[1:])), 5
+	case info == additionalInfoUint64 && len(data) >= 9:
+		n, offset = binary.BigEndian.Uint64(data[1:]), 9
+	default:
+		return 0, 0, errors.New("malformed CBOR data item")
+	}
+	if n > uint64(len(data)) {
+		return 0, 0, errors.New("malformed CBOR data item")
+	}
+	return int(n), offset, nil
 }
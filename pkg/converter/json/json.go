@@ -2,24 +2,145 @@
 package json
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 
 	"github.com/genkami/watson/pkg/types"
 )
 
 func Decode(w io.Writer, val *types.Value) error {
-	obj := val.ToGoObject()
+	obj := toJson(val)
 	enc := json.NewEncoder(w)
 	return enc.Encode(obj)
 }
 
+// toJson is almost the same as `types.Value.ToGoObject` but it converts objects into `object` so as to preserve the order of their keys.
+func toJson(val *types.Value) interface{} {
+	switch val.Kind {
+	case types.Object:
+		return &object{val: val.Object}
+	case types.Array:
+		arr := make([]interface{}, 0, len(val.Array))
+		for _, v := range val.Array {
+			arr = append(arr, toJson(v))
+		}
+		return arr
+	default:
+		return val.ToGoObject()
+	}
+}
+
+// object is a JSON object whose members are written in the same order as the underlying `types.Map`.
+type object struct {
+	val *types.Map
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte('{')
+	first := true
+	o.val.Range(func(k string, v *types.Value) bool {
+		if first {
+			first = false
+		} else {
+			buf.WriteByte(',')
+		}
+		var b []byte
+		b, err = json.Marshal(k)
+		if err != nil {
+			return false
+		}
+		buf.Write(b)
+		buf.WriteByte(':')
+		b, err = json.Marshal(toJson(v))
+		if err != nil {
+			return false
+		}
+		buf.Write(b)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+var _ json.Marshaler = &object{}
+
 func Encode(r io.Reader) (*types.Value, error) {
-	var any interface{}
 	dec := json.NewDecoder(r)
-	err := dec.Decode(&any)
+	return decodeValue(dec)
+}
+
+// EncodeStream reads a stream of JSON values, such as newline-delimited JSON, and calls f for each of them.
+func EncodeStream(r io.Reader, f func(*types.Value) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		v, err := decodeValue(dec)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = f(v)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decodeValue reads a JSON value token by token so as to preserve the order of keys.
+func decodeValue(dec *json.Decoder) (*types.Value, error) {
+	tok, err := dec.Token()
 	if err != nil {
 		return nil, err
 	}
-	return types.ToValue(any)
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return types.ToValue(tok)
+	}
+	switch delim {
+	case '{':
+		obj := types.NewMap()
+		for dec.More() {
+			tok, err = dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			k, ok := tok.(string)
+			if !ok {
+				return nil, fmt.Errorf("can't convert %T to string", tok)
+			}
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.Set(k, v)
+		}
+		_, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		return types.NewOrderedObjectValue(obj), nil
+	case '[':
+		arr := make([]*types.Value, 0)
+		for dec.More() {
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		return types.NewArrayValue(arr), nil
+	default:
+		return nil, fmt.Errorf("unexpected delimiter: %s", delim)
+	}
 }
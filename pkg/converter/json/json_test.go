@@ -0,0 +1,50 @@
+package json
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/types"
+)
+
+func TestEncodeAndDecodePreserveTheOrderOfKeys(t *testing.T) {
+	src := `{"zebra":1,"apple":{"tako":true,"ebi":[{"shrimp":null,"crab":"<kani>"}]},"mango":"hello"}` + "\n"
+	val, err := Encode(bytes.NewReader([]byte(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"zebra", "apple", "mango"}, val.Object.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	buf := bytes.NewBuffer(nil)
+	err = Decode(buf, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"zebra":1,"apple":{"tako":true,"ebi":[{"shrimp":null,"crab":"\u003ckani\u003e"}]},"mango":"hello"}` + "\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEncodeStreamReadsAllValues(t *testing.T) {
+	src := "{\"a\": 1}\n[true]\n\"hello\"\n"
+	got := make([]interface{}, 0)
+	err := EncodeStream(bytes.NewReader([]byte(src)), func(v *types.Value) error {
+		got = append(got, v.ToGoObject())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		map[string]interface{}{"a": float64(1)},
+		[]interface{}{true},
+		"hello",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -2,6 +2,7 @@
 package msgpack
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/vmihailenco/msgpack/v5"
@@ -10,17 +11,129 @@ import (
 )
 
 func Decode(w io.Writer, val *types.Value) error {
-	obj := val.ToGoObject()
+	obj := toMsgpack(val)
 	enc := msgpack.NewEncoder(w)
 	return enc.Encode(obj)
 }
 
+// toMsgpack is almost the same as `types.Value.ToGoObject` but it converts objects into `object` so as to preserve the order of their keys.
+func toMsgpack(val *types.Value) interface{} {
+	switch val.Kind {
+	case types.Object:
+		return &object{val: val.Object}
+	case types.Array:
+		arr := make([]interface{}, 0, len(val.Array))
+		for _, v := range val.Array {
+			arr = append(arr, toMsgpack(v))
+		}
+		return arr
+	default:
+		return val.ToGoObject()
+	}
+}
+
+// object is a MessagePack map whose members are written in the same order as the underlying `types.Map`.
+type object struct {
+	val *types.Map
+}
+
+func (o *object) EncodeMsgpack(enc *msgpack.Encoder) error {
+	err := enc.EncodeMapLen(o.val.Len())
+	if err != nil {
+		return err
+	}
+	o.val.Range(func(k string, v *types.Value) bool {
+		err = enc.EncodeString(k)
+		if err != nil {
+			return false
+		}
+		err = enc.Encode(toMsgpack(v))
+		return err == nil
+	})
+	return err
+}
+
+var _ msgpack.CustomEncoder = &object{}
+
 func Encode(r io.Reader) (*types.Value, error) {
-	var any interface{}
 	dec := msgpack.NewDecoder(r)
-	err := dec.Decode(&any)
+	dec.SetMapDecoder(decodeMap)
+	any, err := dec.DecodeInterface()
+	if err != nil {
+		return nil, err
+	}
+	return fromMsgpack(any)
+}
+
+// EncodeStream reads a sequence of concatenated MessagePack objects and calls f for each of them.
+func EncodeStream(r io.Reader, f func(*types.Value) error) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetMapDecoder(decodeMap)
+	for {
+		any, err := dec.DecodeInterface()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		v, err := fromMsgpack(any)
+		if err != nil {
+			return err
+		}
+		err = f(v)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decodeMap decodes a MessagePack map into `types.Map` so as to preserve the order of keys.
+func decodeMap(dec *msgpack.Decoder) (interface{}, error) {
+	size, err := dec.DecodeMapLen()
 	if err != nil {
 		return nil, err
 	}
-	return types.ToValue(any)
+	if size < 0 {
+		return nil, nil
+	}
+	obj := types.NewMap()
+	for i := 0; i < size; i++ {
+		key, err := dec.DecodeInterface()
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("can't convert %T to string", key)
+		}
+		elem, err := dec.DecodeInterface()
+		if err != nil {
+			return nil, err
+		}
+		v, err := fromMsgpack(elem)
+		if err != nil {
+			return nil, err
+		}
+		obj.Set(k, v)
+	}
+	return obj, nil
+}
+
+func fromMsgpack(any interface{}) (*types.Value, error) {
+	switch any := any.(type) {
+	case *types.Map:
+		return types.NewOrderedObjectValue(any), nil
+	case []interface{}:
+		arr := make([]*types.Value, 0, len(any))
+		for _, elem := range any {
+			v, err := fromMsgpack(elem)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return types.NewArrayValue(arr), nil
+	default:
+		return types.ToValue(any)
+	}
 }
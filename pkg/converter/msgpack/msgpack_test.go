@@ -0,0 +1,63 @@
+package msgpack
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/types"
+)
+
+func TestEncodeAndDecodePreserveTheOrderOfKeys(t *testing.T) {
+	inner := types.NewMap()
+	inner.Set("tako", types.NewBoolValue(true))
+	inner.Set("ebi", types.NewArrayValue([]*types.Value{types.NewNilValue()}))
+	obj := types.NewMap()
+	obj.Set("zebra", types.NewIntValue(1))
+	obj.Set("apple", types.NewOrderedObjectValue(inner))
+	obj.Set("mango", types.NewStringValue([]byte("hello")))
+	want := types.NewOrderedObjectValue(obj)
+
+	buf := bytes.NewBuffer(nil)
+	err := Decode(buf, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Encode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"zebra", "apple", "mango"}, got.Object.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	apple, _ := got.Object.Get("apple")
+	if diff := cmp.Diff([]string{"tako", "ebi"}, apple.Object.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEncodeStreamReadsAllObjects(t *testing.T) {
+	want := []*types.Value{types.NewStringValue([]byte("hello")), types.NewBoolValue(true)}
+	buf := bytes.NewBuffer(nil)
+	for _, v := range want {
+		err := Decode(buf, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := make([]*types.Value, 0)
+	err := EncodeStream(buf, func(v *types.Value) error {
+		got = append(got, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
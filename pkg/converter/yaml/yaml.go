@@ -3,6 +3,7 @@ package yaml
 
 import (
 	"errors"
+	"fmt"
 	"io"
 
 	"gopkg.in/yaml.v2"
@@ -11,15 +12,13 @@ import (
 )
 
 func Decode(w io.Writer, val *types.Value) error {
-	obj := val.ToGoObject()
 	enc := yaml.NewEncoder(w)
 	defer enc.Close()
-	arr, ok := obj.([]interface{})
-	if !ok {
-		return enc.Encode(obj)
+	if val.Kind != types.Array {
+		return enc.Encode(toYaml(val))
 	}
-	for _, v := range arr {
-		err := enc.Encode(v)
+	for _, v := range val.Array {
+		err := enc.Encode(toYaml(v))
 		if err != nil {
 			return err
 		}
@@ -27,23 +26,61 @@ func Decode(w io.Writer, val *types.Value) error {
 	return nil
 }
 
+// toYaml is almost the same as `types.Value.ToGoObject` but it converts objects into `yaml.MapSlice` so as to preserve the order of their keys.
+func toYaml(val *types.Value) interface{} {
+	switch val.Kind {
+	case types.Object:
+		obj := make(yaml.MapSlice, 0, val.Object.Len())
+		val.Object.Range(func(k string, v *types.Value) bool {
+			obj = append(obj, yaml.MapItem{Key: k, Value: toYaml(v)})
+			return true
+		})
+		return obj
+	case types.Array:
+		arr := make([]interface{}, 0, len(val.Array))
+		for _, v := range val.Array {
+			arr = append(arr, toYaml(v))
+		}
+		return arr
+	default:
+		return val.ToGoObject()
+	}
+}
+
+// StreamDecoder writes values as a stream of YAML documents.
+// Unlike Decode, it writes each value as a single document even if it is an array.
+type StreamDecoder struct {
+	enc *yaml.Encoder
+}
+
+// NewStreamDecoder creates a new StreamDecoder that writes to w.
+func NewStreamDecoder(w io.Writer) *StreamDecoder {
+	return &StreamDecoder{enc: yaml.NewEncoder(w)}
+}
+
+// Decode writes val as the next document.
+func (d *StreamDecoder) Decode(val *types.Value) error {
+	return d.enc.Encode(toYaml(val))
+}
+
+// Close finishes the stream.
+func (d *StreamDecoder) Close() error {
+	return d.enc.Close()
+}
+
 func Encode(r io.Reader) (*types.Value, error) {
-	var any interface{}
 	dec := yaml.NewDecoder(r)
 	results := make([]*types.Value, 0)
 	for {
-		err := dec.Decode(&any)
+		var doc document
+		err := dec.Decode(&doc)
 		if err != nil {
 			if errors.Is(err, io.EOF) && len(results) > 0 {
 				break
 			}
 			return nil, err
 		}
-		v, err := types.ToValue(any)
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, v)
+		results = append(results, doc.val)
 	}
 	if len(results) == 1 {
 		return results[0], nil
@@ -51,3 +88,92 @@ func Encode(r io.Reader) (*types.Value, error) {
 		return types.NewArrayValue(results), nil
 	}
 }
+
+// document is a YAML document that is decoded without losing the order of keys.
+type document struct {
+	val *types.Value
+}
+
+// EncodeStream reads a stream of YAML documents and calls f for each of them.
+// Unlike Encode, it never merges documents into an array.
+func EncodeStream(r io.Reader, f func(*types.Value) error) error {
+	dec := yaml.NewDecoder(r)
+	for {
+		var doc document
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = f(doc.val)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (d *document) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var any interface{}
+	err := unmarshal(&any)
+	if err != nil {
+		return err
+	}
+	switch any.(type) {
+	case map[interface{}]interface{}:
+		// Mappings that are decoded into yaml.MapSlice make all mappings inside them decoded into yaml.MapSlice too.
+		var obj yaml.MapSlice
+		err = unmarshal(&obj)
+		if err != nil {
+			return err
+		}
+		d.val, err = fromYaml(obj)
+		return err
+	case []interface{}:
+		var arr []document
+		err = unmarshal(&arr)
+		if err != nil {
+			return err
+		}
+		vals := make([]*types.Value, 0, len(arr))
+		for _, elem := range arr {
+			vals = append(vals, elem.val)
+		}
+		d.val = types.NewArrayValue(vals)
+		return nil
+	default:
+		d.val, err = types.ToValue(any)
+		return err
+	}
+}
+
+func fromYaml(any interface{}) (*types.Value, error) {
+	switch any := any.(type) {
+	case yaml.MapSlice:
+		obj := types.NewMap()
+		for _, item := range any {
+			k, ok := item.Key.(string)
+			if !ok {
+				return nil, fmt.Errorf("can't convert %T to string", item.Key)
+			}
+			v, err := fromYaml(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			obj.Set(k, v)
+		}
+		return types.NewOrderedObjectValue(obj), nil
+	case []interface{}:
+		arr := make([]*types.Value, 0, len(any))
+		for _, elem := range any {
+			v, err := fromYaml(elem)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return types.NewArrayValue(arr), nil
+	default:
+		return types.ToValue(any)
+	}
+}
@@ -0,0 +1,85 @@
+package yaml
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/types"
+)
+
+func TestEncodePreservesTheOrderOfKeys(t *testing.T) {
+	src := "zebra: 1\napple:\n  tako: true\n  ebi: [{shrimp: 1, crab: 2}]\nmango: hello\n"
+	val, err := Encode(bytes.NewReader([]byte(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"zebra", "apple", "mango"}, val.Object.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	apple, _ := val.Object.Get("apple")
+	if diff := cmp.Diff([]string{"tako", "ebi"}, apple.Object.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	ebi, _ := apple.Object.Get("ebi")
+	if diff := cmp.Diff([]string{"shrimp", "crab"}, ebi.Array[0].Object.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDecodePreservesTheOrderOfKeys(t *testing.T) {
+	src := "zebra: 1\napple:\n  tako: true\n  ebi:\n  - shrimp: 1\n    crab: 2\nmango: hello\n"
+	val, err := Encode(bytes.NewReader([]byte(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	err = Decode(buf, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(src, buf.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEncodeDecodesMultipleDocumentsIntoAnArray(t *testing.T) {
+	src := "b: 1\na: 2\n--- hello\n"
+	val, err := Encode(bytes.NewReader([]byte(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	err = Decode(buf, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(src, buf.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEncodeStreamAndStreamDecoderKeepDocumentsSeparated(t *testing.T) {
+	src := "- 1\n- 2\n---\na: 2\n--- hello\n"
+	buf := bytes.NewBuffer(nil)
+	dec := NewStreamDecoder(buf)
+	n := 0
+	err := EncodeStream(bytes.NewReader([]byte(src)), func(v *types.Value) error {
+		n++
+		return dec.Decode(v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dec.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 documents but got %d", n)
+	}
+	if diff := cmp.Diff(src, buf.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,238 @@
+// Package debugger executes Watson Representation instruction by instruction, so that the state of the VM can be inspected at any point.
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/genkami/watson"
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// ErrFinished is returned when there are no instructions left to execute.
+var ErrFinished = errors.New("the program has finished")
+
+// TokenReader is what Debugger reads instructions from. Both `lexer.Lexer` and `lexer.ParallelLexer` implement it.
+type TokenReader interface {
+	ReadTokens(toks []lexer.Token) (int, error)
+}
+
+// DebuggerOption configures a Debugger.
+type DebuggerOption interface {
+	apply(*Debugger)
+}
+
+type debuggerOption func(*Debugger)
+
+func (opt debuggerOption) apply(d *Debugger) {
+	opt(d)
+}
+
+// WithVMOptions configures the VM that executes the program.
+func WithVMOptions(opts ...vm.VMOption) DebuggerOption {
+	return debuggerOption(func(d *Debugger) {
+		d.vmOpts = append(d.vmOpts, opts...)
+	})
+}
+
+// Debugger executes a program, which is a sequence of tokens, on a VM step by step.
+//
+// The program is read into memory before execution so that breakpoints can be set on any position of it.
+// Each method that executes instructions stops before an instruction, so the instruction returned by Next is always the one to be executed next.
+type Debugger struct {
+	toks        []lexer.Token
+	pc          int // the index of the next token in toks
+	m           *vm.VM
+	vmOpts      []vm.VMOption
+	breakpoints []*Breakpoint
+	lastID      int
+}
+
+// Breakpoint stops execution before an instruction. See Debugger.AddPositionBreakpoint and Debugger.AddOpBreakpoint.
+type Breakpoint struct {
+	ID int
+
+	index int // the index of the token at which the breakpoint stops, or -1 if it stops at any instruction that is Op
+	op    vm.Op
+	tok   *lexer.Token
+}
+
+func (b *Breakpoint) String() string {
+	if b.index < 0 {
+		return fmt.Sprintf("breakpoint %d on %#v", b.ID, b.op)
+	}
+	return fmt.Sprintf("breakpoint %d at %s (%#v)", b.ID, Position(b.tok), b.tok.Op)
+}
+
+func (b *Breakpoint) hits(index int, tok *lexer.Token) bool {
+	if b.index < 0 {
+		return tok.Op == b.op
+	}
+	return b.index == index
+}
+
+// NewDebugger reads all tokens from r and returns a new Debugger that executes them.
+func NewDebugger(r TokenReader, opts ...DebuggerOption) (*Debugger, error) {
+	d := &Debugger{}
+	for _, opt := range opts {
+		opt.apply(d)
+	}
+	buf := make([]lexer.Token, 256)
+	for {
+		n, err := r.ReadTokens(buf)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		d.toks = append(d.toks, buf[:n]...)
+	}
+	d.Restart()
+	return d, nil
+}
+
+// Restart discards the state of the VM and moves back to the beginning of the program. Breakpoints are kept.
+func (d *Debugger) Restart() {
+	d.m = vm.NewVM(d.vmOpts...)
+	d.pc = 0
+}
+
+// Next returns the instruction to be executed next, or nil if the program has finished.
+func (d *Debugger) Next() *lexer.Token {
+	if d.pc >= len(d.toks) {
+		return nil
+	}
+	return &d.toks[d.pc]
+}
+
+// Executed returns the number of instructions that have been executed so far.
+func (d *Debugger) Executed() int {
+	return d.pc
+}
+
+// Len returns the number of instructions in the program.
+func (d *Debugger) Len() int {
+	return len(d.toks)
+}
+
+// Stack returns all values on the stack of the VM, from the bottom to the top. They must not be modified.
+func (d *Debugger) Stack() []*types.Value {
+	return d.m.Stack()
+}
+
+// Step executes the next instruction.
+// If it fails, it returns `*watson.DecodeError` and the Debugger stays before the instruction, leaving the stack as it was.
+func (d *Debugger) Step() error {
+	tok := d.Next()
+	if tok == nil {
+		return ErrFinished
+	}
+	err := d.m.Feed(tok.Op)
+	if err != nil {
+		return watson.NewDecodeError(tok, d.m.Stack(), err)
+	}
+	d.pc++
+	return nil
+}
+
+// Continue executes instructions until it reaches a breakpoint, and returns the breakpoint.
+// The next instruction is executed even if it has a breakpoint, so that calling Continue repeatedly makes progress.
+//
+// If the program finishes, it returns ErrFinished. If an instruction fails, it returns the error in the same way as Step.
+func (d *Debugger) Continue() (*Breakpoint, error) {
+	return d.run(func() bool { return false })
+}
+
+// RunTo executes instructions until the next instruction is at or after the given position (zero-origin), or until it reaches a breakpoint.
+// It returns the breakpoint if it stops there, and nil otherwise. Errors are returned in the same way as Continue.
+func (d *Debugger) RunTo(line, column int) (*Breakpoint, error) {
+	return d.run(func() bool {
+		tok := d.Next()
+		return tok.Line > line || tok.Line == line && tok.Column >= column
+	})
+}
+
+// RunToCount executes instructions until n instructions have been executed in total, or until it reaches a breakpoint.
+// It returns the breakpoint if it stops there, and nil otherwise. Errors are returned in the same way as Continue.
+func (d *Debugger) RunToCount(n int) (*Breakpoint, error) {
+	return d.run(func() bool {
+		return d.pc >= n
+	})
+}
+
+// run executes instructions until done reports true or a breakpoint is hit.
+func (d *Debugger) run(done func() bool) (*Breakpoint, error) {
+	for first := true; ; first = false {
+		tok := d.Next()
+		if tok == nil {
+			return nil, ErrFinished
+		}
+		if done() {
+			return nil, nil
+		}
+		if !first {
+			for _, b := range d.breakpoints {
+				if b.hits(d.pc, tok) {
+					return b, nil
+				}
+			}
+		}
+		err := d.Step()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// AddPositionBreakpoint adds a breakpoint at the first instruction at or after the given position (zero-origin).
+// It fails if there are no instructions on or after the line.
+func (d *Debugger) AddPositionBreakpoint(line, column int) (*Breakpoint, error) {
+	for i := range d.toks {
+		tok := &d.toks[i]
+		if tok.Line > line || tok.Line == line && tok.Column >= column {
+			return d.addBreakpoint(&Breakpoint{index: i, op: tok.Op, tok: tok}), nil
+		}
+	}
+	return nil, fmt.Errorf("no instructions at or after line %d", line+1)
+}
+
+// AddOpBreakpoint adds a breakpoint at every instruction that is op.
+func (d *Debugger) AddOpBreakpoint(op vm.Op) *Breakpoint {
+	return d.addBreakpoint(&Breakpoint{index: -1, op: op})
+}
+
+func (d *Debugger) addBreakpoint(b *Breakpoint) *Breakpoint {
+	d.lastID++
+	b.ID = d.lastID
+	d.breakpoints = append(d.breakpoints, b)
+	return b
+}
+
+// RemoveBreakpoint removes the breakpoint whose ID is id, and reports whether it existed.
+func (d *Debugger) RemoveBreakpoint(id int) bool {
+	for i, b := range d.breakpoints {
+		if b.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns all breakpoints in the order in which they were added.
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	bs := make([]*Breakpoint, len(d.breakpoints))
+	copy(bs, d.breakpoints)
+	return bs
+}
+
+// Position returns the position of tok as "FILE:LINE:COLUMN", where LINE and COLUMN are one-origin, or "LINE:COLUMN" if it has no file name.
+func Position(tok *lexer.Token) string {
+	if tok.FileName == "" {
+		return fmt.Sprintf("%d:%d", tok.Line+1, tok.Column+1)
+	}
+	return fmt.Sprintf("%s:%d:%d", tok.FileName, tok.Line+1, tok.Column+1)
+}
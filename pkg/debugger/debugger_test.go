@@ -0,0 +1,207 @@
+package debugger
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson"
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// program pushes 1 and 1, and adds them.
+const program = "Bu\nBu\na\n"
+
+func newLexer(src string, opts ...lexer.LexerOption) *lexer.Lexer {
+	return lexer.NewLexer(strings.NewReader(src), opts...)
+}
+
+func newDebugger(t *testing.T, src string) *Debugger {
+	t.Helper()
+	d, err := NewDebugger(newLexer(src, lexer.WithFileName("test.watson")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func assertStack(t *testing.T, d *Debugger, want ...*types.Value) {
+	t.Helper()
+	if want == nil {
+		want = []*types.Value{}
+	}
+	if diff := cmp.Diff(want, d.Stack()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStepExecutesOneInstruction(t *testing.T) {
+	d := newDebugger(t, program)
+	if d.Len() != 5 {
+		t.Fatalf("expected 5 instructions but got %d", d.Len())
+	}
+	for _, want := range []vm.Op{vm.Inew, vm.Iinc, vm.Inew} {
+		if got := d.Next().Op; got != want {
+			t.Fatalf("expected %#v but got %#v", want, got)
+		}
+		err := d.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertStack(t, d, types.NewIntValue(1), types.NewIntValue(0))
+	if d.Executed() != 3 {
+		t.Errorf("expected 3 but got %d", d.Executed())
+	}
+}
+
+func TestStepReturnsErrFinishedAtTheEnd(t *testing.T) {
+	d := newDebugger(t, program)
+	_, err := d.Continue()
+	if err != ErrFinished {
+		t.Fatalf("expected %v but got %v", ErrFinished, err)
+	}
+	assertStack(t, d, types.NewIntValue(2))
+	if d.Next() != nil {
+		t.Errorf("expected nil but got %#v", d.Next())
+	}
+	err = d.Step()
+	if err != ErrFinished {
+		t.Errorf("expected %v but got %v", ErrFinished, err)
+	}
+}
+
+func TestStepStaysBeforeTheFailedInstruction(t *testing.T) {
+	d := newDebugger(t, "Ba")
+	err := d.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Step()
+	var derr *watson.DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("expected DecodeError but got %v", err)
+	}
+	if derr.Column != 1 || !errors.Is(err, vm.ErrStackEmpty) {
+		t.Errorf("unexpected error: %#v", derr)
+	}
+	if d.Executed() != 1 {
+		t.Errorf("expected 1 but got %d", d.Executed())
+	}
+	assertStack(t, d, types.NewIntValue(0))
+}
+
+func TestContinueStopsAtOpBreakpoints(t *testing.T) {
+	d := newDebugger(t, program)
+	b := d.AddOpBreakpoint(vm.Inew)
+	// The first instruction is executed even though it has a breakpoint.
+	got, err := d.Continue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != b || d.Executed() != 2 {
+		t.Fatalf("expected to stop at %s after 2 instructions but stopped at %v after %d", b, got, d.Executed())
+	}
+	_, err = d.Continue()
+	if err != ErrFinished {
+		t.Errorf("expected %v but got %v", ErrFinished, err)
+	}
+}
+
+func TestContinueStopsAtPositionBreakpoints(t *testing.T) {
+	d := newDebugger(t, program)
+	b, err := d.AddPositionBreakpoint(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "breakpoint 1 at test.watson:2:2 (Iinc)" {
+		t.Errorf("unexpected breakpoint: %s", b)
+	}
+	got, err := d.Continue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != b || d.Executed() != 3 {
+		t.Fatalf("expected to stop at %s after 3 instructions but stopped at %v after %d", b, got, d.Executed())
+	}
+	if !d.RemoveBreakpoint(b.ID) {
+		t.Errorf("expected %s to be removed", b)
+	}
+	if d.RemoveBreakpoint(b.ID) {
+		t.Errorf("expected %s to be removed only once", b)
+	}
+	d.Restart()
+	_, err = d.Continue()
+	if err != ErrFinished {
+		t.Errorf("expected %v but got %v", ErrFinished, err)
+	}
+}
+
+func TestPositionBreakpointsAreSetAtTheNextInstruction(t *testing.T) {
+	d := newDebugger(t, "Bu\n  \nBu\n")
+	b, err := d.AddPositionBreakpoint(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "breakpoint 1 at test.watson:3:1 (Inew)" {
+		t.Errorf("unexpected breakpoint: %s", b)
+	}
+	_, err = d.AddPositionBreakpoint(3, 0)
+	if err == nil {
+		t.Errorf("expected error but got nil")
+	}
+}
+
+func TestRunToStopsBeforeThePosition(t *testing.T) {
+	d := newDebugger(t, program)
+	b, err := d.RunTo(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != nil || d.Executed() != 3 {
+		t.Errorf("expected to stop after 3 instructions but stopped at %v after %d", b, d.Executed())
+	}
+	_, err = d.RunTo(10, 0)
+	if err != ErrFinished {
+		t.Errorf("expected %v but got %v", ErrFinished, err)
+	}
+}
+
+func TestRunToCountStopsAfterTheInstructions(t *testing.T) {
+	d := newDebugger(t, program)
+	b, err := d.RunToCount(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != nil || d.Executed() != 4 {
+		t.Errorf("expected to stop after 4 instructions but stopped at %v after %d", b, d.Executed())
+	}
+	assertStack(t, d, types.NewIntValue(1), types.NewIntValue(1))
+
+	d.AddOpBreakpoint(vm.Iinc)
+	d.Restart()
+	b, err = d.RunToCount(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil || d.Executed() != 1 {
+		t.Errorf("expected to stop at the breakpoint after 1 instruction but stopped at %v after %d", b, d.Executed())
+	}
+}
+
+func TestDescribeShowsKindsOfAllValues(t *testing.T) {
+	obj := types.NewObjectValue(nil)
+	obj.Object.Set("name", types.NewStringValue([]byte("nginx")))
+	obj.Object.Set("ports", types.NewArrayValue([]*types.Value{types.NewIntValue(80), types.NewUintValue(443)}))
+	obj.Object.Set("ratio", types.NewFloatValue(0.5))
+	obj.Object.Set("tls", types.NewBoolValue(true))
+	obj.Object.Set("extra", types.NewNilValue())
+	want := `Object{"name": String("nginx"), "ports": Array[Int(80), Uint(443)], "ratio": Float(0.5), "tls": Bool(true), "extra": Nil}`
+	if got := Describe(obj); got != want {
+		t.Errorf("expected %s but got %s", want, got)
+	}
+}
@@ -0,0 +1,66 @@
+package debugger
+
+import (
+	"strconv"
+
+	"github.com/genkami/watson/pkg/types"
+)
+
+// Describe returns the whole content of v along with the kinds of it and its descendants, like this:
+//
+//	Object{"name": String("nginx"), "ports": Array[Int(80), Int(443)], "tls": Bool(true)}
+//
+// Unlike `types.Value.Summary`, nothing is omitted.
+func Describe(v *types.Value) string {
+	return string(appendDescription(nil, v))
+}
+
+func appendDescription(buf []byte, v *types.Value) []byte {
+	switch v.Kind {
+	case types.Int:
+		buf = append(buf, "Int("...)
+		buf = strconv.AppendInt(buf, v.Int, 10)
+		return append(buf, ')')
+	case types.Uint:
+		buf = append(buf, "Uint("...)
+		buf = strconv.AppendUint(buf, v.Uint, 10)
+		return append(buf, ')')
+	case types.Float:
+		buf = append(buf, "Float("...)
+		buf = strconv.AppendFloat(buf, v.Float, 'g', -1, 64)
+		return append(buf, ')')
+	case types.String:
+		buf = append(buf, "String("...)
+		buf = strconv.AppendQuote(buf, string(v.String))
+		return append(buf, ')')
+	case types.Object:
+		buf = append(buf, "Object{"...)
+		first := true
+		v.Object.Range(func(k string, elem *types.Value) bool {
+			if !first {
+				buf = append(buf, ", "...)
+			}
+			first = false
+			buf = strconv.AppendQuote(buf, k)
+			buf = append(buf, ": "...)
+			buf = appendDescription(buf, elem)
+			return true
+		})
+		return append(buf, '}')
+	case types.Array:
+		buf = append(buf, "Array["...)
+		for i, elem := range v.Array {
+			if i > 0 {
+				buf = append(buf, ", "...)
+			}
+			buf = appendDescription(buf, elem)
+		}
+		return append(buf, ']')
+	case types.Bool:
+		buf = append(buf, "Bool("...)
+		buf = strconv.AppendBool(buf, v.Bool)
+		return append(buf, ')')
+	default:
+		return append(buf, "Nil"...)
+	}
+}
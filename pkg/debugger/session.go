@@ -0,0 +1,252 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// SessionOption configures a Session.
+type SessionOption interface {
+	apply(*Session)
+}
+
+type sessionOption func(*Session)
+
+func (opt sessionOption) apply(s *Session) {
+	opt(s)
+}
+
+// WithPrompt makes a Session write prompt before reading each command. By default no prompt is written, which is suitable for scripts.
+func WithPrompt(prompt string) SessionOption {
+	return sessionOption(func(s *Session) {
+		s.prompt = prompt
+	})
+}
+
+// Session reads commands line by line and operates a Debugger, writing the results.
+// Since commands are read from an io.Reader, a session can be driven by a script as well as by a user.
+//
+// Positions in commands and in the output are one-origin. The help command lists the available commands.
+type Session struct {
+	d      *Debugger
+	r      io.Reader
+	out    *bufio.Writer
+	prompt string
+}
+
+const sessionHelp = `commands:
+  step [N]              execute the next N instructions (default: 1)
+  continue              execute instructions until a breakpoint or the end
+  to LINE[:COLUMN]      execute instructions until the next one is at or after the position
+  count N               execute instructions until N instructions have been executed in total
+  break LINE[:COLUMN]   stop before the first instruction at or after the position
+  break OP              stop before every instruction OP (e.g. Iadd)
+  delete ID             delete the breakpoint ID
+  breakpoints           list breakpoints
+  stack                 show the values on the stack from the top
+  where                 show the next instruction
+  restart               discard the stack and go back to the beginning
+  help                  show this message
+  quit                  exit
+`
+
+// NewSession returns a new Session that reads commands from r and writes the results to w.
+func NewSession(d *Debugger, r io.Reader, w io.Writer, opts ...SessionOption) *Session {
+	s := &Session{d: d, r: r, out: bufio.NewWriter(w)}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	return s
+}
+
+// Run executes commands until the input ends or the quit command is given.
+// Errors of commands are written to the output, and only errors of reading or writing are returned.
+func (s *Session) Run() error {
+	scanner := bufio.NewScanner(s.r)
+	out := s.out
+	s.where()
+	for {
+		if s.prompt != "" {
+			out.WriteString(s.prompt)
+		}
+		err := out.Flush()
+		if err != nil {
+			return err
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "q" {
+			return out.Flush()
+		}
+		err = s.execute(args[0], args[1:])
+		if err != nil {
+			fmt.Fprintf(out, "error: %s\n", err.Error())
+		}
+	}
+}
+
+func (s *Session) execute(cmd string, args []string) error {
+	out := s.out
+	switch cmd {
+	case "step", "s":
+		n, err := optionalCount(args)
+		if err != nil {
+			return err
+		}
+		return s.report(s.d.RunToCount(s.d.Executed() + n))
+	case "continue", "c":
+		return s.report(s.d.Continue())
+	case "to":
+		line, column, err := positionArg(args)
+		if err != nil {
+			return err
+		}
+		return s.report(s.d.RunTo(line, column))
+	case "count":
+		if len(args) != 1 {
+			return errors.New("usage: count N")
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		return s.report(s.d.RunToCount(n))
+	case "break", "b":
+		return s.addBreakpoint(args)
+	case "delete", "d":
+		if len(args) != 1 {
+			return errors.New("usage: delete ID")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		if !s.d.RemoveBreakpoint(id) {
+			return fmt.Errorf("no breakpoint %d", id)
+		}
+		fmt.Fprintf(out, "deleted breakpoint %d\n", id)
+	case "breakpoints":
+		bs := s.d.Breakpoints()
+		if len(bs) == 0 {
+			fmt.Fprintf(out, "no breakpoints\n")
+		}
+		for _, b := range bs {
+			fmt.Fprintf(out, "%s\n", b)
+		}
+	case "stack", "st":
+		s.stack()
+	case "where", "w":
+		s.where()
+	case "restart":
+		s.d.Restart()
+		s.where()
+	case "help", "h":
+		io.WriteString(out, sessionHelp)
+	default:
+		return fmt.Errorf("unknown command: %s (type help for the list of commands)", cmd)
+	}
+	return nil
+}
+
+func (s *Session) addBreakpoint(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: break LINE[:COLUMN] or break OP")
+	}
+	var b *Breakpoint
+	if op, err := vm.ParseOp(args[0]); err == nil {
+		b = s.d.AddOpBreakpoint(op)
+	} else {
+		line, column, err := positionArg(args)
+		if err != nil {
+			return err
+		}
+		b, err = s.d.AddPositionBreakpoint(line, column)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(s.out, "%s\n", b)
+	return nil
+}
+
+// report writes why execution has stopped, followed by the next instruction.
+func (s *Session) report(b *Breakpoint, err error) error {
+	if err != nil && err != ErrFinished {
+		return err
+	}
+	if b != nil {
+		fmt.Fprintf(s.out, "hit %s\n", b)
+	}
+	s.where()
+	return nil
+}
+
+func (s *Session) where() {
+	out := s.out
+	tok := s.d.Next()
+	if tok == nil {
+		fmt.Fprintf(out, "finished (%d executed)\n", s.d.Executed())
+		return
+	}
+	fmt.Fprintf(out, "next: %s %#v (%d executed)\n", Position(tok), tok.Op, s.d.Executed())
+}
+
+func (s *Session) stack() {
+	out := s.out
+	stack := s.d.Stack()
+	if len(stack) == 0 {
+		fmt.Fprintf(out, "(empty)\n")
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		fmt.Fprintf(out, "[%d] %s\n", i, Describe(stack[i]))
+	}
+}
+
+func optionalCount(args []string) (int, error) {
+	switch len(args) {
+	case 0:
+		return 1, nil
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return 0, err
+		}
+		if n < 1 {
+			return 0, fmt.Errorf("expected a positive number but got %d", n)
+		}
+		return n, nil
+	default:
+		return 0, errors.New("too many arguments")
+	}
+}
+
+// positionArg parses "LINE[:COLUMN]" (one-origin) into a zero-origin position.
+func positionArg(args []string) (int, int, error) {
+	if len(args) != 1 {
+		return 0, 0, errors.New("expected LINE[:COLUMN]")
+	}
+	parts := strings.SplitN(args[0], ":", 2)
+	line, err := strconv.Atoi(parts[0])
+	if err != nil || line < 1 {
+		return 0, 0, fmt.Errorf("invalid line: %q", parts[0])
+	}
+	column := 1
+	if len(parts) == 2 {
+		column, err = strconv.Atoi(parts[1])
+		if err != nil || column < 1 {
+			return 0, 0, fmt.Errorf("invalid column: %q", parts[1])
+		}
+	}
+	return line - 1, column - 1, nil
+}
@@ -0,0 +1,113 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func runScript(t *testing.T, src, script string) string {
+	t.Helper()
+	out := &bytes.Buffer{}
+	err := NewSession(newDebugger(t, src), strings.NewReader(script), out).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestSessionRunsScripts(t *testing.T) {
+	script := `step
+step 2
+stack
+break Iadd
+break 2
+breakpoints
+continue
+delete 2
+breakpoints
+continue
+stack
+`
+	want := `next: test.watson:1:1 Inew (0 executed)
+next: test.watson:1:2 Iinc (1 executed)
+next: test.watson:2:2 Iinc (3 executed)
+[1] Int(0)
+[0] Int(1)
+breakpoint 1 on Iadd
+breakpoint 2 at test.watson:2:1 (Inew)
+breakpoint 1 on Iadd
+breakpoint 2 at test.watson:2:1 (Inew)
+hit breakpoint 1 on Iadd
+next: test.watson:3:1 Iadd (4 executed)
+deleted breakpoint 2
+breakpoint 1 on Iadd
+finished (5 executed)
+[0] Int(2)
+`
+	if diff := cmp.Diff(want, runScript(t, program, script)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSessionRunsToPositionsAndCounts(t *testing.T) {
+	script := `to 2:2
+count 4
+where
+restart
+stack
+quit
+step
+`
+	want := `next: test.watson:1:1 Inew (0 executed)
+next: test.watson:2:2 Iinc (3 executed)
+next: test.watson:3:1 Iadd (4 executed)
+next: test.watson:3:1 Iadd (4 executed)
+next: test.watson:1:1 Inew (0 executed)
+(empty)
+`
+	if diff := cmp.Diff(want, runScript(t, program, script)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSessionReportsErrors(t *testing.T) {
+	script := `step 2
+jump
+break 0
+delete 1
+`
+	want := `next: 1:1 Iadd (0 executed)
+error: Iadd: stack is empty at line 1, column 1 (stack: [])
+error: unknown command: jump (type help for the list of commands)
+error: invalid line: "0"
+error: no breakpoint 1
+`
+	out := &bytes.Buffer{}
+	d, err := NewDebugger(newLexer("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewSession(d, strings.NewReader(script), out).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSessionWritesPrompts(t *testing.T) {
+	out := &bytes.Buffer{}
+	d := newDebugger(t, program)
+	err := NewSession(d, strings.NewReader("step\n"), out, WithPrompt("(watson) ")).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "next: test.watson:1:1 Inew (0 executed)\n(watson) next: test.watson:1:2 Iinc (1 executed)\n(watson) "
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
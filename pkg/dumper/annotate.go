@@ -0,0 +1,139 @@
+package dumper
+
+import (
+	"strconv"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// maxAnnotatedStringLength is the maximum number of bytes of a string that are shown in an annotation.
+const maxAnnotatedStringLength = 64
+
+// WithAnnotations makes a Dumper write a comment before each value that tells its path from the root and its literal, like this:
+//
+//	// $.metadata.labels["app.kubernetes.io/name"]: "nginx"
+//
+// Comments are written only if the underlying writer is a `lexer.CommentWriter`, and they are decoded into nothing.
+// Objects and arrays are annotated as "object" and "array", and long strings are truncated.
+//
+// This needs to see the whole value before writing anything, so `DumpGo` converts its argument into `types.Value` first.
+// This is ignored if the Dumper is configured `WithDeduplication`, since shared values are not written where they appear.
+func WithAnnotations() DumperOption {
+	return dumperOption(func(d *Dumper) {
+		d.annotate = true
+	})
+}
+
+// dumpAnnotated writes v and its descendants, each of which follows its annotation.
+func (d *Dumper) dumpAnnotated(v *types.Value, path []byte) error {
+	err := d.writeAnnotation(path, v)
+	if err != nil {
+		return err
+	}
+	switch v.Kind {
+	case types.Object:
+		err = d.w.Write(vm.Onew)
+		if err != nil {
+			return err
+		}
+		for _, k := range d.sortedKeys(v.Object) {
+			val, _ := v.Object.Get(k)
+			err = d.dumpString([]byte(k))
+			if err != nil {
+				return err
+			}
+			err = d.dumpAnnotated(val, appendKey(path, k))
+			if err != nil {
+				return err
+			}
+			err = d.w.Write(vm.Oadd)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case types.Array:
+		err = d.w.Write(vm.Anew)
+		if err != nil {
+			return err
+		}
+		for i, elem := range v.Array {
+			err = d.dumpAnnotated(elem, appendIndex(path, i))
+			if err != nil {
+				return err
+			}
+			err = d.w.Write(vm.Aadd)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return d.dump(v)
+	}
+}
+
+// writeAnnotation writes the annotation of v at path if the underlying writer supports comments.
+func (d *Dumper) writeAnnotation(path []byte, v *types.Value) error {
+	cw, ok := d.w.(lexer.CommentWriter)
+	if !ok {
+		return nil
+	}
+	text := append(path, ": "...)
+	text = appendLiteral(text, v)
+	return cw.WriteComment(string(text))
+}
+
+// appendKey appends the path to the member of an object at path whose key is k.
+// k is quoted unless it only consists of letters, digits and underscores.
+func appendKey(path []byte, k string) []byte {
+	plain := len(k) > 0
+	for i := 0; i < len(k); i++ {
+		c := k[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_') {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		path = append(path, '.')
+		return append(path, k...)
+	}
+	path = append(path, '[')
+	path = strconv.AppendQuote(path, k)
+	return append(path, ']')
+}
+
+// appendIndex appends the path to the i-th element of an array at path.
+func appendIndex(path []byte, i int) []byte {
+	path = append(path, '[')
+	path = strconv.AppendInt(path, int64(i), 10)
+	return append(path, ']')
+}
+
+func appendLiteral(buf []byte, v *types.Value) []byte {
+	switch v.Kind {
+	case types.Int:
+		return strconv.AppendInt(buf, v.Int, 10)
+	case types.Uint:
+		return strconv.AppendUint(buf, v.Uint, 10)
+	case types.Float:
+		return strconv.AppendFloat(buf, v.Float, 'g', -1, 64)
+	case types.String:
+		if len(v.String) > maxAnnotatedStringLength {
+			buf = strconv.AppendQuote(buf, string(v.String[:maxAnnotatedStringLength]))
+			return append(buf, "..."...)
+		}
+		return strconv.AppendQuote(buf, string(v.String))
+	case types.Object:
+		return append(buf, "object"...)
+	case types.Array:
+		return append(buf, "array"...)
+	case types.Bool:
+		return strconv.AppendBool(buf, v.Bool)
+	default:
+		return append(buf, "nil"...)
+	}
+}
@@ -0,0 +1,149 @@
+package dumper
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+func TestAnnotatedOutputHasTheSameOps(t *testing.T) {
+	val := annotatedSample()
+	for _, mode := range []lexer.Mode{lexer.A, lexer.S} {
+		want, err := dump(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := dumpAnnotated(val, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := lexAll(out, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestAnnotationsTellPathsAndLiterals(t *testing.T) {
+	for _, mode := range []lexer.Mode{lexer.A, lexer.S} {
+		out, err := dumpAnnotated(annotatedSample(), mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var comments []string
+		for _, line := range strings.Split(out, "\n") {
+			if line := fromFullwidth(line); strings.HasPrefix(line, "// ") {
+				comments = append(comments, strings.TrimPrefix(line, "// "))
+			}
+		}
+		want := []string{
+			`$: object`,
+			`$.name: "nginx"`,
+			`$["app.kubernetes.io/name"]: "Bu?$"`,
+			`$.ports: array`,
+			`$.ports[0]: 80`,
+			`$.ports[1]: 443`,
+			`$.ratio: 0.5`,
+			`$.enabled: true`,
+			`$.owner: nil`,
+			`$.long: "` + strings.Repeat("x", maxAnnotatedStringLength) + `"...`,
+		}
+		if diff := cmp.Diff(want, comments); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestDumpGoWithAnnotations(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	u := lexer.NewUnlexer(buf)
+	d := NewDumper(u, WithAnnotations())
+	err := d.DumpGo(map[string]interface{}{"a": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(fromFullwidth(buf.String()), "// $.a[1]: 2\n") {
+		t.Errorf("annotation not found: %q", buf.String())
+	}
+}
+
+func TestAnnotationsAreIgnoredIfWriterDoesNotSupportComments(t *testing.T) {
+	val := annotatedSample()
+	want, err := dump(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := dump(val, WithAnnotations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func annotatedSample() *types.Value {
+	obj := types.NewMap()
+	obj.Set("name", types.NewStringValue([]byte("nginx")))
+	obj.Set("app.kubernetes.io/name", types.NewStringValue([]byte("Bu?$")))
+	obj.Set("ports", types.NewArrayValue([]*types.Value{types.NewIntValue(80), types.NewUintValue(443)}))
+	obj.Set("ratio", types.NewFloatValue(0.5))
+	obj.Set("enabled", types.NewBoolValue(true))
+	obj.Set("owner", types.NewNilValue())
+	obj.Set("long", types.NewStringValue(bytes.Repeat([]byte("x"), maxAnnotatedStringLength+1)))
+	return types.NewOrderedObjectValue(obj)
+}
+
+func dumpAnnotated(val *types.Value, mode lexer.Mode) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	u := lexer.NewUnlexer(buf, lexer.WithInitialUnlexerMode(mode))
+	d := NewDumper(u, WithAnnotations())
+	err := d.Dump(val)
+	if err != nil {
+		return "", err
+	}
+	err = u.Flush()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func lexAll(src string, mode lexer.Mode) ([]vm.Op, error) {
+	l := lexer.NewLexer(strings.NewReader(src), lexer.WithInitialLexerMode(mode))
+	var ops []vm.Op
+	buf := make([]vm.Op, 256)
+	for {
+		n, err := l.ReadOps(buf)
+		ops = append(ops, buf[:n]...)
+		if err == io.EOF {
+			return ops, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fromFullwidth replaces fullwidth forms of ASCII characters with the original ones.
+func fromFullwidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 0xff01 <= r && r <= 0xff5e {
+			return r - 0xff01 + '!'
+		}
+		return r
+	}, s)
+}
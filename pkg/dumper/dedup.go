@@ -0,0 +1,403 @@
+package dumper
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// maxSharedCopies is the maximum number of copies of shared values that a Dumper keeps on the stack at once.
+// This keeps the stack of the VM from overflowing when a value is shared many times.
+const maxSharedCopies = 64
+
+// WithDeduplication makes a Dumper build a value that appears more than once in a container only once and duplicate it with Gdup.
+//
+// Since the VM can only access the top two values on the stack, the copies have to be made before the container is created;
+// they are kept right below the container and brought up with Gswp when they are used.
+// This means that a container can share only one value with its descendants, and it is shared only among a limited number of them (see maxSharedCopies).
+// The Dumper chooses the value that saves the most Ops for each container.
+// The output is still decoded to the same `types.Value`.
+//
+// This needs to see the whole value before writing anything, so `DumpGo` converts its argument into `types.Value` first.
+func WithDeduplication() DumperOption {
+	return dumperOption(func(d *Dumper) {
+		d.dedup = true
+	})
+}
+
+// node is a value that is going to be dumped with deduplication.
+type node struct {
+	v       *types.Value
+	class   int         // values that are encoded into the same Ops have the same class
+	size    int         // the number of Ops that are needed to build v without deduplication
+	members []*node     // elements of an array, or keys and values of an object in the order in which they are written
+	counts  map[int]int // the number of descendants of each class
+	gain    int         // the number of Ops that n saves by sharing a value by itself, or -1 if not computed yet
+}
+
+// contains reports whether n has a descendant of the given class.
+func (n *node) contains(class int) bool {
+	return n.counts[class] > 0
+}
+
+// sharer determines which values are shared and writes them.
+type sharer struct {
+	d       *Dumper
+	counter *opCounter
+	classes map[string]int
+	reps    []*node // a representative of each class
+	key     []byte
+}
+
+func (d *Dumper) dumpDeduplicated(v *types.Value) error {
+	counter := &opCounter{mode: d.w.Mode()}
+	// This dumper is used to measure the size of scalar values, so it must encode them in the same way as d.
+	cd := *d
+	cd.w = counter
+	cd.buf = nil
+	counter.d = &cd
+	s := &sharer{
+		d:       d,
+		counter: counter,
+		classes: make(map[string]int),
+	}
+	root := s.newNode(v)
+	return s.dump(root, -1, maxSharedCopies)
+}
+
+func (s *sharer) newNode(v *types.Value) *node {
+	n := &node{v: v, gain: -1}
+	switch v.Kind {
+	case types.Object:
+		for _, k := range s.d.sortedKeys(v.Object) {
+			val, _ := v.Object.Get(k)
+			n.members = append(n.members, s.newNode(types.NewStringValue([]byte(k))), s.newNode(val))
+		}
+	case types.Array:
+		for _, elem := range v.Array {
+			n.members = append(n.members, s.newNode(elem))
+		}
+	}
+
+	s.key = append(s.key[:0], byte(v.Kind))
+	switch v.Kind {
+	case types.Int:
+		s.key = appendUvarint(s.key, uint64(v.Int))
+	case types.Uint:
+		s.key = appendUvarint(s.key, v.Uint)
+	case types.Float:
+		s.key = appendUvarint(s.key, math.Float64bits(v.Float))
+	case types.String:
+		s.key = append(s.key, v.String...)
+	case types.Bool:
+		if v.Bool {
+			s.key = append(s.key, 1)
+		}
+	case types.Object, types.Array:
+		n.size = 1
+		n.counts = make(map[int]int)
+		for _, m := range n.members {
+			s.key = appendUvarint(s.key, uint64(m.class))
+			n.size += m.size
+			n.counts[m.class]++
+			for class, count := range m.counts {
+				n.counts[class] += count
+			}
+		}
+		// Oadd or Aadd
+		n.size += len(n.members)
+		if v.Kind == types.Object {
+			n.size -= len(n.members) / 2
+		}
+	}
+	if v.Kind != types.Object && v.Kind != types.Array {
+		n.size = s.counter.measure(v)
+	}
+
+	class, ok := s.classes[string(s.key)]
+	if !ok {
+		class = len(s.reps)
+		s.classes[string(s.key)] = class
+		s.reps = append(s.reps, n)
+	}
+	n.class = class
+	return n
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	size := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:size]...)
+}
+
+// consumer is a member of a container that takes a copy of a shared value.
+type consumer struct {
+	index int  // the index of the member in node.members
+	use   bool // whether the member is the shared value itself; otherwise the copy is passed to the member, which contains the shared value
+}
+
+// consumers returns the members of n that take copies of the value of the given class.
+// At most budget members take copies.
+func consumers(n *node, class, budget int) []consumer {
+	var cs []consumer
+	if n.v.Kind == types.Array {
+		for i, elem := range n.members {
+			if len(cs) == budget {
+				break
+			}
+			if elem.class == class {
+				cs = append(cs, consumer{index: i, use: true})
+			} else if elem.contains(class) {
+				cs = append(cs, consumer{index: i})
+			}
+		}
+		return cs
+	}
+	for i := 0; i < len(n.members); i += 2 {
+		if len(cs) == budget {
+			break
+		}
+		// Only one of a key and a value can take a copy because the copy is buried under the key after it is pushed.
+		key, val := n.members[i], n.members[i+1]
+		if key.class == class {
+			cs = append(cs, consumer{index: i, use: true})
+		} else if val.class == class {
+			cs = append(cs, consumer{index: i + 1, use: true})
+		} else if val.contains(class) {
+			cs = append(cs, consumer{index: i + 1})
+		}
+	}
+	return cs
+}
+
+// plan reports how many occurrences of the value of the given class are replaced with its copies in n,
+// and how many extra Ops are needed to make and move the copies, assuming that a copy is right below n.
+func (s *sharer) plan(n *node, class, budget int) (uses, extra int) {
+	cs := consumers(n, class, budget)
+	if len(cs) == 0 {
+		return 0, 0
+	}
+	// Gdup
+	extra = len(cs) - 1
+	for _, c := range cs {
+		if n.v.Kind == types.Array || c.index%2 == 0 {
+			// Gswp
+			extra++
+		} else {
+			// Gswp (key) Gswp
+			extra += 2
+		}
+		if c.use {
+			uses++
+			if n.v.Kind == types.Object && c.index%2 == 0 && n.members[c.index+1].class == class {
+				// The value is the same as the key: Gswp Gdup Oadd
+				uses++
+				extra++
+			}
+			continue
+		}
+		// The member can't share another value by itself.
+		member := n.members[c.index]
+		subUses, subExtra := s.plan(member, class, subBudget(budget, len(cs)))
+		uses += subUses
+		extra += subExtra + s.gain(member)
+	}
+	return uses, extra
+}
+
+// subBudget returns the budget for a member that takes a copy.
+// It can keep its own copies in addition to the ones that are not used yet.
+func subBudget(budget, consumers int) int {
+	return budget - consumers + 1
+}
+
+// gain returns the number of Ops that n saves if it shares the value that it chooses.
+func (s *sharer) gain(n *node) int {
+	if n.gain < 0 {
+		_, n.gain = s.choose(n, maxSharedCopies)
+	}
+	return n.gain
+}
+
+// choose returns the class of the value that n should share and the number of Ops that it saves.
+// The class is -1 if sharing nothing is the best.
+func (s *sharer) choose(n *node, budget int) (int, int) {
+	if budget < 2 {
+		return -1, 0
+	}
+	type candidate struct {
+		class, bound int
+	}
+	var candidates []candidate
+	for class, count := range n.counts {
+		if count < 2 {
+			continue
+		}
+		candidates = append(candidates, candidate{class: class, bound: (count - 1) * s.reps[class].size})
+	}
+	// Candidates are sorted by the upper bound of the Ops that they save, so the search can stop early.
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].bound != candidates[j].bound {
+			return candidates[i].bound > candidates[j].bound
+		}
+		return candidates[i].class < candidates[j].class
+	})
+	best, saved := -1, 0
+	for _, c := range candidates {
+		if c.bound <= saved {
+			break
+		}
+		uses, extra := s.plan(n, c.class, budget)
+		if uses < 2 {
+			continue
+		}
+		if gain := (uses-1)*s.reps[c.class].size - extra; gain > saved {
+			best, saved = c.class, gain
+		}
+	}
+	return best, saved
+}
+
+// dump writes n. If shared is not negative, a copy of the value of that class is right below n, and n must use it.
+// n and its descendants keep at most budget copies on the stack at once.
+func (s *sharer) dump(n *node, shared, budget int) error {
+	if n.v.Kind != types.Object && n.v.Kind != types.Array {
+		return s.d.dump(n.v)
+	}
+	var err error
+	if shared < 0 {
+		shared, _ = s.choose(n, budget)
+		if shared >= 0 {
+			err = s.dump(s.reps[shared], -1, budget)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	var cs []consumer
+	if shared >= 0 {
+		cs = consumers(n, shared, budget)
+		for i := 1; i < len(cs); i++ {
+			err = s.d.w.Write(vm.Gdup)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if n.v.Kind == types.Array {
+		return s.dumpArray(n, shared, cs, budget)
+	}
+	return s.dumpObject(n, shared, cs, budget)
+}
+
+// dumpArray writes the elements of n in the same way as plan.
+func (s *sharer) dumpArray(n *node, shared int, cs []consumer, budget int) error {
+	total := len(cs)
+	err := s.d.w.Write(vm.Anew)
+	if err != nil {
+		return err
+	}
+	for i, elem := range n.members {
+		if len(cs) > 0 && cs[0].index == i {
+			// [copy, array] -> [array, copy]
+			err = s.d.w.Write(vm.Gswp)
+			if err == nil && !cs[0].use {
+				err = s.dump(elem, shared, subBudget(budget, total))
+			}
+			cs = cs[1:]
+		} else {
+			err = s.dump(elem, -1, budget-len(cs))
+		}
+		if err != nil {
+			return err
+		}
+		err = s.d.w.Write(vm.Aadd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpObject writes the members of n in the same way as plan.
+func (s *sharer) dumpObject(n *node, shared int, cs []consumer, budget int) error {
+	total := len(cs)
+	err := s.d.w.Write(vm.Onew)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(n.members); i += 2 {
+		key, val := n.members[i], n.members[i+1]
+		switch {
+		case len(cs) > 0 && cs[0].index == i:
+			// [copy, object] -> [object, copy]
+			err = s.d.w.Write(vm.Gswp)
+			if err == nil && val.class == shared {
+				// [object, copy] -> [object, copy, copy]
+				err = s.d.w.Write(vm.Gdup)
+			} else if err == nil {
+				err = s.dump(val, -1, budget-len(cs))
+			}
+			cs = cs[1:]
+		case len(cs) > 0 && cs[0].index == i+1:
+			// [copy, object] -> [object, copy] -> [object, copy, key] -> [object, key, copy]
+			err = s.d.w.Write(vm.Gswp)
+			if err == nil {
+				err = s.dump(key, -1, budget)
+			}
+			if err == nil {
+				err = s.d.w.Write(vm.Gswp)
+			}
+			if err == nil && !cs[0].use {
+				err = s.dump(val, shared, subBudget(budget, total))
+			}
+			cs = cs[1:]
+		default:
+			err = s.dump(key, -1, budget)
+			if err == nil {
+				err = s.dump(val, -1, budget-len(cs))
+			}
+		}
+		if err != nil {
+			return err
+		}
+		err = s.d.w.Write(vm.Oadd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// opCounter is a `lexer.OpWriter` that only counts Ops.
+type opCounter struct {
+	d    *Dumper
+	n    int
+	mode lexer.Mode
+}
+
+func (c *opCounter) Write(vm.Op) error {
+	c.n++
+	return nil
+}
+
+func (c *opCounter) WriteOps(ops []vm.Op) error {
+	c.n += len(ops)
+	return nil
+}
+
+func (c *opCounter) Mode() lexer.Mode {
+	return c.mode
+}
+
+// measure returns the number of Ops that are needed to build a scalar value v.
+func (c *opCounter) measure(v *types.Value) int {
+	c.n = 0
+	// Writing to opCounter never fails.
+	_ = c.d.dump(v)
+	return c.n
+}
@@ -0,0 +1,171 @@
+package dumper
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// assertDeduplicatedIsCorrect checks that v is decoded to the same value with deduplication, and that the output is not longer than usual.
+// It returns the numbers of Ops with and without deduplication.
+func assertDeduplicatedIsCorrect(t *testing.T, v *types.Value, opts ...DumperOption) (int, int) {
+	t.Helper()
+	naive, err := dump(v, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deduplicated, err := dump(v, append(opts, WithDeduplication())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deduplicated) > len(naive) {
+		t.Errorf("the deduplicated Ops are longer than the default ones: %d > %d", len(deduplicated), len(naive))
+	}
+	m := vm.NewVM()
+	err = m.FeedMulti(deduplicated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*types.Value{v}
+	if diff := cmp.Diff(want, m.Stack(), cmp.Comparer(sameFloat)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	return len(deduplicated), len(naive)
+}
+
+// randomValue generates a value that often contains the same values as the ones in pool.
+func randomValue(r *rand.Rand, depth int, pool *[]*types.Value) *types.Value {
+	var v *types.Value
+	switch {
+	case len(*pool) > 0 && r.Intn(3) == 0:
+		return (*pool)[r.Intn(len(*pool))]
+	case depth > 0 && r.Intn(2) == 0:
+		obj := types.NewObjectValue(nil)
+		n := r.Intn(5)
+		for i := 0; i < n; i++ {
+			obj.Object.Set(strconv.Itoa(r.Intn(4)), randomValue(r, depth-1, pool))
+		}
+		v = obj
+	case depth > 0 && r.Intn(2) == 0:
+		n := r.Intn(5)
+		arr := make([]*types.Value, 0, n)
+		for i := 0; i < n; i++ {
+			arr = append(arr, randomValue(r, depth-1, pool))
+		}
+		v = types.NewArrayValue(arr)
+	default:
+		switch r.Intn(6) {
+		case 0:
+			v = types.NewIntValue(r.Int63n(1000) - 500)
+		case 1:
+			v = types.NewUintValue(r.Uint64())
+		case 2:
+			v = types.NewFloatValue(r.NormFloat64())
+		case 3:
+			v = types.NewStringValue([]byte(strconv.Itoa(r.Intn(100))))
+		case 4:
+			v = types.NewBoolValue(r.Intn(2) == 0)
+		default:
+			v = types.NewNilValue()
+		}
+	}
+	*pool = append(*pool, v)
+	return v
+}
+
+func TestDeduplicatedRandomValuesAreDecodedToTheSameValues(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		var pool []*types.Value
+		v := randomValue(r, 5, &pool)
+		assertDeduplicatedIsCorrect(t, v)
+		assertDeduplicatedIsCorrect(t, v, WithSortedKeys(), WithOptimizedNumbers())
+	}
+}
+
+func newManifest(name string) *types.Value {
+	labels := types.NewObjectValue(nil)
+	labels.Object.Set("app.kubernetes.io/name", types.NewStringValue([]byte(name)))
+	labels.Object.Set("app.kubernetes.io/part-of", types.NewStringValue([]byte("watson")))
+	metadata := types.NewObjectValue(nil)
+	metadata.Object.Set("name", types.NewStringValue([]byte(name)))
+	metadata.Object.Set("labels", labels)
+	selector := types.NewObjectValue(nil)
+	selector.Object.Set("matchLabels", labels)
+	template := types.NewObjectValue(nil)
+	template.Object.Set("metadata", metadata)
+	spec := types.NewObjectValue(nil)
+	spec.Object.Set("selector", selector)
+	spec.Object.Set("template", template)
+	manifest := types.NewObjectValue(nil)
+	manifest.Object.Set("metadata", metadata)
+	manifest.Object.Set("spec", spec)
+	return manifest
+}
+
+func TestDeduplicationSharesRepeatedSubtrees(t *testing.T) {
+	manifests := make([]*types.Value, 10)
+	for i := range manifests {
+		manifests[i] = newManifest("app" + strconv.Itoa(i))
+	}
+	got, naive := assertDeduplicatedIsCorrect(t, types.NewArrayValue(manifests))
+	if got*10 > naive*6 {
+		t.Errorf("expected to be less than 60%% of %d Ops but got %d", naive, got)
+	}
+}
+
+func TestDeduplicationDoesNotOverflowTheStack(t *testing.T) {
+	elems := make([]*types.Value, 1000)
+	for i := range elems {
+		elems[i] = newManifest("app")
+	}
+	v := types.NewArrayValue(elems)
+	ops, err := dump(v, WithDeduplication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := vm.NewVM(vm.WithStackSize(maxSharedCopies + 32))
+	err = m.FeedMulti(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*types.Value{v}, m.Stack()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeduplicationUsesAKeyAsAValue(t *testing.T) {
+	obj := types.NewObjectValue(nil)
+	obj.Object.Set("name", types.NewStringValue([]byte("name")))
+	v := types.NewArrayValue([]*types.Value{obj})
+	got, naive := assertDeduplicatedIsCorrect(t, v)
+	if got >= naive {
+		t.Errorf("expected to be shorter than %d Ops but got %d", naive, got)
+	}
+}
+
+func TestWithDeduplicationIsAppliedToDumpGo(t *testing.T) {
+	v := map[string]interface{}{"a": []string{"x", "x", "x"}, "b": []string{"x", "x", "x"}}
+	want, err := types.ToValue(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOps, err := dump(want, WithDeduplication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := lexer.NewSliceWriter()
+	err = NewDumper(w, WithDeduplication()).DumpGo(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(wantOps, w.Ops()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"math/bits"
+	"sort"
 
 	"github.com/genkami/watson/pkg/lexer"
 	"github.com/genkami/watson/pkg/types"
@@ -13,16 +14,69 @@ import (
 
 // Dumper dumps `types.Value` as a sequence of `types.Op`s.
 type Dumper struct {
-	w lexer.OpWriter
+	w              lexer.OpWriter
+	less           func(a, b string) bool
+	optimize       bool
+	dedup          bool
+	compactStrings bool
+	annotate       bool
+	parallelism    int
+	buf            []vm.Op // a buffer to write each scalar value at once
+}
+
+// DumperOption configures a Dumper.
+type DumperOption interface {
+	apply(*Dumper)
+}
+
+type dumperOption func(*Dumper)
+
+func (opt dumperOption) apply(d *Dumper) {
+	opt(d)
+}
+
+// WithKeyOrder makes a Dumper write members of objects in the order determined by less.
+// less reports whether the key a should be written before the key b.
+// Keys that are equivalent under less keep the order in which they were added to the object, so the output is deterministic as long as less is.
+// If less is nil, members are written in the order in which they were added to the object, which is the default.
+func WithKeyOrder(less func(a, b string) bool) DumperOption {
+	return dumperOption(func(d *Dumper) {
+		d.less = less
+	})
+}
+
+// WithSortedKeys makes a Dumper write members of objects in lexicographical order of their keys.
+func WithSortedKeys() DumperOption {
+	return WithKeyOrder(func(a, b string) bool {
+		return a < b
+	})
 }
 
 // NewDumper creates a new Dumper.
-func NewDumper(w lexer.OpWriter) *Dumper {
-	return &Dumper{w: w}
+// By default it writes members of objects in the order in which they were added, so that the same value is always dumped into the same sequence of `types.Op`s.
+func NewDumper(w lexer.OpWriter, opts ...DumperOption) *Dumper {
+	d := &Dumper{w: w}
+	for _, opt := range opts {
+		opt.apply(d)
+	}
+	return d
 }
 
 // Dump converts v into a sequence of `types.Op`s and writes it to the underlying writer `lexer.OpWriter`.
 func (d *Dumper) Dump(v *types.Value) error {
+	if d.dedup {
+		return d.dumpDeduplicated(v)
+	}
+	if d.annotate {
+		return d.dumpAnnotated(v, []byte("$"))
+	}
+	if d.shouldDumpInParallel(v) {
+		return d.dumpParallel(v)
+	}
+	return d.dump(v)
+}
+
+func (d *Dumper) dump(v *types.Value) error {
 	switch v.Kind {
 	case types.Int:
 		return d.dumpInt(uint64(v.Int))
@@ -45,116 +99,75 @@ func (d *Dumper) Dump(v *types.Value) error {
 	}
 }
 
-// dumpInt writes out a number from the most-significant to the least-significant bit.
-func (d *Dumper) dumpInt(n uint64) error {
-	var err error
-	err = d.w.Write(vm.Inew)
-	if err != nil {
-		return err
-	}
+// appendInt appends Ops that push n to ops. It writes out a number from the most-significant to the least-significant bit.
+func appendInt(ops []vm.Op, n uint64) []vm.Op {
+	ops = append(ops, vm.Inew)
 
 	if n == 0 {
-		return nil
+		return ops
 	}
 
 	msb := 63 - bits.LeadingZeros64(n)
 
 	// This bit is guaranteed to be one 1 and we can write it out directly.
-	err = d.w.Write(vm.Iinc)
-	if err != nil {
-		return err
-	}
+	ops = append(ops, vm.Iinc)
 
 	// Now we start checking from the next bit.
 	for i := msb - 1; i >= 0; i-- {
-		err = d.w.Write(vm.Ishl)
-		if err != nil {
-			return err
-		}
+		ops = append(ops, vm.Ishl)
 
 		mask := uint64(1) << i
 		if (n & mask) != 0 {
-			err = d.w.Write(vm.Iinc)
-			if err != nil {
-				return err
-			}
+			ops = append(ops, vm.Iinc)
 		}
 	}
 
-	return nil
+	return ops
+}
+
+func (d *Dumper) dumpInt(n uint64) error {
+	d.buf = d.appendNumber(d.buf[:0], n)
+	return d.w.WriteOps(d.buf)
 }
 
 func (d *Dumper) dumpUint(n uint64) error {
-	var err error
-	err = d.dumpInt(n)
-	if err != nil {
-		return err
-	}
-	err = d.w.Write(vm.Itou)
-	if err != nil {
-		return err
-	}
-	return nil
+	d.buf = d.appendNumber(d.buf[:0], n)
+	d.buf = append(d.buf, vm.Itou)
+	return d.w.WriteOps(d.buf)
 }
 
 func (d *Dumper) dumpFloat(x float64) error {
-	var err error
+	d.buf = d.buf[:0]
 	if math.IsNaN(x) {
 		return d.w.Write(vm.Fnan)
 	} else if math.IsInf(x, 1) {
 		return d.w.Write(vm.Finf)
 	} else if math.IsInf(x, -1) {
-		err = d.w.Write(vm.Finf)
-		if err != nil {
-			return err
-		}
-		err = d.w.Write(vm.Fneg)
-		if err != nil {
-			return err
-		}
-	}
-	err = d.dumpInt(math.Float64bits(x))
-	if err != nil {
-		return err
-	}
-	err = d.w.Write(vm.Itof)
-	if err != nil {
-		return err
+		d.buf = append(d.buf, vm.Finf, vm.Fneg)
 	}
-	return nil
+	d.buf = d.appendNumber(d.buf, math.Float64bits(x))
+	d.buf = append(d.buf, vm.Itof)
+	return d.w.WriteOps(d.buf)
 }
 
 func (d *Dumper) dumpString(s []byte) error {
-	var err error
-	err = d.w.Write(vm.Snew)
-	if err != nil {
-		return err
-	}
-	for _, c := range s {
-		err = d.dumpInt(uint64(c))
-		if err != nil {
-			return err
-		}
-		err = d.w.Write(vm.Sadd)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	d.buf = d.appendString(d.buf[:0], s)
+	return d.w.WriteOps(d.buf)
 }
 
-func (d *Dumper) dumpObject(obj map[string]*types.Value) error {
+func (d *Dumper) dumpObject(obj *types.Map) error {
 	var err error
 	err = d.w.Write(vm.Onew)
 	if err != nil {
 		return err
 	}
-	for k, v := range obj {
+	for _, k := range d.sortedKeys(obj) {
+		v, _ := obj.Get(k)
 		err = d.dumpString([]byte(k))
 		if err != nil {
 			return err
 		}
-		err = d.Dump(v)
+		err = d.dump(v)
 		if err != nil {
 			return err
 		}
@@ -166,6 +179,16 @@ func (d *Dumper) dumpObject(obj map[string]*types.Value) error {
 	return nil
 }
 
+func (d *Dumper) sortedKeys(obj *types.Map) []string {
+	keys := obj.Keys()
+	if d.less != nil {
+		sort.SliceStable(keys, func(i, j int) bool {
+			return d.less(keys[i], keys[j])
+		})
+	}
+	return keys
+}
+
 func (d *Dumper) dumpArray(arr []*types.Value) error {
 	var err error
 	err = d.w.Write(vm.Anew)
@@ -173,7 +196,7 @@ func (d *Dumper) dumpArray(arr []*types.Value) error {
 		return err
 	}
 	for _, v := range arr {
-		err = d.Dump(v)
+		err = d.dump(v)
 		if err != nil {
 			return err
 		}
@@ -119,6 +119,99 @@ func TestDumpObject(t *testing.T) {
 	})
 }
 
+func TestDumpObjectWritesKeysInTheOrderOfTheObjectByDefault(t *testing.T) {
+	obj := types.NewMap()
+	obj.Set("shrimp", types.NewIntValue(1))
+	obj.Set("ebi", types.NewIntValue(2))
+	obj.Set("tako", types.NewIntValue(3))
+	got, err := dump(types.NewOrderedObjectValue(obj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := dumpMembers(obj, []string{"shrimp", "ebi", "tako"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDumpObjectWritesKeysInLexicographicalOrderWithSortedKeys(t *testing.T) {
+	obj := types.NewMap()
+	obj.Set("shrimp", types.NewIntValue(1))
+	obj.Set("ebi", types.NewIntValue(2))
+	obj.Set("tako", types.NewIntValue(3))
+	got, err := dump(types.NewOrderedObjectValue(obj), WithSortedKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := dumpMembers(obj, []string{"ebi", "shrimp", "tako"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDumpObjectWritesKeysInTheGivenOrder(t *testing.T) {
+	obj := types.NewMap()
+	obj.Set("shrimp", types.NewIntValue(1))
+	obj.Set("tako", types.NewIntValue(2))
+	obj.Set("kani", types.NewIntValue(3))
+	obj.Set("ebi", types.NewIntValue(4))
+	byLength := func(a, b string) bool {
+		return len(a) < len(b)
+	}
+	got, err := dump(types.NewOrderedObjectValue(obj), WithKeyOrder(byLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := dumpMembers(obj, []string{"ebi", "tako", "kani", "shrimp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDumpObjectPreservesTheOrderOfKeys(t *testing.T) {
+	obj := types.NewMap()
+	obj.Set("shrimp", types.NewIntValue(1))
+	obj.Set("ebi", types.NewIntValue(2))
+	obj.Set("tako", types.NewIntValue(3))
+	got, err := encodeThenExecute(types.NewOrderedObjectValue(obj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"shrimp", "ebi", "tako"}
+	if diff := cmp.Diff(want, got.Object.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDumpObjectIsDeterministic(t *testing.T) {
+	obj := map[string]*types.Value{}
+	for i := 0; i < 100; i++ {
+		obj[fmt.Sprintf("key%d", i)] = types.NewIntValue(int64(i))
+	}
+	first, err := dump(types.NewObjectValue(obj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		got, err := dump(types.NewObjectValue(obj))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(first, got); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
 func TestDumpArray(t *testing.T) {
 	test := func(arr []*types.Value) {
 		orig := types.NewArrayValue(arr)
@@ -161,6 +254,41 @@ func TestDumpNil(t *testing.T) {
 	}
 }
 
+func dump(val *types.Value, opts ...DumperOption) ([]vm.Op, error) {
+	w := lexer.NewSliceWriter()
+	d := NewDumper(w, opts...)
+	err := d.Dump(val)
+	if err != nil {
+		return nil, err
+	}
+	return w.Ops(), nil
+}
+
+func dumpMembers(obj *types.Map, keys []string) ([]vm.Op, error) {
+	w := lexer.NewSliceWriter()
+	d := NewDumper(w)
+	err := w.Write(vm.Onew)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		err = d.Dump(types.NewStringValue([]byte(k)))
+		if err != nil {
+			return nil, err
+		}
+		v, _ := obj.Get(k)
+		err = d.Dump(v)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Write(vm.Oadd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return w.Ops(), nil
+}
+
 func encodeThenExecute(val *types.Value) (*types.Value, error) {
 	w := lexer.NewSliceWriter()
 	d := NewDumper(w)
@@ -0,0 +1,127 @@
+package dumper
+
+import (
+	"math/bits"
+
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// WithOptimizedNumbers makes a Dumper search for shorter sequences of Ops that push integers and floats,
+// instead of writing all bits one by one.
+// For example, 1.0 is written in 23 Ops instead of 73, and 1<<60 is written in 13 Ops instead of 62.
+// The search takes more time than the default encoding, so this is useful when the size of the output matters.
+func WithOptimizedNumbers() DumperOption {
+	return dumperOption(func(d *Dumper) {
+		d.optimize = true
+	})
+}
+
+// appendNumber appends Ops that push n to ops, using the optimized encoding if d is configured to do so.
+func (d *Dumper) appendNumber(ops []vm.Op, n uint64) []vm.Op {
+	if d.optimize {
+		return append(ops, optimizedInt(n)...)
+	}
+	return appendInt(ops, n)
+}
+
+// optimizedInt returns the shortest sequence of Ops that pushes n among some candidates.
+// The candidates are: Horner's method that handles runs of bits at once (see appendRuns),
+// pushing -n and negating it with Ineg,
+// pushing x and adding a copy of it made by Gdup if n == x + x<<k,
+// and pushing k and using its copy made by Gdup as a shift count if n == a<<k + k.
+// The sequence never touches values below the one that it pushes, so it can be used anywhere.
+func optimizedInt(n uint64) []vm.Op {
+	best := appendRuns(nil, n)
+	if int64(n) < 0 {
+		best = shorter(best, append(appendRuns(nil, -n), vm.Ineg))
+	}
+	for k := 1; k <= 32; k++ {
+		x := n & (1<<uint(k) - 1)
+		if x == 0 || n != x|x<<uint(k) {
+			continue
+		}
+		ops := append(optimizedInt(x), vm.Gdup)
+		ops = appendShift(ops, k)
+		ops = append(ops, vm.Iadd)
+		best = shorter(best, ops)
+	}
+	for k := 2; k < 64; k++ {
+		m := n - uint64(k)
+		if m == 0 || bits.TrailingZeros64(m) < k {
+			continue
+		}
+		// [k, k, a] --Gswp--> [k, a, k] --Isht--> [k, a<<k] --Iadd--> [a<<k + k]
+		ops := append(appendInt(nil, uint64(k)), vm.Gdup)
+		ops = append(ops, optimizedInt(m>>uint(k))...)
+		ops = append(ops, vm.Gswp, vm.Isht, vm.Iadd)
+		best = shorter(best, ops)
+	}
+	return best
+}
+
+func shorter(a, b []vm.Op) []vm.Op {
+	if len(b) < len(a) {
+		return b
+	}
+	return a
+}
+
+// appendRuns appends Ops that push n by Horner's method, like appendInt.
+// Unlike appendInt, it handles each run of the same bits at once if it is shorter:
+// a run of r zeros shifts the value by r with Isht, and a run of r ones is added as ((v+1) << r) - 1.
+func appendRuns(ops []vm.Op, n uint64) []vm.Op {
+	ops = append(ops, vm.Inew)
+	first := true
+	for i := 63 - bits.LeadingZeros64(n); i >= 0; {
+		bit := n >> uint(i) & 1
+		r := 0
+		for ; i >= 0 && n>>uint(i)&1 == bit; i-- {
+			r++
+		}
+		if bit == 0 {
+			ops = appendShift(ops, r)
+			continue
+		}
+		// Shifting zero is not needed for the first run.
+		naive := 2*r - 1
+		if !first {
+			naive++
+		}
+		if naive <= 4+shiftLength(r) {
+			if first {
+				ops = append(ops, vm.Iinc)
+				r--
+			}
+			for ; r > 0; r-- {
+				ops = append(ops, vm.Ishl, vm.Iinc)
+			}
+		} else {
+			ops = append(ops, vm.Iinc)
+			ops = appendShift(ops, r)
+			ops = append(ops, vm.Ineg, vm.Iinc, vm.Ineg)
+		}
+		first = false
+	}
+	return ops
+}
+
+// appendShift appends Ops that shift the value on the top of the stack by r bits to the left.
+func appendShift(ops []vm.Op, r int) []vm.Op {
+	if r <= shiftLength(r) {
+		for ; r > 0; r-- {
+			ops = append(ops, vm.Ishl)
+		}
+		return ops
+	}
+	ops = appendInt(ops, uint64(r))
+	return append(ops, vm.Isht)
+}
+
+// shiftLength returns the number of Ops that appendShift appends.
+func shiftLength(r int) int {
+	isht := len(appendInt(nil, uint64(r))) + 1
+	if r <= isht {
+		return r
+	}
+	return isht
+}
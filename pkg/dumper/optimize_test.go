@@ -0,0 +1,121 @@
+package dumper
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// assertOptimizedNumberIsCorrect checks that the optimized Ops push the same value as the default ones, without touching the value below it.
+func assertOptimizedNumberIsCorrect(t *testing.T, val *types.Value) {
+	t.Helper()
+	naive, err := dump(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	optimized, err := dump(val, WithOptimizedNumbers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(optimized) > len(naive) {
+		t.Errorf("%#v: the optimized Ops are longer than the default ones: %d > %d", val, len(optimized), len(naive))
+	}
+	m := vm.NewVM()
+	err = m.Feed(vm.Nnew)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.FeedMulti(optimized)
+	if err != nil {
+		t.Fatalf("%#v: %v", val, err)
+	}
+	want := []*types.Value{types.NewNilValue(), val}
+	if diff := cmp.Diff(want, m.Stack(), cmp.Comparer(sameFloat)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// sameFloat compares floats bit by bit so that NaNs and negative zeros are compared correctly.
+func sameFloat(a, b float64) bool {
+	return math.Float64bits(a) == math.Float64bits(b)
+}
+
+// randomInts returns integers of various bit lengths and bit patterns.
+func randomInts(r *rand.Rand) []uint64 {
+	var ns []uint64
+	for bits := 1; bits <= 64; bits++ {
+		for i := 0; i < 10; i++ {
+			n := r.Uint64() >> uint(64-bits)
+			sparse := n & r.Uint64() & r.Uint64()
+			dense := n | r.Uint64()>>uint(64-bits)
+			ns = append(ns, n, sparse, dense)
+		}
+		n := r.Uint64() >> uint(64-bits/2)
+		ns = append(ns, n|n<<uint(bits/2))
+	}
+	return ns
+}
+
+func TestOptimizedIntsEvaluateToTheSameValues(t *testing.T) {
+	ns := []uint64{0, 1, 2, 3, 1 << 60, 1<<63 - 1, 1 << 63, math.MaxUint64, 0x5555555555555555, 1<<40 + 40}
+	ns = append(ns, randomInts(rand.New(rand.NewSource(1)))...)
+	for _, n := range ns {
+		assertOptimizedNumberIsCorrect(t, types.NewIntValue(int64(n)))
+		assertOptimizedNumberIsCorrect(t, types.NewIntValue(-int64(n)))
+		assertOptimizedNumberIsCorrect(t, types.NewUintValue(n))
+	}
+}
+
+func TestOptimizedFloatsEvaluateToTheSameValues(t *testing.T) {
+	xs := []float64{
+		0, math.Copysign(0, -1), 1, -1, 0.5, 1.5, 3.14, 1e100, -1e-100,
+		math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(1), math.NaN(),
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		xs = append(xs, r.NormFloat64()*1e6, float64(r.Intn(1000))/8, float64(float32(r.Float64())))
+	}
+	for _, x := range xs {
+		assertOptimizedNumberIsCorrect(t, types.NewFloatValue(x))
+	}
+}
+
+func TestOptimizedNumbersAreShorter(t *testing.T) {
+	test := func(val *types.Value, want int) {
+		t.Helper()
+		ops, err := dump(val, WithOptimizedNumbers())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ops) != want {
+			t.Errorf("%#v: expected %d Ops but got %d", val, want, len(ops))
+		}
+	}
+	test(types.NewFloatValue(1), 23)
+	test(types.NewIntValue(1<<60), 13)
+	test(types.NewIntValue(-1), 3)
+	test(types.NewUintValue(math.MaxUint64), 4)
+}
+
+func TestWithOptimizedNumbersIsAppliedToDumpGo(t *testing.T) {
+	w := lexer.NewSliceWriter()
+	err := NewDumper(w, WithOptimizedNumbers()).DumpGo([]interface{}{int8(-1), 1.0, uint(1 << 60)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := dump(types.NewArrayValue([]*types.Value{
+		types.NewIntValue(-1), types.NewFloatValue(1), types.NewUintValue(1 << 60),
+	}), WithOptimizedNumbers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, w.Ops()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,160 @@
+package dumper
+
+import (
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// WithParallelism makes a Dumper dump the elements of the outermost array, or the members of the outermost object, on n goroutines.
+//
+// Each of them is dumped into its own buffer, and the buffers are written to the underlying writer in the same order as they are written without this option,
+// so the output is exactly the same. If the underlying writer is a `*lexer.Unlexer`, each buffer is also converted into characters on those goroutines
+// from both modes, and the characters are chosen by the parity of Snews before it (see `lexer.UnlexedChunk`).
+//
+// If n is less than or equal to one, values are dumped on the calling goroutine, which is the default.
+// This is ignored if the Dumper is configured `WithDeduplication` or `WithAnnotations`.
+// Like them, this makes `DumpGo` convert its argument into `types.Value` first.
+func WithParallelism(n int) DumperOption {
+	return dumperOption(func(d *Dumper) {
+		d.parallelism = n
+	})
+}
+
+// part is an element of an array or a member of an object that is dumped on a worker.
+type part struct {
+	key   []byte // nil if the part is an element of an array
+	v     *types.Value
+	ready chan struct{}
+
+	ops   []vm.Op
+	chunk *lexer.UnlexedChunk // nil unless the underlying writer is an Unlexer
+	err   error
+}
+
+// opBuffer is a lexer.OpWriter that keeps Ops in memory.
+type opBuffer struct {
+	ops []vm.Op
+}
+
+func (b *opBuffer) Write(op vm.Op) error {
+	b.ops = append(b.ops, op)
+	return nil
+}
+
+func (b *opBuffer) WriteOps(ops []vm.Op) error {
+	b.ops = append(b.ops, ops...)
+	return nil
+}
+
+// Mode always returns lexer.A since the mode in which the buffer is written is not known.
+func (b *opBuffer) Mode() lexer.Mode {
+	return lexer.A
+}
+
+func (d *Dumper) shouldDumpInParallel(v *types.Value) bool {
+	return d.parallelism > 1 && (v.Kind == types.Object || v.Kind == types.Array)
+}
+
+// dumpParallel dumps v, which is an object or an array, in the way described in WithParallelism.
+func (d *Dumper) dumpParallel(v *types.Value) error {
+	u, unlex := d.w.(*lexer.Unlexer)
+	var err error
+	if v.Kind == types.Object {
+		err = d.w.Write(vm.Onew)
+	} else {
+		err = d.w.Write(vm.Anew)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Parts that are dumped ahead are limited by the capacity of pending.
+	pending := make(chan *part, d.parallelism*2)
+	jobs := make(chan *part, d.parallelism*2)
+	done := make(chan struct{})
+	defer close(done)
+	for i := 0; i < d.parallelism; i++ {
+		go func() {
+			for p := range jobs {
+				d.dumpPart(p, unlex)
+				close(p.ready)
+			}
+		}()
+	}
+	go d.splitParts(v, pending, jobs, done)
+
+	for p := range pending {
+		<-p.ready
+		if p.err != nil {
+			return p.err
+		}
+		if unlex {
+			err = u.WriteChunk(p.chunk)
+		} else {
+			err = d.w.WriteOps(p.ops)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitParts sends each part of v both to pending and to jobs, in the order in which they are written.
+func (d *Dumper) splitParts(v *types.Value, pending, jobs chan<- *part, done <-chan struct{}) {
+	defer close(pending)
+	defer close(jobs)
+	send := func(p *part) bool {
+		select {
+		case pending <- p:
+		case <-done:
+			return false
+		}
+		// Every part in pending is sent to jobs so that the reader never waits for a part that is not dumped.
+		jobs <- p
+		return true
+	}
+	if v.Kind == types.Object {
+		for _, k := range d.sortedKeys(v.Object) {
+			val, _ := v.Object.Get(k)
+			if !send(&part{key: []byte(k), v: val, ready: make(chan struct{})}) {
+				return
+			}
+		}
+		return
+	}
+	for _, elem := range v.Array {
+		if !send(&part{v: elem, ready: make(chan struct{})}) {
+			return
+		}
+	}
+}
+
+// dumpPart dumps p into its buffer with a copy of d.
+func (d *Dumper) dumpPart(p *part, unlex bool) {
+	buf := &opBuffer{}
+	sub := *d
+	sub.w = buf
+	sub.buf = nil
+	sub.parallelism = 0
+	if p.key != nil {
+		p.err = sub.dumpString(p.key)
+		if p.err != nil {
+			return
+		}
+	}
+	p.err = sub.dump(p.v)
+	if p.err != nil {
+		return
+	}
+	if p.key != nil {
+		buf.Write(vm.Oadd)
+	} else {
+		buf.Write(vm.Aadd)
+	}
+	p.ops = buf.ops
+	if unlex {
+		p.chunk = lexer.UnlexChunk(p.ops)
+	}
+}
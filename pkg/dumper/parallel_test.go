@@ -0,0 +1,125 @@
+package dumper
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+)
+
+// assertParallelOutputIsTheSame dumps v with and without WithParallelism, and checks that they write the same characters.
+func assertParallelOutputIsTheSame(t *testing.T, v *types.Value, opts ...DumperOption) {
+	t.Helper()
+	for _, mode := range []lexer.Mode{lexer.A, lexer.S} {
+		want, err := unlex(v, mode, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := unlex(v, mode, append(opts, WithParallelism(3))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func unlex(v *types.Value, mode lexer.Mode, opts ...DumperOption) (string, error) {
+	buf := &bytes.Buffer{}
+	u := lexer.NewUnlexer(buf, lexer.WithInitialUnlexerMode(mode), lexer.WithUnlexerBufferSize(16))
+	err := NewDumper(u, opts...).Dump(v)
+	if err != nil {
+		return "", err
+	}
+	err = u.Flush()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func TestParallelDumpWritesTheSameCharacters(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		var pool []*types.Value
+		v := randomValue(r, 5, &pool)
+		assertParallelOutputIsTheSame(t, v)
+		assertParallelOutputIsTheSame(t, v, WithSortedKeys(), WithOptimizedNumbers(), WithCompactStrings())
+	}
+}
+
+func TestParallelDumpWritesTheSameOps(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		var pool []*types.Value
+		v := randomValue(r, 5, &pool)
+		want, err := dump(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := dump(v, WithParallelism(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestWithParallelismIsAppliedToDumpGo(t *testing.T) {
+	assertDumpGoIsSameAsDump(t, newPerson(), WithParallelism(2))
+	assertDumpGoIsSameAsDump(t, []interface{}{1, "a", []int{2, 3}, map[string]bool{"b": true}}, WithParallelism(2))
+}
+
+type failingWriter struct {
+	n int // the number of bytes that can be written before failing
+}
+
+var errFailingWriter = errors.New("failing writer")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errFailingWriter
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestParallelDumpStopsWhenWriterFails(t *testing.T) {
+	arr := make([]*types.Value, 1000)
+	for i := range arr {
+		arr[i] = types.NewStringValue([]byte("hello"))
+	}
+	u := lexer.NewUnlexer(&failingWriter{n: 100}, lexer.WithUnlexerBufferSize(16))
+	err := NewDumper(u, WithParallelism(2)).Dump(types.NewArrayValue(arr))
+	if err != errFailingWriter {
+		t.Errorf("expected %v but got %v", errFailingWriter, err)
+	}
+}
+
+func BenchmarkParallelDump(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	arr := make([]*types.Value, 1000)
+	for i := range arr {
+		var pool []*types.Value
+		arr[i] = randomValue(r, 4, &pool)
+	}
+	v := types.NewArrayValue(arr)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		u := lexer.NewUnlexer(&bytes.Buffer{})
+		err := NewDumper(u, WithParallelism(4), WithOptimizedNumbers()).Dump(v)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package dumper
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+var marshalerType = reflect.TypeOf((*types.Marshaler)(nil)).Elem()
+
+// DumpGo converts an arbitrary Go value into a sequence of `vm.Op`s in the same way as `d.Dump` does for the result of `types.ToValue(v)`,
+// but it writes Ops while walking v instead of building a `types.Value` first.
+// Only the result of `types.Marshaler`s and the keys of objects are kept in memory.
+//
+// Unlike `types.ToValue`, this can fail after writing a part of v.
+// If d is configured `WithDeduplication`, `WithAnnotations` or `WithParallelism`, it converts v into `types.Value` first.
+func (d *Dumper) DumpGo(v interface{}) error {
+	if d.dedup || d.annotate || d.parallelism > 1 {
+		val, err := types.ToValue(v)
+		if err != nil {
+			return err
+		}
+		return d.Dump(val)
+	}
+	if v == nil {
+		return d.dumpNil()
+	}
+	switch v := v.(type) {
+	case bool:
+		return d.dumpBool(v)
+	case int:
+		return d.dumpInt(uint64(v))
+	case int8:
+		return d.dumpInt(uint64(v))
+	case int16:
+		return d.dumpInt(uint64(v))
+	case int32:
+		return d.dumpInt(uint64(v))
+	case int64:
+		return d.dumpInt(uint64(v))
+	case uint:
+		return d.dumpUint(uint64(v))
+	case uint8:
+		return d.dumpUint(uint64(v))
+	case uint16:
+		return d.dumpUint(uint64(v))
+	case uint32:
+		return d.dumpUint(uint64(v))
+	case uint64:
+		return d.dumpUint(v)
+	case string:
+		return d.dumpGoString(v)
+	case float32:
+		return d.dumpFloat(float64(v))
+	case float64:
+		return d.dumpFloat(v)
+	}
+	if marshaler, ok := v.(types.Marshaler); ok {
+		val, err := marshaler.MarshalWatson()
+		if err != nil {
+			return err
+		}
+		return d.Dump(val)
+	}
+	return d.DumpByReflection(reflect.ValueOf(v))
+}
+
+// DumpByReflection does almost the same thing as `DumpGo`, but it always uses reflection.
+func (d *Dumper) DumpByReflection(v reflect.Value) error {
+	// The order of cases is the same as `types.ToValueByReflection`.
+	switch {
+	case v.Type().Implements(marshalerType):
+		return d.dumpMarshalerByReflection(v)
+	case isKindOf(v, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64):
+		return d.dumpInt(uint64(v.Int()))
+	case isKindOf(v, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64):
+		return d.dumpUint(v.Uint())
+	case isKindOf(v, reflect.Float32, reflect.Float64):
+		return d.dumpFloat(v.Float())
+	case isKindOf(v, reflect.Bool):
+		return d.dumpBool(v.Bool())
+	case isKindOf(v, reflect.String):
+		return d.dumpGoString(v.String())
+	case isKindOf(v, reflect.Array):
+		return d.dumpArrayByReflection(v)
+	case isKindOf(v, reflect.Struct):
+		return d.dumpStructByReflection(v)
+	case isKindOf(v, reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice) && v.IsNil():
+		return d.dumpNil()
+	case isKindOf(v, reflect.Ptr):
+		return d.dumpElemByReflection(v.Elem())
+	case isKindOf(v, reflect.Map):
+		return d.dumpMapByReflection(v)
+	case isKindOf(v, reflect.Slice):
+		return d.dumpArrayByReflection(v)
+	}
+	return fmt.Errorf("can't convert %s to *Value", v.Type().String())
+}
+
+func isKindOf(v reflect.Value, kinds ...reflect.Kind) bool {
+	k := v.Kind()
+	for _, kind := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpElemByReflection dumps an element of a container in the same way as `types.ToValueByReflection` converts it.
+func (d *Dumper) dumpElemByReflection(elem reflect.Value) error {
+	if elem.CanInterface() {
+		return d.DumpGo(elem.Interface())
+	}
+	return d.DumpByReflection(elem)
+}
+
+func (d *Dumper) dumpMarshalerByReflection(v reflect.Value) error {
+	marshal := v.MethodByName("MarshalWatson")
+	ret := marshal.Call([]reflect.Value{})
+	if err, ok := ret[1].Interface().(error); ok {
+		return err
+	}
+	return d.Dump(ret[0].Interface().(*types.Value))
+}
+
+func (d *Dumper) dumpGoString(s string) error {
+	if d.compactStrings {
+		return d.dumpString([]byte(s))
+	}
+	d.buf = append(d.buf[:0], vm.Snew)
+	for i := 0; i < len(s); i++ {
+		d.buf = appendInt(d.buf, uint64(s[i]))
+		d.buf = append(d.buf, vm.Sadd)
+	}
+	return d.w.WriteOps(d.buf)
+}
+
+func (d *Dumper) dumpArrayByReflection(v reflect.Value) error {
+	err := d.w.Write(vm.Anew)
+	if err != nil {
+		return err
+	}
+	size := v.Len()
+	for i := 0; i < size; i++ {
+		err = d.dumpElemByReflection(v.Index(i))
+		if err != nil {
+			return err
+		}
+		err = d.w.Write(vm.Aadd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// member is a member of an object that is going to be dumped.
+type member struct {
+	key  string
+	elem reflect.Value
+}
+
+func (d *Dumper) dumpMapByReflection(v reflect.Value) error {
+	members := make([]member, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key := iter.Key()
+		k, ok := key.Interface().(string)
+		if !ok {
+			return fmt.Errorf("can't convert %s to string", key.Type().String())
+		}
+		members = append(members, member{key: k, elem: iter.Value()})
+	}
+	// Keys are sorted in the same way as `types.ToValue`.
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].key < members[j].key
+	})
+	return d.dumpMembers(members)
+}
+
+func (d *Dumper) dumpStructByReflection(v reflect.Value) error {
+	if d.less != nil {
+		// All members are needed to sort them.
+		return d.dumpMembers(appendFields(nil, v))
+	}
+	err := d.w.Write(vm.Onew)
+	if err != nil {
+		return err
+	}
+	return d.dumpFields(v)
+}
+
+// dumpFields writes the fields of a struct v as members of the object on the top of the stack.
+func (d *Dumper) dumpFields(v reflect.Value) error {
+	for _, field := range types.Fields(v.Type()) {
+		elem := v.Field(field.Index)
+		if field.OmitEmpty && elem.IsZero() {
+			continue
+		}
+		var err error
+		if field.Inline {
+			err = d.dumpFields(elem)
+		} else {
+			err = d.dumpMember(field.Key, elem)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendFields appends the fields of a struct v to members in the same way as dumpFields writes them.
+func appendFields(members []member, v reflect.Value) []member {
+	for _, field := range types.Fields(v.Type()) {
+		elem := v.Field(field.Index)
+		if field.OmitEmpty && elem.IsZero() {
+			continue
+		}
+		if field.Inline {
+			members = appendFields(members, elem)
+		} else {
+			members = append(members, member{key: field.Key, elem: elem})
+		}
+	}
+	return members
+}
+
+// dumpMembers writes an object that consists of members. Members are written in the given order unless d has a key order.
+func (d *Dumper) dumpMembers(members []member) error {
+	if d.less != nil {
+		sort.SliceStable(members, func(i, j int) bool {
+			return d.less(members[i].key, members[j].key)
+		})
+	}
+	err := d.w.Write(vm.Onew)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		err = d.dumpMember(m.key, m.elem)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dumper) dumpMember(key string, elem reflect.Value) error {
+	err := d.dumpGoString(key)
+	if err != nil {
+		return err
+	}
+	err = d.dumpElemByReflection(elem)
+	if err != nil {
+		return err
+	}
+	return d.w.Write(vm.Oadd)
+}
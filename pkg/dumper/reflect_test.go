@@ -0,0 +1,197 @@
+package dumper
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+type name struct {
+	First string `watson:"first"`
+	Last  string `watson:"last,omitempty"`
+}
+
+type person struct {
+	Name     name `watson:",inline"`
+	Age      int  `watson:"age"`
+	Height   float32
+	Admin    bool   `watson:"admin,omitempty"`
+	Password string `watson:"-"`
+	secret   string
+	Tags     []string
+	Scores   [2]uint8
+	Friends  []*person
+	Attrs    map[string]interface{}
+	Note     interface{}
+	Label    label
+}
+
+type label string
+
+func (l label) MarshalWatson() (*types.Value, error) {
+	return types.NewStringValue([]byte("label:" + l)), nil
+}
+
+type failingMarshaler struct{}
+
+var errFailingMarshaler = errors.New("failed")
+
+func (failingMarshaler) MarshalWatson() (*types.Value, error) {
+	return nil, errFailingMarshaler
+}
+
+// assertDumpGoIsSameAsDump dumps v by both DumpGo and Dump, and checks that they write the same ops.
+func assertDumpGoIsSameAsDump(t *testing.T, v interface{}, opts ...DumperOption) {
+	t.Helper()
+	val, err := types.ToValue(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := dump(val, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := lexer.NewSliceWriter()
+	err = NewDumper(w, opts...).DumpGo(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, w.Ops()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func newPerson() *person {
+	return &person{
+		Name:     name{First: "Taro"},
+		Age:      41,
+		Height:   170.5,
+		Password: "password",
+		secret:   "secret",
+		Tags:     []string{"a", ""},
+		Scores:   [2]uint8{1, 255},
+		Friends:  []*person{{Name: name{First: "Hanako", Last: "Yamada"}, Admin: true}, nil},
+		Attrs:    map[string]interface{}{"z": int64(-1), "a": []interface{}{nil, 1.5}, "m": map[string]int{"k": 1}},
+		Note:     uint16(3),
+		Label:    "x",
+	}
+}
+
+func TestDumpGoConvertsPrimitives(t *testing.T) {
+	values := []interface{}{
+		nil, true, false, 0, int8(-1), int16(2), int32(-3), int64(4),
+		uint(5), uint8(6), uint16(7), uint32(8), uint64(9),
+		1.5, float32(-2.5), "", "hello", label("y"),
+	}
+	for _, v := range values {
+		assertDumpGoIsSameAsDump(t, v)
+	}
+}
+
+func TestDumpGoConvertsStructs(t *testing.T) {
+	assertDumpGoIsSameAsDump(t, newPerson())
+	assertDumpGoIsSameAsDump(t, *newPerson())
+	assertDumpGoIsSameAsDump(t, person{})
+}
+
+func TestDumpGoConvertsStructsWithKeyOrder(t *testing.T) {
+	assertDumpGoIsSameAsDump(t, newPerson(), WithSortedKeys())
+	assertDumpGoIsSameAsDump(t, newPerson(), WithKeyOrder(func(a, b string) bool {
+		return len(a) < len(b)
+	}))
+}
+
+func TestDumpGoConvertsContainers(t *testing.T) {
+	assertDumpGoIsSameAsDump(t, []interface{}{1, "a", []int{2, 3}, map[string]bool{}})
+	assertDumpGoIsSameAsDump(t, map[string]*person{"b": newPerson(), "a": nil})
+	assertDumpGoIsSameAsDump(t, [0]int{})
+	assertDumpGoIsSameAsDump(t, []int(nil))
+	assertDumpGoIsSameAsDump(t, map[string]int(nil))
+}
+
+func TestDumpGoFailsIfAValueCantBeConverted(t *testing.T) {
+	w := lexer.NewSliceWriter()
+	err := NewDumper(w).DumpGo(map[int]int{1: 2})
+	if err == nil {
+		t.Error("expected an error but got nil")
+	}
+	err = NewDumper(w).DumpGo([]interface{}{make(chan int)})
+	if err == nil {
+		t.Error("expected an error but got nil")
+	}
+	err = NewDumper(w).DumpGo(map[string]interface{}{"a": failingMarshaler{}})
+	if !errors.Is(err, errFailingMarshaler) {
+		t.Errorf("expected %v but got %v", errFailingMarshaler, err)
+	}
+}
+
+func TestDumpGoWritesWhatExecutesToToValue(t *testing.T) {
+	want, err := types.ToValue(newPerson())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := lexer.NewSliceWriter()
+	err = NewDumper(w).DumpGo(newPerson())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := vm.NewVM()
+	err = m.FeedMulti(w.Ops())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Top()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func BenchmarkDumpGo(b *testing.B) {
+	people := make([]*person, 100)
+	for i := range people {
+		people[i] = newPerson()
+	}
+	w := lexer.NewUnlexer(discard{})
+	d := NewDumper(w)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := d.DumpGo(people)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDumpToValue(b *testing.B) {
+	people := make([]*person, 100)
+	for i := range people {
+		people[i] = newPerson()
+	}
+	w := lexer.NewUnlexer(discard{})
+	d := NewDumper(w)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		val, err := types.ToValue(people)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = d.Dump(val)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
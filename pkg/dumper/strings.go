@@ -0,0 +1,199 @@
+package dumper
+
+import (
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// WithCompactStrings makes a Dumper write strings in fewer Ops.
+//
+// Since Sadd only uses the lowest 8 bits of an integer, each byte is pushed as the shortest integer that has the same lowest 8 bits (e.g. 255 is pushed as -1).
+// In addition, copies of bytes are made with Gdup before the string is created, and each of them is brought up with Gswp when it is appended.
+// Since the VM can only access the top two values on the stack, the copies have to be used in the reverse order of the one in which they are made,
+// so the Dumper chooses which bytes to copy so that the output is the shortest.
+// A string uses at most maxSharedCopies slots of the stack for the copies.
+func WithCompactStrings() DumperOption {
+	return dumperOption(func(d *Dumper) {
+		d.compactStrings = true
+	})
+}
+
+// shortestBytes[c] is the shortest sequence of Ops that pushes an integer whose lowest 8 bits are c.
+var shortestBytes = searchShortestBytes()
+
+// searchShortestBytes finds the shortest sequences of Ops that push each byte by breadth-first search.
+// Since the lowest 8 bits of the results of Iinc, Ishl, Ineg and Iadd only depend on the lowest 8 bits of their operands,
+// it is enough to search stacks of bytes. Stacks have at most two bytes.
+func searchShortestBytes() [256][]vm.Op {
+	// A stack [a] is represented as a, and a stack [a, b] is represented as 256 + a*256 + b.
+	const size = 256 + 256*256
+	type edge struct {
+		prev int
+		op   vm.Op
+	}
+	edges := make([]edge, size)
+	visited := make([]bool, size)
+	queue := []int{0}
+	visited[0] = true
+	edges[0] = edge{prev: -1, op: vm.Inew}
+	visit := func(prev, next int, op vm.Op) {
+		if !visited[next] {
+			visited[next] = true
+			edges[next] = edge{prev: prev, op: op}
+			queue = append(queue, next)
+		}
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if s < 256 {
+			a := s
+			visit(s, (a+1)%256, vm.Iinc)
+			visit(s, a*2%256, vm.Ishl)
+			visit(s, (256-a)%256, vm.Ineg)
+			visit(s, 256+a*256+a, vm.Gdup)
+			visit(s, 256+a*256, vm.Inew)
+			continue
+		}
+		a, b := (s-256)/256, (s-256)%256
+		visit(s, 256+a*256+(b+1)%256, vm.Iinc)
+		visit(s, 256+a*256+b*2%256, vm.Ishl)
+		visit(s, 256+a*256+(256-b)%256, vm.Ineg)
+		visit(s, 256+b*256+a, vm.Gswp)
+		visit(s, (a+b)%256, vm.Iadd)
+	}
+	var table [256][]vm.Op
+	for c := range table {
+		var ops []vm.Op
+		for s := c; s >= 0; s = edges[s].prev {
+			ops = append(ops, edges[s].op)
+		}
+		for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+			ops[i], ops[j] = ops[j], ops[i]
+		}
+		table[c] = ops
+	}
+	return table
+}
+
+// appendString appends Ops that push s to ops.
+func (d *Dumper) appendString(ops []vm.Op, s []byte) []vm.Op {
+	if d.compactStrings {
+		return appendCompactString(ops, s)
+	}
+	ops = append(ops, vm.Snew)
+	for _, c := range s {
+		ops = appendInt(ops, uint64(c))
+		ops = append(ops, vm.Sadd)
+	}
+	return ops
+}
+
+// Decisions on how each byte is appended.
+const (
+	pushByte  byte = iota // push the byte and append it
+	startRun              // append a copy that is built from scratch
+	extendRun             // append a copy that is made by duplicating the previous copy
+)
+
+// appendCompactString appends Ops that push s in the way that `WithCompactStrings` describes.
+func appendCompactString(ops []vm.Op, s []byte) []vm.Op {
+	copied := chooseCopiedBytes(s)
+	var uses []byte
+	for i, c := range s {
+		if copied[i] {
+			uses = append(uses, c)
+		}
+	}
+	// The copy that is used first must be on the top.
+	for i := len(uses) - 1; i >= 0; i-- {
+		if i < len(uses)-1 && uses[i] == uses[i+1] {
+			ops = append(ops, vm.Gdup)
+		} else {
+			ops = append(ops, shortestBytes[uses[i]]...)
+		}
+	}
+	ops = append(ops, vm.Snew)
+	for i, c := range s {
+		if copied[i] {
+			// [copy, string] -> [string, copy]
+			ops = append(ops, vm.Gswp)
+		} else {
+			ops = append(ops, shortestBytes[c]...)
+		}
+		ops = append(ops, vm.Sadd)
+	}
+	return ops
+}
+
+// chooseCopiedBytes reports whether each byte in s should be appended as a copy.
+//
+// Appending a byte costs len(shortestBytes[c]) + 1 Ops if it is pushed directly.
+// Copies that are used consecutively form a run. The first copy in a run costs len(shortestBytes[c]) + 2 Ops (push, Gswp, Sadd),
+// and the rest of the copies in the run cost 3 Ops if they are the same byte (Gdup, Gswp, Sadd).
+// This finds the best choice by dynamic programming over the last copied byte.
+func chooseCopiedBytes(s []byte) []bool {
+	const none = 256
+	const inf = 1 << 60
+	// cost[v] + offset is the minimum cost of the bytes so far such that v is the last copied byte.
+	var cost [none + 1]int
+	for v := range cost {
+		cost[v] = inf
+	}
+	cost[none] = 0
+	offset, best, argBest := 0, 0, none
+	decisions := make([]byte, len(s))
+	from := make([]int, len(s))
+	for i, c := range s {
+		push := len(shortestBytes[c]) + 1
+		current := cost[c] + offset
+		next, decision := current+push, pushByte
+		if current < inf && current+3 < next {
+			next, decision = current+3, extendRun
+		}
+		if start := best + push + 1; start < next {
+			next, decision = start, startRun
+			from[i] = argBest
+		}
+		decisions[i] = decision
+		// All other bytes are pushed directly.
+		offset += push
+		cost[c] = next - offset
+		if next < best+push {
+			best, argBest = next, int(c)
+		} else {
+			best += push
+		}
+	}
+
+	copied := make([]bool, len(s))
+	v := argBest
+	for i := len(s) - 1; i >= 0; i-- {
+		if v != int(s[i]) {
+			continue
+		}
+		switch decisions[i] {
+		case extendRun:
+			copied[i] = true
+		case startRun:
+			copied[i] = true
+			v = from[i]
+		}
+	}
+	return limitCopies(copied)
+}
+
+// limitCopies makes only the first maxSharedCopies bytes be copied.
+func limitCopies(copied []bool) []bool {
+	n := 0
+	for i, c := range copied {
+		if !c {
+			continue
+		}
+		if n == maxSharedCopies {
+			copied[i] = false
+			continue
+		}
+		n++
+	}
+	return copied
+}
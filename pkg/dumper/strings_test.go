@@ -0,0 +1,108 @@
+package dumper
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// assertCompactStringIsCorrect checks that s is decoded to the same string with WithCompactStrings, and that the output is not longer than usual.
+// It returns the numbers of Ops with and without WithCompactStrings.
+func assertCompactStringIsCorrect(t *testing.T, s []byte, opts ...vm.VMOption) (int, int) {
+	t.Helper()
+	v := types.NewStringValue(s)
+	naive, err := dump(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compact, err := dump(v, WithCompactStrings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compact) > len(naive) {
+		t.Errorf("%q: the compact Ops are longer than the default ones: %d > %d", s, len(compact), len(naive))
+	}
+	m := vm.NewVM(opts...)
+	err = m.FeedMulti(compact)
+	if err != nil {
+		t.Fatalf("%q: %v", s, err)
+	}
+	if diff := cmp.Diff([]*types.Value{v}, m.Stack()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	return len(compact), len(naive)
+}
+
+func TestShortestBytesPushTheSameBytes(t *testing.T) {
+	for c, ops := range shortestBytes {
+		m := vm.NewVM()
+		err := m.FeedMulti(append([]vm.Op{vm.Snew}, append(ops, vm.Sadd)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := m.Top()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.String, []byte{byte(c)}) {
+			t.Errorf("expected %q but got %q", []byte{byte(c)}, got.String)
+		}
+	}
+}
+
+func TestCompactRandomStringsAreDecodedToTheSameStrings(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		s := make([]byte, r.Intn(300))
+		// Bytes are chosen from a small alphabet in some strings so that they repeat often.
+		alphabet := 1 + r.Intn(256)
+		for j := range s {
+			s[j] = byte(r.Intn(alphabet) - r.Intn(2)*128)
+		}
+		assertCompactStringIsCorrect(t, s)
+	}
+	assertCompactStringIsCorrect(t, []byte{})
+	assertCompactStringIsCorrect(t, []byte{0xff, 0xfe, 0x80, 0x00, 0xc3, 0x28})
+}
+
+func TestCompactStringsAreShorter(t *testing.T) {
+	got, naive := assertCompactStringIsCorrect(t, []byte("hello, world"))
+	if got*10 > naive*9 {
+		t.Errorf("expected to be less than 90%% of %d Ops but got %d", naive, got)
+	}
+	got, naive = assertCompactStringIsCorrect(t, bytes.Repeat([]byte{0xff}, 100))
+	if got*3 > naive {
+		t.Errorf("expected to be less than a third of %d Ops but got %d", naive, got)
+	}
+}
+
+func TestCompactStringsDoNotOverflowTheStack(t *testing.T) {
+	s := bytes.Repeat([]byte("abc"), 1000)
+	assertCompactStringIsCorrect(t, s, vm.WithStackSize(maxSharedCopies+2))
+}
+
+func TestWithCompactStringsIsAppliedToDumpGo(t *testing.T) {
+	v := map[string]interface{}{"key": "value\xff"}
+	val, err := types.ToValue(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := dump(val, WithCompactStrings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := lexer.NewSliceWriter()
+	err = NewDumper(w, WithCompactStrings()).DumpGo(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, w.Ops()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,62 @@
+package lexer
+
+import (
+	"unicode/utf8"
+)
+
+// CommentWriter is implemented by OpWriters that can write comments.
+// Comments are written as characters that do not correspond to any Op, so the lexer skips them.
+type CommentWriter interface {
+	WriteComment(text string) error
+}
+
+// commentPrefix is the prefix of each comment.
+const commentPrefix = "// "
+
+// fullwidthOffset is the difference between a printable ASCII character and its fullwidth form (e.g. 'A' and U+FF21).
+const fullwidthOffset = 0xff01 - '!'
+
+// WriteComment writes text as a comment on its own line, which starts with "// ".
+//
+// Since the lexer ignores any byte that does not correspond to an Op in its current mode, ASCII characters that correspond to Ops
+// are replaced with their fullwidth forms (e.g. 'B' is written as 'Ｂ' in mode A), and control characters are replaced with spaces.
+// The comment ends with a line break, so '\n' can't be used as a delimiter together with comments.
+func (u *Unlexer) WriteComment(text string) error {
+	atLineStart := u.lineStart
+	if len(u.buf) > 0 {
+		atLineStart = u.buf[len(u.buf)-1] == newline
+	}
+	if !atLineStart {
+		err := u.writeByte(newline)
+		if err != nil {
+			return err
+		}
+	}
+	for _, s := range []string{commentPrefix, text} {
+		for _, b := range appendComment(nil, u.mode, s) {
+			err := u.writeByte(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return u.writeByte(newline)
+}
+
+// appendComment appends text to buf, replacing characters that correspond to Ops in the given mode in the way that WriteComment describes.
+func appendComment(buf []byte, mode Mode, text string) []byte {
+	var tmp [utf8.UTFMax]byte
+	for _, r := range text {
+		switch {
+		case r < ' ' || r == utf8.RuneError || r == 0x7f:
+			buf = append(buf, ' ')
+		case r < utf8.RuneSelf && lookupTables[mode][r] != noOp:
+			n := utf8.EncodeRune(tmp[:], r+fullwidthOffset)
+			buf = append(buf, tmp[:n]...)
+		default:
+			n := utf8.EncodeRune(tmp[:], r)
+			buf = append(buf, tmp[:n]...)
+		}
+	}
+	return buf
+}
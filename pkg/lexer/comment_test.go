@@ -0,0 +1,91 @@
+package lexer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/genkami/watson/pkg/vm"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteCommentReplacesCharactersThatCorrespondToOps(t *testing.T) {
+	test := func(mode Mode, text, expected string) {
+		buf := bytes.NewBuffer(nil)
+		u := NewUnlexer(buf, WithInitialUnlexerMode(mode))
+		err := u.WriteComment(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = u.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Errorf("expected %#v but got %#v", expected, buf.String())
+		}
+		ops, err := readAllWithMode(buf.String(), mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ops) != 0 {
+			t.Errorf("expected no ops but got %#v", ops)
+		}
+	}
+	test(A, "Bu $x", "// Ｂｕ $x\n")
+	test(S, "Bu $x", "／／ Bｕ ＄x\n")
+	test(A, "a\tb\x00", "// ａ ｂ \n")
+	test(A, "日本語", "// 日本語\n")
+}
+
+func TestWriteCommentStartsOnANewLine(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	u := NewUnlexer(buf)
+	ops := []vm.Op{vm.Inew, vm.Snew, vm.Iinc}
+	err := u.Write(ops[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.WriteComment("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.WriteOps(ops[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.WriteComment("y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "B\n// x\n?h\n／／ ｙ\n"
+	if buf.String() != expected {
+		t.Errorf("expected %#v but got %#v", expected, buf.String())
+	}
+	got, err := readAll(buf.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ops, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func readAllWithMode(s string, mode Mode) ([]vm.Op, error) {
+	l := NewLexer(bytes.NewReader([]byte(s)), WithInitialLexerMode(mode))
+	ops := make([]vm.Op, len(s))
+	n, err := l.ReadOps(ops)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return ops[:n], nil
+}
@@ -0,0 +1,183 @@
+package lexer
+
+import (
+	"fmt"
+
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// LayoutOption configures a LayoutWriter.
+type LayoutOption interface {
+	apply(*LayoutWriter)
+}
+
+type layoutOption func(*LayoutWriter)
+
+func (opt layoutOption) apply(l *LayoutWriter) {
+	opt(l)
+}
+
+// WithWrapColumn makes a LayoutWriter break lines so that each line has at most col characters.
+// If col is less than or equal to zero, lines are not wrapped.
+func WithWrapColumn(col int) LayoutOption {
+	return layoutOption(func(l *LayoutWriter) {
+		l.wrap = col
+	})
+}
+
+// WithTopLevelBreaks makes a LayoutWriter start a new line after each Oadd or Aadd that adds a member to the outermost object or array,
+// so that each member of it starts on its own line.
+func WithTopLevelBreaks() LayoutOption {
+	return layoutOption(func(l *LayoutWriter) {
+		l.breaks = true
+	})
+}
+
+// LayoutWriter behaves as an OpWriter and writes Ops to an Unlexer, breaking lines of the output.
+//
+// Since '\n' does not correspond to any Op in either mode, the output is lexed into the same Ops,
+// and the lexer reports the actual lines and columns of the characters.
+// This means that '\n' can't be used as a delimiter of records at the same time.
+//
+// The outermost container is determined by tracking the stack of the VM. A container is regarded as an outer one until it is added to another container,
+// or until it is duplicated by Gdup, in which case it is assumed to be shared among other values.
+type LayoutWriter struct {
+	u      *Unlexer
+	wrap   int
+	breaks bool
+	column int
+	// pending reports whether a line should be broken before the next character.
+	// Lines are broken lazily so that the output does not end with an empty line.
+	pending bool
+	shape   []bool // whether each value on the stack is a container that is not added to anything yet
+	open    int    // the number of trues in shape
+	one     [1]vm.Op
+}
+
+// NewLayoutWriter returns a new LayoutWriter that writes to u.
+// u should not have written anything on the current line yet.
+func NewLayoutWriter(u *Unlexer, opts ...LayoutOption) *LayoutWriter {
+	l := &LayoutWriter{u: u}
+	for _, opt := range opts {
+		opt.apply(l)
+	}
+	return l
+}
+
+// Write writes op to the underlying Unlexer.
+func (l *LayoutWriter) Write(op vm.Op) error {
+	l.one[0] = op
+	return l.WriteOps(l.one[:])
+}
+
+// WriteOps writes ops to the underlying Unlexer, breaking lines between them if needed.
+func (l *LayoutWriter) WriteOps(ops []vm.Op) error {
+	start := 0
+	for i, op := range ops {
+		if l.wrap > 0 && l.column >= l.wrap {
+			l.pending = true
+		}
+		if l.pending {
+			err := l.u.WriteOps(ops[start:i])
+			if err != nil {
+				return err
+			}
+			err = l.u.writeByte(newline)
+			if err != nil {
+				return err
+			}
+			start = i
+			l.column = 0
+			l.pending = false
+		}
+		l.column++
+		if l.breaks && l.track(op) {
+			l.pending = true
+		}
+	}
+	return l.u.WriteOps(ops[start:])
+}
+
+// WriteDelimiter writes delim in the same way as Unlexer.WriteDelimiter.
+//
+// This fails if delim is '\n' and the LayoutWriter breaks lines, because the lexer would regard each line as a separate record.
+func (l *LayoutWriter) WriteDelimiter(delim byte) error {
+	if delim == newline && (l.wrap > 0 || l.breaks) {
+		return fmt.Errorf("can't use %q as a delimiter since it is used to break lines", delim)
+	}
+	l.column = 0
+	l.pending = false
+	l.shape = l.shape[:0]
+	l.open = 0
+	return l.u.WriteDelimiter(delim)
+}
+
+// WriteComment writes a comment in the same way as Unlexer.WriteComment.
+// The next Op starts on a new line.
+func (l *LayoutWriter) WriteComment(text string) error {
+	l.column = 0
+	l.pending = false
+	return l.u.WriteComment(text)
+}
+
+// Flush writes any buffered data of the underlying Unlexer.
+func (l *LayoutWriter) Flush() error {
+	return l.u.Flush()
+}
+
+// Mode returns the current mode of the underlying Unlexer.
+func (l *LayoutWriter) Mode() Mode {
+	return l.u.Mode()
+}
+
+// track updates the shape of the stack after op is executed, and reports whether op adds a member to the outermost container.
+func (l *LayoutWriter) track(op vm.Op) bool {
+	switch op {
+	case vm.Onew, vm.Anew:
+		l.push(true)
+	case vm.Oadd, vm.Aadd:
+		l.pop()
+		if op == vm.Oadd {
+			l.pop()
+		}
+		return len(l.shape) > 0 && l.shape[len(l.shape)-1] && l.open == 1
+	case vm.Gdup:
+		l.pop()
+		l.push(false)
+		l.push(false)
+	case vm.Gpop:
+		l.pop()
+	case vm.Gswp:
+		if n := len(l.shape); n >= 2 {
+			l.shape[n-1], l.shape[n-2] = l.shape[n-2], l.shape[n-1]
+		}
+	case vm.Iadd, vm.Isht, vm.Sadd:
+		l.pop()
+		l.pop()
+		l.push(false)
+	case vm.Iinc, vm.Ishl, vm.Ineg, vm.Itof, vm.Itou, vm.Fneg, vm.Bneg:
+		l.pop()
+		l.push(false)
+	default:
+		l.push(false)
+	}
+	return false
+}
+
+func (l *LayoutWriter) push(container bool) {
+	l.shape = append(l.shape, container)
+	if container {
+		l.open++
+	}
+}
+
+// pop removes the top of the shape. It does nothing if the shape is empty, which means that the Ops will fail anyway.
+func (l *LayoutWriter) pop() {
+	if len(l.shape) == 0 {
+		return
+	}
+	if l.shape[len(l.shape)-1] {
+		l.open--
+	}
+	l.shape = l.shape[:len(l.shape)-1]
+}
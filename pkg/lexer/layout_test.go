@@ -0,0 +1,150 @@
+package lexer
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/genkami/watson/pkg/vm"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLayoutWriterWrapsLines(t *testing.T) {
+	ops := make([]vm.Op, 0, 25)
+	for i := 0; i < 25; i++ {
+		ops = append(ops, vm.Inew)
+	}
+	out, err := layout(ops, WithWrapColumn(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "BBBBBBBBBB\nBBBBBBBBBB\nBBBBB"
+	if out != expected {
+		t.Errorf("expected %#v but got %#v", expected, out)
+	}
+}
+
+func TestLayoutWriterBreaksLinesAtTopLevel(t *testing.T) {
+	// {"": [0], "": false}
+	ops := []vm.Op{
+		vm.Onew,
+		vm.Snew, vm.Anew, vm.Inew, vm.Aadd, vm.Oadd,
+		vm.Snew, vm.Bnew, vm.Oadd,
+	}
+	out, err := layout(ops, WithTopLevelBreaks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "~?vS?g\n$zM"
+	if out != expected {
+		t.Errorf("expected %#v but got %#v", expected, out)
+	}
+}
+
+func TestLayoutWriterDoesNotRegardSharedContainersAsTopLevel(t *testing.T) {
+	// [[], []] where [] is shared
+	ops := []vm.Op{
+		vm.Anew, vm.Gdup,
+		vm.Anew, vm.Gswp, vm.Aadd, vm.Gswp, vm.Aadd,
+	}
+	out, err := layout(ops, WithTopLevelBreaks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "@E@%s\n%s"
+	if out != expected {
+		t.Errorf("expected %#v but got %#v", expected, out)
+	}
+}
+
+func TestLayoutWriterKeepsPositionsOfTokens(t *testing.T) {
+	var ops []vm.Op
+	ops = append(ops, vm.Anew)
+	for i := 0; i < 30; i++ {
+		ops = append(ops, vm.Snew, vm.Inew, vm.Iinc, vm.Sadd, vm.Aadd)
+	}
+	out, err := layout(ops, WithWrapColumn(7), WithTopLevelBreaks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out, "\n")
+	l := NewLexer(strings.NewReader(out))
+	var got []vm.Op
+	mode := A
+	for {
+		tok, err := l.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if c := lines[tok.Line][tok.Column]; lookupTables[mode][c] != tok.Op {
+			t.Fatalf("expected %#v at %d:%d but got %q", tok.Op, tok.Line, tok.Column, c)
+		}
+		mode = NextMode(mode, tok.Op)
+		got = append(got, tok.Op)
+	}
+	if diff := cmp.Diff(ops, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	for _, line := range lines {
+		if len(line) > 7 {
+			t.Errorf("too long line: %#v", line)
+		}
+	}
+}
+
+func TestLayoutWriterWriteOpsIsTheSameAsWrite(t *testing.T) {
+	var ops []vm.Op
+	for i := 0; i < 10; i++ {
+		ops = append(ops, vm.Onew, vm.Snew, vm.Nnew, vm.Oadd, vm.Gpop)
+	}
+	buf := bytes.NewBuffer(nil)
+	u := NewUnlexer(buf)
+	l := NewLayoutWriter(u, WithWrapColumn(3), WithTopLevelBreaks())
+	for _, op := range ops {
+		err := l.Write(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := l.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := layout(ops, WithWrapColumn(3), WithTopLevelBreaks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Errorf("expected %#v but got %#v", expected, buf.String())
+	}
+}
+
+func TestLayoutWriterRejectsNewlineAsDelimiter(t *testing.T) {
+	l := NewLayoutWriter(NewUnlexer(bytes.NewBuffer(nil)), WithWrapColumn(80))
+	err := l.WriteDelimiter('\n')
+	if err == nil {
+		t.Errorf("expected error but got nil")
+	}
+	err = l.WriteDelimiter(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func layout(ops []vm.Op, opts ...LayoutOption) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	l := NewLayoutWriter(NewUnlexer(buf), opts...)
+	err := l.WriteOps(ops)
+	if err != nil {
+		return "", err
+	}
+	err = l.Flush()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -60,6 +60,8 @@
 package lexer
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 
@@ -85,6 +87,19 @@ type Token struct {
 
 var newline = char("\n")
 
+const (
+	DefaultBufferSize = 4096 // the default size of the buffer of Lexer
+	DefaultDelimiter  = '\n' // the default delimiter of records; see WithDelimiter
+)
+
+// ErrEndOfRecord is returned by Lexer when it reaches the end of a record.
+var ErrEndOfRecord = errors.New("end of record")
+
+// IsDelimiter reports whether b can be used as a delimiter, that is, b does not correspond to any Op in either mode.
+func IsDelimiter(b byte) bool {
+	return lookupTables[A][b] == noOp && lookupTables[S][b] == noOp
+}
+
 // LexerOption configures a Lexer.
 type LexerOption interface {
 	apply(*Lexer)
@@ -103,6 +118,31 @@ func WithInitialLexerMode(mode Mode) LexerOption {
 	})
 }
 
+// WithBufferSize sets the size of the buffer of a lexer.
+// If given size is less than or equal to zero, DefaultBufferSize will be used.
+func WithBufferSize(size int) LexerOption {
+	return lexerOption(func(l *Lexer) {
+		if size > 0 {
+			l.buf = make([]byte, size)
+		}
+	})
+}
+
+// WithDelimiter makes a lexer regard its input as a sequence of records that are separated by delim.
+// Each record starts with the initial mode of the lexer, so that it can be read independently of the others.
+// See ReadTokens for how the end of a record is reported.
+//
+// This panics if delim corresponds to an Op in either mode.
+func WithDelimiter(delim byte) LexerOption {
+	if !IsDelimiter(delim) {
+		panic(fmt.Errorf("can't use %q as a delimiter since it corresponds to an Op", delim))
+	}
+	return lexerOption(func(l *Lexer) {
+		l.delim = delim
+		l.hasDelim = true
+	})
+}
+
 // WithFileName sets a file name of a lexer.
 // File name is only used to generate error messages.
 func WithFileName(name string) LexerOption {
@@ -122,13 +162,23 @@ func WithFileName(name string) LexerOption {
 // After that, it hits 'b' again, but in this time the 'b' is interpreted differently from the previous lexing step. Since the current mode of the lexer is S, it regards 'b' as `Fnan` instead of `Ishl`.
 // Then it hits '?', which is now interpreted as `Snew`, yields `Snew`, and changes its current mode to A.
 // In the end, it hits 'q' and yields `Finf`, and it stops its lexing procedure.
+//
+// Lexer reads the underlying io.Reader in chunks, so it may read more bytes than it needs.
 type Lexer struct {
 	r        io.Reader
+	err      error // the error returned by r, which is reported after all buffered bytes are consumed
 	mode     Mode
-	buf      [1]byte
+	buf      []byte
+	pos      int // the position of the next byte in buf
+	end      int // the end of the valid bytes in buf
 	fileName string
 	line     int
 	column   int
+
+	initialMode Mode
+	delim       byte
+	hasDelim    bool
+	parallelism int // only used by ParallelLexer
 }
 
 // Creates a new Lexer that reads Watson Representation from r.
@@ -137,9 +187,22 @@ func NewLexer(r io.Reader, opts ...LexerOption) *Lexer {
 	for _, opt := range opts {
 		opt.apply(l)
 	}
+	if len(l.buf) == 0 {
+		l.buf = make([]byte, DefaultBufferSize)
+	}
+	l.initialMode = l.mode
 	return l
 }
 
+// Reset discards the unread bytes and the error of l, and makes it read from r.
+// Unlike a new Lexer, it keeps its current mode and its buffer, so that an input that arrives in chunks can be lexed by a single Lexer.
+// The positions of tokens start over from the beginning of r.
+func (l *Lexer) Reset(r io.Reader) {
+	l.r, l.err = r, nil
+	l.pos, l.end = 0, 0
+	l.line, l.column = 0, 0
+}
+
 // Returns its current mode.
 func (l *Lexer) Mode() Mode {
 	return l.mode
@@ -147,36 +210,132 @@ func (l *Lexer) Mode() Mode {
 
 // Returns the next Op.
 // This returns io.EOF if it hits on the end of the input.
+//
+// Next allocates a new Token on each call, so the returned Token stays valid after subsequent calls and can be kept by the caller (e.g. in a DecodeError).
+// Use ReadTokens or ReadOps to read many tokens without allocating.
 func (l *Lexer) Next() (*Token, error) {
-	for {
-		_, err := l.r.Read(l.buf[:])
-		if err != nil {
-			// Note that it returns io.EOF if the underlying Reader returns io.EOF.
-			return nil, err
+	var toks [1]Token
+	_, err := l.read(nil, toks[:])
+	if err != nil {
+		return nil, err
+	}
+	return &toks[0], nil
+}
+
+// ReadTokens reads at most len(toks) tokens into toks and returns the number of tokens read.
+// Like io.Reader, it returns io.EOF (or an error that the underlying io.Reader returned) only if no tokens are read.
+//
+// If the lexer has a delimiter (see WithDelimiter), tokens are never read across it.
+// When the lexer reaches the delimiter without reading any tokens, it consumes the delimiter, resets its mode, and returns ErrEndOfRecord.
+func (l *Lexer) ReadTokens(toks []Token) (int, error) {
+	return l.read(nil, toks)
+}
+
+// ReadOps is the same as ReadTokens except that it reads only `vm.Op`s.
+func (l *Lexer) ReadOps(ops []vm.Op) (int, error) {
+	return l.read(ops, nil)
+}
+
+// read reads ops into either ops or toks, whichever is non-nil, and records their positions only in the latter.
+func (l *Lexer) read(ops []vm.Op, toks []Token) (int, error) {
+	max := len(ops) + len(toks)
+	n := 0
+	for n < max {
+		if l.pos >= l.end {
+			// Returns what it has already read rather than waiting for more input.
+			if n > 0 || !l.fill() {
+				break
+			}
 		}
-		line := l.line
-		col := l.column
-		if l.buf[0] == newline {
-			l.line++
-			l.column = 0
-		} else {
-			l.column++
+		table := &lookupTables[l.mode]
+		buf := l.buf[l.pos:l.end]
+		i, skipped := 0, 0
+		for ; i < len(buf) && n < max; i++ {
+			b := buf[i]
+			op := table[b]
+			if op != noOp {
+				if toks != nil {
+					l.skip(buf[skipped:i])
+					skipped = i
+					toks[n] = Token{Op: op, FileName: l.fileName, Line: l.line, Column: l.column}
+				} else {
+					ops[n] = op
+				}
+				n++
+				if op == vm.Snew {
+					l.mode = NextMode(l.mode, op)
+					table = &lookupTables[l.mode]
+				}
+			} else if l.hasDelim && b == l.delim {
+				break
+			}
 		}
-		if op, ok := readOp(l.mode, l.buf[0]); ok {
-			l.mode = nextMode(l.mode, op)
-			return &Token{
-				Op:       op,
-				FileName: l.fileName,
-				Line:     line,
-				Column:   col,
-			}, nil
+		l.skip(buf[skipped:i])
+		l.pos += i
+		if i < len(buf) && n < max {
+			return l.endRecord(n)
 		}
 	}
+	if n == 0 {
+		return 0, l.err
+	}
+	return n, nil
+}
+
+// skip updates the position of the lexer after reading buf.
+func (l *Lexer) skip(buf []byte) {
+	if len(buf) < 8 {
+		// Tokens are usually close to each other, so they are not worth calling into the bytes package.
+		for _, b := range buf {
+			if b == newline {
+				l.line++
+				l.column = 0
+			} else {
+				l.column++
+			}
+		}
+		return
+	}
+	k := bytes.LastIndexByte(buf, newline)
+	if k < 0 {
+		l.column += len(buf)
+		return
+	}
+	l.line += bytes.Count(buf, []byte{newline})
+	l.column = len(buf) - k - 1
+}
+
+// endRecord is called when the lexer reaches a delimiter after reading n tokens.
+// If any tokens are read, it leaves the delimiter so that it can be reported by the next call.
+func (l *Lexer) endRecord(n int) (int, error) {
+	if n > 0 {
+		return n, nil
+	}
+	l.skip(l.buf[l.pos : l.pos+1])
+	l.pos++
+	l.mode = l.initialMode
+	return 0, ErrEndOfRecord
+}
+
+// fill reads the next chunk from the underlying io.Reader and reports whether any bytes are available.
+func (l *Lexer) fill() bool {
+	l.pos, l.end = 0, 0
+	for l.err == nil {
+		n, err := l.r.Read(l.buf)
+		l.end = n
+		l.err = err
+		if n > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 // OpWriter is an abstract interface that defines what the Unlexer does.
 type OpWriter interface {
 	Write(vm.Op) error
+	// WriteOps writes all ops in order. This has the same effect as calling Write for each op, but it is usually faster.
+	WriteOps([]vm.Op) error
 	Mode() Mode
 }
 
@@ -207,7 +366,15 @@ func (s *SliceWriter) Ops() []vm.Op {
 
 func (s *SliceWriter) Write(op vm.Op) error {
 	s.ops = append(s.ops, op)
-	s.mode = nextMode(s.mode, op)
+	s.mode = NextMode(s.mode, op)
+	return nil
+}
+
+func (s *SliceWriter) WriteOps(ops []vm.Op) error {
+	for _, op := range ops {
+		s.ops = append(s.ops, op)
+		s.mode = NextMode(s.mode, op)
+	}
 	return nil
 }
 
@@ -229,30 +396,119 @@ func WithInitialUnlexerMode(m Mode) UnlexerOption {
 	})
 }
 
+// WithUnlexerBufferSize sets the size of the buffer of an Unlexer.
+// If given size is less than or equal to zero, DefaultBufferSize will be used.
+func WithUnlexerBufferSize(size int) UnlexerOption {
+	return unlexerOption(func(u *Unlexer) {
+		if size > 0 {
+			u.buf = make([]byte, 0, size)
+		}
+	})
+}
+
 // Unlexer converts a sequence of `vm.Op`s into a sequence of characters.
+//
+// Unlexer buffers its output. Call Flush after writing all Ops to make sure that they are written to the underlying io.Writer.
 type Unlexer struct {
-	w    io.Writer
-	mode Mode
+	w           io.Writer
+	mode        Mode
+	initialMode Mode
+	buf         []byte
+	lineStart   bool // whether the last byte that has been flushed is a line break, or nothing has been flushed
 }
 
 // NewUnlexer returns a new Unlexer that writes to w.
 func NewUnlexer(w io.Writer, opts ...UnlexerOption) *Unlexer {
 	u := &Unlexer{
-		w:    w,
-		mode: A,
+		w:         w,
+		mode:      A,
+		lineStart: true,
 	}
 	for _, opt := range opts {
 		opt.apply(u)
 	}
+	if cap(u.buf) == 0 {
+		u.buf = make([]byte, 0, DefaultBufferSize)
+	}
+	u.initialMode = u.mode
 	return u
 }
 
 // Write writes an Op to the underlying io.Writer.
 func (u *Unlexer) Write(op vm.Op) error {
-	b := make([]byte, 1)
-	b[0] = showOp(u.mode, op)
-	u.mode = nextMode(u.mode, op)
-	_, err := u.w.Write(b)
+	if len(u.buf) == cap(u.buf) {
+		err := u.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	u.buf = append(u.buf, showOp(u.mode, op))
+	u.mode = NextMode(u.mode, op)
+	return nil
+}
+
+// WriteOps writes Ops to the underlying io.Writer.
+func (u *Unlexer) WriteOps(ops []vm.Op) error {
+	for len(ops) > 0 {
+		if len(u.buf) == cap(u.buf) {
+			err := u.Flush()
+			if err != nil {
+				return err
+			}
+		}
+		n := cap(u.buf) - len(u.buf)
+		if n > len(ops) {
+			n = len(ops)
+		}
+		table := showTables[u.mode]
+		for _, op := range ops[:n] {
+			u.buf = append(u.buf, table[op])
+			if op == vm.Snew {
+				u.mode = NextMode(u.mode, op)
+				table = showTables[u.mode]
+			}
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// WriteDelimiter writes delim as the end of a record and resets the mode of the Unlexer to its initial mode.
+// See WithDelimiter for more details.
+//
+// This panics if delim corresponds to an Op in either mode.
+func (u *Unlexer) WriteDelimiter(delim byte) error {
+	if !IsDelimiter(delim) {
+		panic(fmt.Errorf("can't use %q as a delimiter since it corresponds to an Op", delim))
+	}
+	err := u.writeByte(delim)
+	if err != nil {
+		return err
+	}
+	u.mode = u.initialMode
+	return nil
+}
+
+// writeByte writes b, which must not correspond to any Op, without changing the mode.
+func (u *Unlexer) writeByte(b byte) error {
+	if len(u.buf) == cap(u.buf) {
+		err := u.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	u.buf = append(u.buf, b)
+	return nil
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (u *Unlexer) Flush() error {
+	if len(u.buf) == 0 {
+		return nil
+	}
+	u.lineStart = u.buf[len(u.buf)-1] == newline
+	_, err := u.w.Write(u.buf)
+	u.buf = u.buf[:0]
 	return err
 }
 
@@ -261,7 +517,8 @@ func (u *Unlexer) Mode() Mode {
 	return u.mode
 }
 
-func nextMode(mode Mode, op vm.Op) Mode {
+// NextMode returns the mode of a lexer or an unlexer after it reads or writes op in the given mode.
+func NextMode(mode Mode, op vm.Op) Mode {
 	var next Mode
 	switch mode {
 	case A:
@@ -308,8 +565,6 @@ var opTableA = map[byte]vm.Op{
 	char("%"): vm.Gswp,
 }
 
-var reversedTableA map[vm.Op]byte
-
 var opTableS = map[byte]vm.Op{
 	char("S"): vm.Inew,
 	char("h"): vm.Iinc,
@@ -336,47 +591,36 @@ var opTableS = map[byte]vm.Op{
 	char(":"): vm.Gswp,
 }
 
-var reversedTableS map[vm.Op]byte
+// noOp is a sentinel in lookupTables that indicates that a byte does not correspond to any Op.
+const noOp vm.Op = -1
 
-func init() {
-	reversedTableA = make(map[vm.Op]byte)
-	for k, v := range opTableA {
-		reversedTableA[v] = k
-	}
-	reversedTableS = make(map[vm.Op]byte)
-	for k, v := range opTableS {
-		reversedTableS[v] = k
-	}
-}
+// lookupTables is an array version of opTableA and opTableS that is indexed by Mode.
+var lookupTables [2][256]vm.Op
 
-func readOp(m Mode, b byte) (op vm.Op, ok bool) {
-	var table map[byte]vm.Op
-	switch m {
-	case A:
-		table = opTableA
-	case S:
-		table = opTableS
-	default:
-		panic(fmt.Errorf("unknown mode: %d", m))
+// showTables is the inverse of lookupTables, which is indexed by Mode and then by vm.Op.
+var showTables [2][]byte
+
+func init() {
+	for m, table := range []map[byte]vm.Op{A: opTableA, S: opTableS} {
+		for b := range lookupTables[m] {
+			lookupTables[m][b] = noOp
+		}
+		showTables[m] = make([]byte, len(vm.AllOps()))
+		for b, op := range table {
+			lookupTables[m][b] = op
+			showTables[m][op] = b
+		}
 	}
-	op, ok = table[b]
-	return
 }
 
 func showOp(m Mode, op vm.Op) byte {
-	var table map[vm.Op]byte
-	switch m {
-	case A:
-		table = reversedTableA
-	case S:
-		table = reversedTableS
-	default:
+	if m != A && m != S {
 		panic(fmt.Errorf("unknown mode: %d", m))
 	}
-	if b, ok := table[op]; ok {
-		return b
+	if op < 0 || int(op) >= len(showTables[m]) {
+		panic(fmt.Errorf("unknown Op: %#v\n", op))
 	}
-	panic(fmt.Errorf("unknown Op: %#v\n", op))
+	return showTables[m][op]
 }
 
 func char(s string) byte {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"testing"
+	"testing/iotest"
 
 	"github.com/genkami/watson/pkg/vm"
 
@@ -214,6 +215,10 @@ func TestWriteWritesAnOp(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	err = u.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
 	want := []byte("B")
 	got := buf.Bytes()
 	if diff := cmp.Diff(want, got); diff != "" {
@@ -233,6 +238,10 @@ func TestWriteChangesItsModeWhenHittingSnew(t *testing.T) {
 			t.Fatal(err)
 		}
 	}
+	err := u.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	got := buf.Bytes()
 	if diff := cmp.Diff(want, got); diff != "" {
@@ -240,6 +249,300 @@ func TestWriteChangesItsModeWhenHittingSnew(t *testing.T) {
 	}
 }
 
+func TestWriteOpsWritesAllOps(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	u := NewUnlexer(buf, WithUnlexerBufferSize(2))
+	ops := []vm.Op{vm.Ishl, vm.Snew, vm.Fnan, vm.Snew, vm.Finf}
+	want := []byte("b?b$q")
+
+	err := u.WriteOps(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.Bytes()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("expected %#v but got %#v", want, got)
+	}
+	if u.Mode() != A {
+		t.Errorf("expected %#v but got %#v", A, u.Mode())
+	}
+}
+
+func TestUnlexerBuffersItsOutput(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	u := NewUnlexer(buf, WithUnlexerBufferSize(4))
+	err := u.WriteOps([]vm.Op{vm.Inew, vm.Iinc, vm.Iinc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing to be written but got %#v", buf.Bytes())
+	}
+	err = u.WriteOps([]vm.Op{vm.Iinc, vm.Iinc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]byte("Buuu"), buf.Bytes()); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSliceWriterWriteOpsAppendsAllOps(t *testing.T) {
+	w := NewSliceWriter()
+	ops := []vm.Op{vm.Inew, vm.Snew, vm.Iinc}
+	err := w.WriteOps(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ops, w.Ops()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if w.Mode() != S {
+		t.Errorf("expected %#v but got %#v", S, w.Mode())
+	}
+}
+
+func TestReadTokensReturnsFileNameAndPosition(t *testing.T) {
+	name := "hoge.watson"
+	// The tiny buffer makes the lexer cross the boundaries of chunks while lexing.
+	l := NewLexer(bytes.NewReader([]byte("Bu?\nZa$\n\nb")), WithFileName(name), WithBufferSize(3))
+	want := []Token{
+		{Op: vm.Inew, FileName: name, Line: 0, Column: 0},
+		{Op: vm.Iinc, FileName: name, Line: 0, Column: 1},
+		{Op: vm.Snew, FileName: name, Line: 0, Column: 2},
+		{Op: vm.Ishl, FileName: name, Line: 1, Column: 1},
+		{Op: vm.Snew, FileName: name, Line: 1, Column: 2},
+		{Op: vm.Ishl, FileName: name, Line: 3, Column: 0},
+	}
+	got := make([]Token, 0)
+	toks := make([]Token, 4)
+	for {
+		n, err := l.ReadTokens(toks)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, toks[:n]...)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadTokensReturnsPositionAfterReadOps(t *testing.T) {
+	l := NewLexer(bytes.NewReader([]byte("Bu  \n   \n  ub          b\n Ba")))
+	ops := make([]vm.Op, 3)
+	_, err := l.ReadOps(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Token{
+		{Op: vm.Ishl, Line: 2, Column: 3},
+		{Op: vm.Ishl, Line: 2, Column: 14},
+		{Op: vm.Inew, Line: 3, Column: 1},
+		{Op: vm.Iadd, Line: 3, Column: 2},
+	}
+	got := make([]Token, 4)
+	n, err := l.ReadTokens(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got[:n]); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestResetKeepsTheMode(t *testing.T) {
+	l := NewLexer(bytes.NewReader([]byte("B?")))
+	got, _ := readTokens(t, l)
+	l.Reset(bytes.NewReader([]byte("\nS")))
+	more, _ := readTokens(t, l)
+	got = append(got, more...)
+	want := []Token{
+		{Op: vm.Inew, Line: 0, Column: 0},
+		{Op: vm.Snew, Line: 0, Column: 1},
+		{Op: vm.Inew, Line: 1, Column: 0},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if l.Mode() != S {
+		t.Errorf("expected %#v but got %#v", S, l.Mode())
+	}
+}
+
+func TestReadOpsReadsAllOps(t *testing.T) {
+	l := NewLexer(iotest.OneByteReader(bytes.NewReader([]byte("Bub?Sh$ba"))))
+	want := []vm.Op{vm.Inew, vm.Iinc, vm.Ishl, vm.Snew, vm.Inew, vm.Iinc, vm.Snew, vm.Ishl, vm.Iadd}
+	got := make([]vm.Op, 0)
+	ops := make([]vm.Op, 16)
+	for {
+		n, err := l.ReadOps(ops)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ops[:n]...)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if l.Mode() != A {
+		t.Errorf("expected %#v but got %#v", A, l.Mode())
+	}
+}
+
+func TestReadOpsReturnsAnErrorAfterAllOpsAreRead(t *testing.T) {
+	l := NewLexer(iotest.TimeoutReader(bytes.NewReader([]byte("Bu"))))
+	ops := make([]vm.Op, 16)
+	n, err := l.ReadOps(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 ops but got %d", n)
+	}
+	_, err = l.ReadOps(ops)
+	if err != iotest.ErrTimeout {
+		t.Fatalf("expected ErrTimeout but got %v", err)
+	}
+}
+
+func TestReadTokensStopsAtDelimiter(t *testing.T) {
+	name := "hoge.watson"
+	l := NewLexer(bytes.NewReader([]byte("B?S\n\nZu\n")), WithFileName(name), WithDelimiter('\n'), WithBufferSize(2))
+	toks := make([]Token, 16)
+	type result struct {
+		Toks []Token
+		Err  error
+	}
+	want := []result{
+		{Toks: []Token{
+			{Op: vm.Inew, FileName: name, Line: 0, Column: 0},
+			{Op: vm.Snew, FileName: name, Line: 0, Column: 1},
+		}},
+		{Toks: []Token{
+			{Op: vm.Inew, FileName: name, Line: 0, Column: 2},
+		}},
+		{Err: ErrEndOfRecord},
+		{Err: ErrEndOfRecord},
+		// The lexer is back to mode A after the delimiter.
+		{Toks: []Token{
+			{Op: vm.Iinc, FileName: name, Line: 2, Column: 1},
+		}},
+		{Err: ErrEndOfRecord},
+		{Err: io.EOF},
+	}
+	got := make([]result, 0)
+	for {
+		n, err := l.ReadTokens(toks)
+		r := result{Err: err}
+		if n > 0 {
+			r.Toks = append(r.Toks, toks[:n]...)
+		}
+		got = append(got, r)
+		if err == io.EOF || len(got) > len(want) {
+			break
+		}
+	}
+	opt := cmp.Comparer(func(a, b error) bool { return a == b })
+	if diff := cmp.Diff(want, got, opt); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadOpsStopsAtDelimiter(t *testing.T) {
+	l := NewLexer(bytes.NewReader([]byte("?;u")), WithDelimiter(';'))
+	ops := make([]vm.Op, 16)
+	n, err := l.ReadOps(ops)
+	if err != nil || n != 1 || ops[0] != vm.Snew {
+		t.Fatalf("unexpected result: %v, %v", ops[:n], err)
+	}
+	_, err = l.ReadOps(ops)
+	if err != ErrEndOfRecord {
+		t.Fatalf("expected ErrEndOfRecord but got %v", err)
+	}
+	n, err = l.ReadOps(ops)
+	if err != nil || n != 1 || ops[0] != vm.Iinc {
+		t.Fatalf("unexpected result: %v, %v", ops[:n], err)
+	}
+}
+
+func TestIsDelimiter(t *testing.T) {
+	if !IsDelimiter('\n') {
+		t.Errorf("expected '\\n' to be a delimiter")
+	}
+	if IsDelimiter('B') || IsDelimiter('S') {
+		t.Errorf("expected ops not to be delimiters")
+	}
+}
+
+func TestWriteDelimiterResetsTheMode(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	u := NewUnlexer(buf, WithInitialUnlexerMode(S))
+	err := u.WriteOps([]vm.Op{vm.Snew, vm.Inew})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.WriteDelimiter('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.Write(vm.Inew)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("$B\nS", buf.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func BenchmarkReadOps(b *testing.B) {
+	src := bytes.Repeat([]byte("Bubba?Shak$ZZ\n"), 1<<16)
+	ops := make([]vm.Op, 1024)
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := NewLexer(bytes.NewReader(src))
+		for {
+			_, err := l.ReadOps(ops)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkNext(b *testing.B) {
+	src := bytes.Repeat([]byte("Bubba?Shak$ZZ\n"), 1<<16)
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := NewLexer(bytes.NewReader(src))
+		for {
+			_, err := l.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
 func readOne(s string) (vm.Op, error) {
 	buf := bytes.NewReader([]byte(s))
 	l := NewLexer(buf)
@@ -0,0 +1,339 @@
+package lexer
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// DefaultChunkSize is the default size of the chunks that ParallelLexer lexes at once.
+const DefaultChunkSize = 1 << 16
+
+// WithParallelism sets the number of goroutines that a ParallelLexer uses to lex chunks.
+// If n is less than or equal to zero, runtime.GOMAXPROCS(0) is used. Lexer ignores this option.
+func WithParallelism(n int) LexerOption {
+	return lexerOption(func(l *Lexer) {
+		l.parallelism = n
+	})
+}
+
+// ParallelLexer converts a Watson Representation into the same sequence of `vm.Op`s as Lexer does, but it lexes the input on multiple goroutines.
+//
+// Since each Snew flips the mode, the mode in which a part of the input should be lexed is not known until everything before it is lexed.
+// ParallelLexer splits its input into chunks and lexes each of them from both modes at once. Then it chooses one of the results
+// according to the mode in which the previous chunk ends, which is determined by the parity of Snews in it.
+//
+// The size of the chunks is determined by WithBufferSize (DefaultChunkSize by default), and the number of goroutines is determined by WithParallelism.
+// ParallelLexer does not support WithDelimiter.
+//
+// Since each chunk is lexed twice, ParallelLexer is about half as fast as Lexer on a single CPU; it pays off only if the workers run on different CPUs.
+//
+// ParallelLexer reads the input ahead of the tokens that are returned. Call Close if it is no longer used before it reaches the end of the input.
+type ParallelLexer struct {
+	start    Mode // the mode in which the current chunk starts
+	fileName string
+	line     int // the line where the current chunk starts
+	column   int // the column where the current chunk starts
+
+	pending <-chan *chunk // chunks in the order in which they appear in the input
+	current *chunk
+	pos     int // the index of the next op in current.ops[start]
+	lines   int // the number of line breaks in current.data before the op at pos
+	err     error
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// chunk is a part of the input and the results of lexing it from both modes.
+type chunk struct {
+	data  []byte
+	err   error // the error that the underlying io.Reader returned after data
+	ready chan struct{}
+
+	buf      []vm.Op // taken from opsPool
+	offBuf   []int32 // taken from offsetPool
+	ops      [2][]vm.Op
+	offsets  [2][]int32 // the offsets in data of the bytes that ops are read from
+	newlines []int32    // the offsets of line breaks in data
+	endMode  [2]Mode
+	lines    int // the number of line breaks in the chunk
+	column   int // the number of bytes after the last line break, or the number of all bytes if there are no line breaks
+}
+
+// Buffers of chunks are reused since they are as large as several times the chunk size.
+var (
+	dataPool   sync.Pool // *[]byte
+	opsPool    sync.Pool // *[]vm.Op
+	offsetPool sync.Pool // *[]int32
+)
+
+func getData(size int) []byte {
+	if p, ok := dataPool.Get().(*[]byte); ok && cap(*p) >= size {
+		return (*p)[:size]
+	}
+	return make([]byte, size)
+}
+
+func getOps(size int) []vm.Op {
+	if p, ok := opsPool.Get().(*[]vm.Op); ok && cap(*p) >= size {
+		return (*p)[:size]
+	}
+	return make([]vm.Op, size)
+}
+
+func getOffsets(size int) []int32 {
+	if p, ok := offsetPool.Get().(*[]int32); ok && cap(*p) >= size {
+		return (*p)[:size]
+	}
+	return make([]int32, size)
+}
+
+// release returns the buffers of the chunk to the pools. The chunk must not be used after that.
+func (c *chunk) release() {
+	data, buf, offBuf := c.data[:0], c.buf[:0], c.offBuf[:0]
+	dataPool.Put(&data)
+	opsPool.Put(&buf)
+	offsetPool.Put(&offBuf)
+	c.data, c.buf, c.offBuf, c.ops, c.offsets, c.newlines = nil, nil, nil, [2][]vm.Op{}, [2][]int32{}, nil
+}
+
+// NewParallelLexer creates a new ParallelLexer that reads Watson Representation from r.
+// It accepts the same options as NewLexer in addition to WithParallelism.
+//
+// This panics if WithDelimiter is given.
+func NewParallelLexer(r io.Reader, opts ...LexerOption) *ParallelLexer {
+	config := &Lexer{mode: A}
+	for _, opt := range opts {
+		opt.apply(config)
+	}
+	if config.hasDelim {
+		panic("ParallelLexer does not support delimiters")
+	}
+	chunkSize := len(config.buf)
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	workers := config.parallelism
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	// Chunks that are read ahead are limited by the capacity of pending.
+	pending := make(chan *chunk, workers*2)
+	jobs := make(chan *chunk, workers*2)
+	l := &ParallelLexer{
+		start:    config.mode,
+		fileName: config.fileName,
+		pending:  pending,
+		done:     make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for c := range jobs {
+				c.lex()
+				close(c.ready)
+			}
+		}()
+	}
+	go l.split(r, chunkSize, pending, jobs)
+	return l
+}
+
+// split reads r and sends each chunk both to pending and to jobs.
+func (l *ParallelLexer) split(r io.Reader, chunkSize int, pending, jobs chan<- *chunk) {
+	defer close(pending)
+	defer close(jobs)
+	for {
+		data := getData(chunkSize)
+		n, err := io.ReadFull(r, data)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		c := &chunk{data: data[:n], err: err, ready: make(chan struct{})}
+		select {
+		case pending <- c:
+		case <-l.done:
+			return
+		}
+		select {
+		case jobs <- c:
+		case <-l.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// lex lexes the chunk from both modes in a single pass.
+// It also records where ops and line breaks are, so that the positions of tokens need not be found by lexing the chunk again.
+func (c *chunk) lex() {
+	n := len(c.data)
+	lines := bytes.Count(c.data, []byte{newline})
+	// Each byte yields at most one op in each mode.
+	buf, offBuf := getOps(2*n), getOffsets(2*n+lines)
+	// opsA and opsS are the results of lexing the chunk from A and from S respectively.
+	opsA, opsS := buf[:n], buf[n:]
+	offA, offS := offBuf[:n], offBuf[n:2*n]
+	nA, nS := 0, 0
+	modeA, modeS := A, S
+	tableA, tableS := &lookupTables[modeA], &lookupTables[modeS]
+	for i, b := range c.data {
+		// Each op is stored whether or not it is valid, and is overwritten by the next one unless it is valid.
+		opA, opS := tableA[b], tableS[b]
+		opsA[nA], offA[nA] = opA, int32(i)
+		opsS[nS], offS[nS] = opS, int32(i)
+		if opA != noOp {
+			nA++
+			if opA == vm.Snew {
+				modeA = NextMode(modeA, opA)
+				tableA = &lookupTables[modeA]
+			}
+		}
+		if opS != noOp {
+			nS++
+			if opS == vm.Snew {
+				modeS = NextMode(modeS, opS)
+				tableS = &lookupTables[modeS]
+			}
+		}
+	}
+	newlines := offBuf[2*n : 2*n : 2*n+lines]
+	for i := 0; len(newlines) < lines; i++ {
+		i += bytes.IndexByte(c.data[i:], newline)
+		newlines = append(newlines, int32(i))
+	}
+	c.buf, c.ops = buf, [2][]vm.Op{opsA[:nA], opsS[:nS]}
+	c.offBuf, c.offsets, c.newlines = offBuf, [2][]int32{offA[:nA], offS[:nS]}, newlines
+	c.endMode = [2]Mode{modeA, modeS}
+	c.lines = lines
+	c.column = n
+	if lines > 0 {
+		c.column = n - 1 - int(newlines[lines-1])
+	}
+}
+
+// Mode returns the mode in which the next byte is lexed.
+func (l *ParallelLexer) Mode() Mode {
+	c := l.current
+	if c == nil {
+		return l.start
+	}
+	ops := c.ops[l.start]
+	if l.pos == len(ops) {
+		return c.endMode[l.start]
+	}
+	mode := l.start
+	for _, op := range ops[:l.pos] {
+		mode = NextMode(mode, op)
+	}
+	return mode
+}
+
+// Next returns the next Op in the same way as Lexer.Next; it also allocates a new Token on each call.
+func (l *ParallelLexer) Next() (*Token, error) {
+	var toks [1]Token
+	_, err := l.ReadTokens(toks[:])
+	if err != nil {
+		return nil, err
+	}
+	return &toks[0], nil
+}
+
+// ReadTokens reads at most len(toks) tokens into toks in the same way as Lexer.ReadTokens.
+func (l *ParallelLexer) ReadTokens(toks []Token) (int, error) {
+	n := 0
+	for n < len(toks) {
+		if !l.next() {
+			break
+		}
+		c := l.current
+		ops, offsets := c.ops[l.start], c.offsets[l.start]
+		for ; l.pos < len(ops) && n < len(toks); l.pos++ {
+			offset := offsets[l.pos]
+			for l.lines < len(c.newlines) && c.newlines[l.lines] < offset {
+				l.lines++
+			}
+			column := l.column + int(offset)
+			if l.lines > 0 {
+				column = int(offset - c.newlines[l.lines-1] - 1)
+			}
+			toks[n] = Token{Op: ops[l.pos], FileName: l.fileName, Line: l.line + l.lines, Column: column}
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, l.err
+	}
+	return n, nil
+}
+
+// ReadOps is the same as ReadTokens except that it reads only `vm.Op`s.
+func (l *ParallelLexer) ReadOps(ops []vm.Op) (int, error) {
+	n := 0
+	for n < len(ops) {
+		if !l.next() {
+			break
+		}
+		m := copy(ops[n:], l.current.ops[l.start][l.pos:])
+		n += m
+		l.pos += m
+	}
+	if n == 0 {
+		return 0, l.err
+	}
+	return n, nil
+}
+
+// next makes sure that the current chunk has ops that are not read yet, and reports whether there are any.
+func (l *ParallelLexer) next() bool {
+	for l.current == nil || l.pos >= len(l.current.ops[l.start]) {
+		if l.err != nil {
+			return false
+		}
+		if c := l.current; c != nil {
+			// Moves to the end of the current chunk.
+			l.start = c.endMode[l.start]
+			if c.lines > 0 {
+				l.line += c.lines
+				l.column = c.column
+			} else {
+				l.column += c.column
+			}
+			l.current = nil
+			c.release()
+			if c.err != nil {
+				l.err = c.err
+				return false
+			}
+		}
+		c, ok := <-l.pending
+		if !ok {
+			// This happens only after Close is called.
+			l.err = io.EOF
+			return false
+		}
+		select {
+		case <-c.ready:
+		case <-l.done:
+			// The chunk may never be lexed.
+			l.err = io.EOF
+			return false
+		}
+		l.current, l.pos, l.lines = c, 0, 0
+	}
+	return true
+}
+
+// Close stops reading the input. Reading tokens after Close returns io.EOF or the error that has already occurred.
+func (l *ParallelLexer) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
@@ -0,0 +1,216 @@
+package lexer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/genkami/watson/pkg/vm"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParallelLexerReadsTheSameTokensAsLexer(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	alphabet := []byte("Bubaq?$Shk+gvM~@s \n#e/E")
+	for i := 0; i < 100; i++ {
+		src := make([]byte, r.Intn(300))
+		for j := range src {
+			src[j] = alphabet[r.Intn(len(alphabet))]
+		}
+		mode := Mode(r.Intn(2))
+		opts := []LexerOption{WithInitialLexerMode(mode), WithFileName("test.watson")}
+
+		want, wantMode := readTokens(t, NewLexer(bytes.NewReader(src), opts...))
+		pl := NewParallelLexer(
+			bytes.NewReader(src),
+			append(opts, WithBufferSize(1+r.Intn(20)), WithParallelism(1+r.Intn(4)))...,
+		)
+		got, gotMode := readTokens(t, pl)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+		if wantMode != gotMode {
+			t.Errorf("expected %d but got %d", wantMode, gotMode)
+		}
+	}
+}
+
+func TestParallelLexerReadOpsReadsAllOps(t *testing.T) {
+	src := bytes.Repeat([]byte("Bubba?Shak$ZZ\n"), 100)
+	want, err := readAll(string(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewParallelLexer(bytes.NewReader(src), WithBufferSize(64), WithParallelism(3))
+	buf := make([]vm.Op, 100)
+	var got []vm.Op
+	for {
+		n, err := l.ReadOps(buf)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParallelLexerReadsTokensAfterOps(t *testing.T) {
+	src := []byte("Bu\nb?Sh\n\nkaa$Bu")
+	want, _ := readTokens(t, NewLexer(bytes.NewReader(src)))
+	l := NewParallelLexer(bytes.NewReader(src), WithBufferSize(5), WithParallelism(2))
+	var got []Token
+	ops := make([]vm.Op, 2)
+	toks := make([]Token, 1)
+	for i := 0; ; i++ {
+		var err error
+		if i%2 == 0 {
+			_, err = l.ReadOps(ops)
+		} else {
+			var n int
+			n, err = l.ReadTokens(toks)
+			got = append(got, toks[:n]...)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// ReadOps reads 2 ops and ReadTokens reads 1 token alternately.
+	var wantTokens []Token
+	for i := 2; i < len(want); i += 3 {
+		wantTokens = append(wantTokens, want[i])
+	}
+	if diff := cmp.Diff(wantTokens, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParallelLexerReturnsErrorAfterAllOpsAreRead(t *testing.T) {
+	errTest := errors.New("test")
+	r := io.MultiReader(bytes.NewReader([]byte("BuuB")), iotest.ErrReader(errTest))
+	l := NewParallelLexer(r, WithBufferSize(3))
+	ops := make([]vm.Op, 10)
+	n, err := l.ReadOps(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]vm.Op{vm.Inew, vm.Iinc, vm.Iinc, vm.Inew}, ops[:n]); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	_, err = l.ReadOps(ops)
+	if err != errTest {
+		t.Errorf("expected %v but got %v", errTest, err)
+	}
+}
+
+func TestParallelLexerCanBeClosedBeforeTheEnd(t *testing.T) {
+	src := bytes.Repeat([]byte("Bu"), 10000)
+	l := NewParallelLexer(bytes.NewReader(src), WithBufferSize(16), WithParallelism(2))
+	_, err := l.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err = l.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParallelLexerDoesNotSupportDelimiters(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic but did not")
+		}
+	}()
+	NewParallelLexer(bytes.NewReader(nil), WithDelimiter(DefaultDelimiter))
+}
+
+func BenchmarkParallelLexerReadOps(b *testing.B) {
+	src := bytes.Repeat([]byte("Bubba?Shak$ZZ\n"), 1<<16)
+	ops := make([]vm.Op, 1024)
+	for _, parallelism := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				l := NewParallelLexer(bytes.NewReader(src), WithParallelism(parallelism))
+				for {
+					_, err := l.ReadOps(ops)
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkParallelLexerReadTokens compares ParallelLexer with Lexer, which is run as the sub-benchmark "lexer".
+// Run it with -cpu to see how it scales; e.g. -cpu=1,4.
+func BenchmarkParallelLexerReadTokens(b *testing.B) {
+	src := bytes.Repeat([]byte("Bubba?Shak$ZZ\n"), 1<<16)
+	toks := make([]Token, 1024)
+	bench := func(b *testing.B, newReader func() tokenReader) {
+		b.SetBytes(int64(len(src)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l := newReader()
+			for {
+				_, err := l.ReadTokens(toks)
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+	b.Run("lexer", func(b *testing.B) {
+		bench(b, func() tokenReader { return NewLexer(bytes.NewReader(src)) })
+	})
+	for _, parallelism := range []int{1, 2, 4, 8} {
+		parallelism := parallelism
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			bench(b, func() tokenReader { return NewParallelLexer(bytes.NewReader(src), WithParallelism(parallelism)) })
+		})
+	}
+}
+
+type tokenReader interface {
+	ReadTokens([]Token) (int, error)
+	Mode() Mode
+}
+
+func readTokens(t *testing.T, r tokenReader) ([]Token, Mode) {
+	var toks []Token
+	buf := make([]Token, 7)
+	for {
+		n, err := r.ReadTokens(buf)
+		if err == io.EOF {
+			return toks, r.Mode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		toks = append(toks, buf[:n]...)
+	}
+}
@@ -0,0 +1,160 @@
+package lexer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// TranscodeOption configures Transcode.
+type TranscodeOption interface {
+	apply(*transcoder)
+}
+
+type transcodeOption func(*transcoder)
+
+func (opt transcodeOption) apply(t *transcoder) {
+	opt(t)
+}
+
+// WithRelexing makes Transcode rewrite each character of its input instead of writing a prefix.
+//
+// Each character that corresponds to an Op is replaced with the one that corresponds to the same Op in the new mode.
+// Other characters are kept as they are, except that ASCII characters that correspond to Ops in the new mode are replaced with their fullwidth forms
+// (e.g. '$' is written as '＄' where the new mode is S), in the same way as `Unlexer.WriteComment`.
+// The output has the same Ops as the input.
+func WithRelexing() TranscodeOption {
+	return transcodeOption(func(t *transcoder) {
+		t.relex = true
+	})
+}
+
+// WithTranscodeDelimiter makes Transcode regard its input as a sequence of records that are separated by delim, as Lexer does with WithDelimiter.
+// Since each record starts with the initial mode, Transcode writes the prefix at the start of each record, or resets the modes at each delimiter if WithRelexing is given.
+//
+// This panics if delim corresponds to an Op in either mode.
+func WithTranscodeDelimiter(delim byte) TranscodeOption {
+	if !IsDelimiter(delim) {
+		panic(fmt.Errorf("can't use %q as a delimiter since it corresponds to an Op", delim))
+	}
+	return transcodeOption(func(t *transcoder) {
+		t.delim = delim
+		t.hasDelim = true
+	})
+}
+
+type transcoder struct {
+	relex    bool
+	delim    byte
+	hasDelim bool
+}
+
+// Transcode reads Watson Representation that is written for the initial mode from, and writes Watson Representation that has the same effect
+// when it is read from the initial mode to.
+//
+// By default, it writes `Snew Gpop` in the mode to, which pushes an empty string, flips the mode to from, and discards the string,
+// before the first Op of the input, and copies everything else as it is. An input without Ops is left as it is.
+// This keeps every byte of the input including comments and whitespace, but it uses one more slot of the stack.
+// See WithRelexing for the other way.
+//
+// Records in the input each start with the initial mode, so without WithTranscodeDelimiter only the first one is transcoded correctly.
+//
+// If from and to are the same, the input is copied as it is.
+func Transcode(w io.Writer, r io.Reader, from, to Mode, opts ...TranscodeOption) error {
+	t := &transcoder{}
+	for _, opt := range opts {
+		opt.apply(t)
+	}
+	if from == to {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	prefix := []byte{showOp(to, vm.Snew), showOp(from, vm.Gpop)}
+	if !t.relex && !t.hasDelim {
+		return prefixFirstOp(w, r, from, prefix)
+	}
+	return t.transcode(w, r, from, to, prefix)
+}
+
+// prefixFirstOp copies r to w, and writes the prefix before the first Op.
+// Unlike transcode, it copies the rest of the input as it is once the prefix is written.
+func prefixFirstOp(w io.Writer, r io.Reader, from Mode, prefix []byte) error {
+	buf := make([]byte, DefaultBufferSize)
+	table := &lookupTables[from]
+	for {
+		n, rerr := r.Read(buf)
+		data := buf[:n]
+		i := 0
+		for i < len(data) && table[data[i]] == noOp {
+			i++
+		}
+		_, err := w.Write(data[:i])
+		if err != nil {
+			return err
+		}
+		if i < len(data) {
+			for _, b := range [][]byte{prefix, data[i:]} {
+				_, err = w.Write(b)
+				if err != nil {
+					return err
+				}
+			}
+			if rerr == nil {
+				_, rerr = io.Copy(w, r)
+				return rerr
+			}
+		}
+		if rerr == io.EOF {
+			return nil
+		} else if rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// transcode reads r byte by byte, and writes the prefix before the first Op of each record or relexes each byte.
+func (t *transcoder) transcode(w io.Writer, r io.Reader, from, to Mode, prefix []byte) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, DefaultBufferSize)
+	var tmp [utf8.UTFMax]byte
+	initialFrom, initialTo := from, to
+	// Empty records are left empty, since a record that only has the prefix can't be decoded.
+	prefixed := false
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if t.hasDelim && b == t.delim {
+				// Delimiters never correspond to Ops.
+				from, to, prefixed = initialFrom, initialTo, false
+				bw.WriteByte(b)
+			} else if !t.relex {
+				if !prefixed && lookupTables[from][b] != noOp {
+					bw.Write(prefix)
+					prefixed = true
+				}
+				bw.WriteByte(b)
+			} else if op := lookupTables[from][b]; op != noOp {
+				bw.WriteByte(showTables[to][op])
+				if op == vm.Snew {
+					from, to = NextMode(from, op), NextMode(to, op)
+				}
+			} else if lookupTables[to][b] != noOp {
+				// Every character that corresponds to an Op is a printable ASCII character.
+				size := utf8.EncodeRune(tmp[:], rune(b)+fullwidthOffset)
+				bw.Write(tmp[:size])
+			} else {
+				bw.WriteByte(b)
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	// bufio.Writer keeps the first error, which is returned here.
+	return bw.Flush()
+}
@@ -0,0 +1,165 @@
+package lexer
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/genkami/watson/pkg/vm"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTranscodeWritesPrefix(t *testing.T) {
+	test := func(src string, from, to Mode, expected string) {
+		out := transcode(t, src, from, to)
+		if out != expected {
+			t.Errorf("expected %#v but got %#v", expected, out)
+		}
+	}
+	test("Shh // comment", S, A, "?eShh // comment")
+	test("Buu // comment", A, S, "$#Buu // comment")
+	test("Buu // comment", A, A, "Buu // comment")
+	// The prefix is written just before the first Op, so an input without Ops is left as it is.
+	test("  \n Buu", A, S, "  \n $#Buu")
+	test("  \n ", A, S, "  \n ")
+	test("", S, A, "")
+}
+
+func TestTranscodeWritesPrefixAcrossReads(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	err := Transcode(out, iotest.OneByteReader(strings.NewReader("  Buu")), A, S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "  $#Buu"; out.String() != want {
+		t.Errorf("expected %#v but got %#v", want, out.String())
+	}
+}
+
+func TestTranscodeWithRelexing(t *testing.T) {
+	test := func(src string, from, to Mode, expected string) {
+		out := transcode(t, src, from, to, WithRelexing())
+		if out != expected {
+			t.Errorf("expected %#v but got %#v", expected, out)
+		}
+	}
+	test("Buu", A, S, "Shh")
+	test("Bu?Sh$B", A, S, "Sh$Bu?S")
+	test("Shh # x", S, A, "Buu ＃ x")
+	test("Bu 日本語", A, S, "Sh 日本語")
+}
+
+func TestTranscodeWithDelimiter(t *testing.T) {
+	test := func(src string, from, to Mode, expected string, opts ...TranscodeOption) {
+		out := transcode(t, src, from, to, append(opts, WithTranscodeDelimiter(DefaultDelimiter))...)
+		if out != expected {
+			t.Errorf("expected %#v but got %#v", expected, out)
+		}
+	}
+	test("Shh\n\n  Shk\n", S, A, "?eShh\n\n  ?eShk\n")
+	test("Bu?S\nBu", A, S, "Sh$B\nSh", WithRelexing())
+	test("Bu\n", A, A, "Bu\n")
+}
+
+func TestTranscodeWithDelimiterKeepsOpsOfEachRecord(t *testing.T) {
+	src := "Bu?Sh\nBuu\n"
+	want, err := readRecords(src, A)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range [][]TranscodeOption{nil, {WithRelexing()}} {
+		out := transcode(t, src, A, S, append(opts, WithTranscodeDelimiter(DefaultDelimiter))...)
+		got, err := readRecords(out, S)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(opts) == 0 {
+			for i := range got {
+				// Drops the prefix.
+				got[i] = got[i][2:]
+			}
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func readRecords(src string, mode Mode) ([][]vm.Op, error) {
+	l := NewLexer(strings.NewReader(src), WithInitialLexerMode(mode), WithDelimiter(DefaultDelimiter))
+	var records [][]vm.Op
+	var ops []vm.Op
+	buf := make([]vm.Op, 16)
+	for {
+		n, err := l.ReadOps(buf)
+		if err == ErrEndOfRecord || err == io.EOF {
+			if len(ops) > 0 {
+				records = append(records, ops)
+				ops = nil
+			}
+			if err == io.EOF {
+				return records, nil
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ops = append(ops, buf[:n]...)
+	}
+}
+
+func TestTranscodeKeepsOps(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	allOps := vm.AllOps()
+	for i := 0; i < 100; i++ {
+		from := Mode(r.Intn(2))
+		ops := make([]vm.Op, r.Intn(100))
+		for j := range ops {
+			ops[j] = allOps[r.Intn(len(allOps))]
+		}
+		buf := bytes.NewBuffer(nil)
+		u := NewUnlexer(buf, WithInitialUnlexerMode(from))
+		err := u.WriteOps(ops)
+		if err == nil {
+			err = u.WriteComment("comment: Bu?$/#")
+		}
+		if err == nil {
+			err = u.Flush()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := buf.String()
+		to := NextMode(from, vm.Snew)
+
+		got, err := readAllWithMode(transcode(t, src, from, to, WithRelexing()), to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(ops, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+
+		got, err = readAllWithMode(transcode(t, src, from, to), to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append([]vm.Op{vm.Snew, vm.Gpop}, ops...)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func transcode(t *testing.T, src string, from, to Mode, opts ...TranscodeOption) string {
+	buf := bytes.NewBuffer(nil)
+	err := Transcode(buf, strings.NewReader(src), from, to, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,69 @@
+package lexer
+
+import (
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// UnlexedChunk is a sequence of `vm.Op`s that is converted into characters from both modes in advance.
+//
+// Since each Snew flips the mode, the characters of a part of the output depend on the parity of Snews before it.
+// UnlexedChunk makes it possible to convert parts of the output on multiple goroutines before knowing the mode in which each of them starts,
+// and to write them in order with `Unlexer.WriteChunk`.
+type UnlexedChunk struct {
+	text  [2][]byte // the characters of the chunk, indexed by the mode in which it starts
+	flips bool      // whether the chunk has an odd number of Snews
+}
+
+// UnlexChunk converts ops into an UnlexedChunk.
+func UnlexChunk(ops []vm.Op) *UnlexedChunk {
+	c := &UnlexedChunk{}
+	for _, start := range []Mode{A, S} {
+		text := make([]byte, len(ops))
+		mode := start
+		table := showTables[mode]
+		for i, op := range ops {
+			text[i] = table[op]
+			if op == vm.Snew {
+				mode = NextMode(mode, op)
+				table = showTables[mode]
+			}
+		}
+		c.text[start] = text
+		c.flips = mode != start
+	}
+	return c
+}
+
+// Text returns the characters of the chunk when it starts with mode.
+func (c *UnlexedChunk) Text(mode Mode) []byte {
+	return c.text[mode]
+}
+
+// EndMode returns the mode after the chunk when it starts with mode.
+func (c *UnlexedChunk) EndMode(mode Mode) Mode {
+	if c.flips {
+		return NextMode(mode, vm.Snew)
+	}
+	return mode
+}
+
+// WriteChunk writes c to the underlying io.Writer. This has the same effect as writing the Ops from which c is converted.
+func (u *Unlexer) WriteChunk(c *UnlexedChunk) error {
+	text := c.Text(u.mode)
+	for len(text) > 0 {
+		if len(u.buf) == cap(u.buf) {
+			err := u.Flush()
+			if err != nil {
+				return err
+			}
+		}
+		n := cap(u.buf) - len(u.buf)
+		if n > len(text) {
+			n = len(text)
+		}
+		u.buf = append(u.buf, text[:n]...)
+		text = text[n:]
+	}
+	u.mode = c.EndMode(u.mode)
+	return nil
+}
@@ -0,0 +1,56 @@
+package lexer
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/genkami/watson/pkg/vm"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteChunkWritesTheSameCharactersAsWriteOps(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	allOps := vm.AllOps()
+	for i := 0; i < 100; i++ {
+		chunks := make([][]vm.Op, r.Intn(5))
+		for j := range chunks {
+			ops := make([]vm.Op, r.Intn(30))
+			for k := range ops {
+				ops[k] = allOps[r.Intn(len(allOps))]
+			}
+			chunks[j] = ops
+		}
+		for _, mode := range []Mode{A, S} {
+			want := &bytes.Buffer{}
+			wu := NewUnlexer(want, WithInitialUnlexerMode(mode))
+			got := &bytes.Buffer{}
+			gu := NewUnlexer(got, WithInitialUnlexerMode(mode), WithUnlexerBufferSize(7))
+			for _, ops := range chunks {
+				err := wu.WriteOps(ops)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = gu.WriteChunk(UnlexChunk(ops))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if wu.Mode() != gu.Mode() {
+					t.Fatalf("expected %d but got %d", wu.Mode(), gu.Mode())
+				}
+			}
+			err := wu.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = gu.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want.String(), got.String()); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		}
+	}
+}
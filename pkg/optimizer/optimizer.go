@@ -0,0 +1,164 @@
+// Package optimizer removes redundant `vm.Op`s from Watson Representation.
+package optimizer
+
+import (
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+const (
+	// maxPending is the number of Ops that an Optimizer keeps before writing them.
+	maxPending = 4096
+	// keptOnFlush is the number of Ops that an Optimizer keeps when it writes pending Ops because there are too many of them.
+	// Ops that are already written can't be removed any more, so they have a chance to be removed together with the next Ops.
+	keptOnFlush = 256
+)
+
+// Optimizer behaves as a `lexer.OpWriter` and writes a shorter sequence of Ops that has the same effect as the Ops that are written to it.
+//
+// It repeatedly rewrites pairs of adjacent Ops such as `Ineg Ineg`, `Gdup Gpop` or `Inew Gpop`, so it also removes decorations of the prettifier.
+// Each rewrite has the same effect on the VM as long as the original Ops do not fail, but a sequence of Ops that fails (e.g. `Ineg Ineg` on a String) might succeed after it is optimized.
+//
+// Optimizer keeps some of the Ops that are written in order to rewrite them with the following Ops. Call Flush after writing all Ops.
+type Optimizer struct {
+	w       lexer.OpWriter
+	pending []vm.Op
+	snews   int // the number of Snews in pending
+	one     [1]vm.Op
+}
+
+// NewOptimizer returns a new Optimizer that writes to w.
+func NewOptimizer(w lexer.OpWriter) *Optimizer {
+	return &Optimizer{w: w}
+}
+
+// Write writes op to the Optimizer.
+func (o *Optimizer) Write(op vm.Op) error {
+	o.one[0] = op
+	return o.WriteOps(o.one[:])
+}
+
+// WriteOps writes ops to the Optimizer. This has the same effect as calling Write for each op.
+func (o *Optimizer) WriteOps(ops []vm.Op) error {
+	for _, op := range ops {
+		o.push(op)
+	}
+	if len(o.pending) > maxPending {
+		return o.writePending(len(o.pending) - keptOnFlush)
+	}
+	return nil
+}
+
+// Flush writes all pending Ops to the underlying OpWriter.
+// It does not flush the underlying OpWriter.
+func (o *Optimizer) Flush() error {
+	return o.writePending(len(o.pending))
+}
+
+// Mode returns the mode that the underlying OpWriter will be in after all pending Ops are written.
+// Since each Snew flips the mode, removing Ops may change the mode of the following Ops.
+func (o *Optimizer) Mode() lexer.Mode {
+	mode := o.w.Mode()
+	if o.snews%2 == 1 {
+		mode = lexer.NextMode(mode, vm.Snew)
+	}
+	return mode
+}
+
+func (o *Optimizer) writePending(n int) error {
+	for _, op := range o.pending[:n] {
+		if op == vm.Snew {
+			o.snews--
+		}
+	}
+	err := o.w.WriteOps(o.pending[:n])
+	rest := copy(o.pending, o.pending[n:])
+	o.pending = o.pending[:rest]
+	return err
+}
+
+// push appends op to the pending Ops, rewriting it together with the last one as many times as possible.
+func (o *Optimizer) push(op vm.Op) {
+	for len(o.pending) > 0 {
+		last := o.pending[len(o.pending)-1]
+		rewritten, ok := rewrite(last, op)
+		if !ok {
+			break
+		}
+		o.pending = o.pending[:len(o.pending)-1]
+		if last == vm.Snew {
+			o.snews--
+		}
+		if len(rewritten) == 0 {
+			return
+		}
+		op = rewritten[0]
+	}
+	o.pending = append(o.pending, op)
+	if op == vm.Snew {
+		o.snews++
+	}
+}
+
+// Optimize returns a shorter sequence of Ops that has the same effect as ops in the same way as Optimizer.
+func Optimize(ops []vm.Op) []vm.Op {
+	w := lexer.NewSliceWriter()
+	o := NewOptimizer(w)
+	// Neither of them fails because SliceWriter never fails.
+	_ = o.WriteOps(ops)
+	_ = o.Flush()
+	return w.Ops()
+}
+
+var (
+	nothing  = []vm.Op{}
+	onlyInew = []vm.Op{vm.Inew}
+	onlyGpop = []vm.Op{vm.Gpop}
+	onlyGdup = []vm.Op{vm.Gdup}
+)
+
+// rewrite returns a shorter sequence of Ops that has the same effect as a followed by b.
+// The second return value is false if there is no such sequence.
+// The result has at most one Op.
+func rewrite(a, b vm.Op) ([]vm.Op, bool) {
+	switch {
+	case a == b && (a == vm.Ineg || a == vm.Fneg || a == vm.Bneg || a == vm.Gswp):
+		// Negating twice and swapping twice do nothing.
+		return nothing, true
+	case a == vm.Gdup && b == vm.Gpop:
+		return nothing, true
+	case a == vm.Gdup && b == vm.Gswp:
+		// Both of them are the same value.
+		return onlyGdup, true
+	case b == vm.Gpop && pushesConstant(a):
+		return nothing, true
+	case b == vm.Gpop && isUnary(a):
+		// The result of a is discarded anyway.
+		return onlyGpop, true
+	case a == vm.Inew && (b == vm.Iadd || b == vm.Isht):
+		// Adding zero or shifting by zero does nothing.
+		return nothing, true
+	case a == vm.Inew && (b == vm.Ishl || b == vm.Ineg):
+		// The result is still zero.
+		return onlyInew, true
+	}
+	return nil, false
+}
+
+// pushesConstant reports whether op pushes a value without popping anything.
+func pushesConstant(op vm.Op) bool {
+	switch op {
+	case vm.Inew, vm.Finf, vm.Fnan, vm.Snew, vm.Onew, vm.Anew, vm.Bnew, vm.Nnew:
+		return true
+	}
+	return false
+}
+
+// isUnary reports whether op pops a value and pushes another value that is converted from it.
+func isUnary(op vm.Op) bool {
+	switch op {
+	case vm.Iinc, vm.Ishl, vm.Ineg, vm.Itof, vm.Itou, vm.Fneg, vm.Bneg:
+		return true
+	}
+	return false
+}
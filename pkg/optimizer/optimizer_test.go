@@ -0,0 +1,241 @@
+package optimizer
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/dumper"
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/prettifier"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+func TestOptimizeRemovesRedundantOps(t *testing.T) {
+	test := func(src, want string) {
+		t.Helper()
+		orig, err := lex(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		optimized := Optimize(orig)
+		assertSameResult(t, orig, optimized)
+		got, err := unlex(optimized)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want != got {
+			t.Errorf("%s: expected %#v but got %#v", src, want, got)
+		}
+	}
+	test("Bu", "Bu")
+	test("BuAA", "Bu")
+	test("BuAAA", "BuA")
+	test("Bzoo", "Bz")
+	test("Bippp", "Bip")
+	test("BuBu%%", "BuBu")
+	test("BuE#", "Bu")
+	test("BuE%a", "BuEa")
+	test("BuB#", "Bu")
+	test("Bu?e", "Bu")
+	test("BuBuu#", "Bu")
+	test("BuBa", "Bu")
+	test("BuBe", "Bu")
+	test("BbuA", "BuA")
+	test("BBbAu", "BBu")
+	test("BuAEA#A", "Bu")
+	// Ops that can't be removed are kept.
+	test("BuBua#", "BuBua#")
+	test("BuBue", "BuBue")
+}
+
+func TestOptimizeRemovesDecorationsOfPrettifier(t *testing.T) {
+	for _, src := range []string{
+		"~?$#zM",
+		"~?$#BM",
+		"~?$#BuM",
+		"~?$#BBaM",
+		"?SShak",
+		"?SShaShaAk",
+		"?+",
+	} {
+		orig, err := lex(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prettified := prettify(t, orig)
+		optimized := Optimize(prettified)
+		assertSameResult(t, orig, optimized)
+		if len(optimized) > len(orig) {
+			t.Errorf("%s: expected at most %d Ops but got %d", src, len(orig), len(optimized))
+		}
+	}
+}
+
+func TestOptimizerKeepsSemanticsOfDumpedValues(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		v := randomValue(r, 4)
+		sw := lexer.NewSliceWriter()
+		err := dumper.NewDumper(sw).Dump(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain := sw.Ops()
+		orig := prettify(t, plain)
+		optimized := Optimize(orig)
+		assertSameResult(t, orig, optimized)
+		if len(optimized) > len(plain) {
+			t.Errorf("expected at most %d Ops but got %d", len(plain), len(optimized))
+		}
+	}
+}
+
+func TestOptimizerWritesTheSameOpsAsOptimize(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ops := make([]vm.Op, 3*maxPending)
+	for i := range ops {
+		ops[i] = []vm.Op{vm.Inew, vm.Ineg, vm.Gdup, vm.Gpop, vm.Gswp, vm.Snew}[r.Intn(6)]
+	}
+	want := Optimize(ops)
+	sw := lexer.NewSliceWriter()
+	o := NewOptimizer(sw)
+	for i := 0; i < len(ops); i += 100 {
+		end := i + 100
+		if end > len(ops) {
+			end = len(ops)
+		}
+		err := o.WriteOps(ops[i:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantMode := lexer.A
+		for _, op := range append(sw.Ops(), o.pending...) {
+			wantMode = lexer.NextMode(wantMode, op)
+		}
+		if o.Mode() != wantMode {
+			t.Fatalf("expected mode %v but got %v", wantMode, o.Mode())
+		}
+	}
+	err := o.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, sw.Ops()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestModeTakesRemovedSnewsIntoAccount(t *testing.T) {
+	sw := lexer.NewSliceWriter()
+	o := NewOptimizer(sw)
+	err := o.WriteOps([]vm.Op{vm.Inew, vm.Snew})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Mode() != lexer.S {
+		t.Errorf("expected S but got %v", o.Mode())
+	}
+	err = o.Write(vm.Gpop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Mode() != lexer.A {
+		t.Errorf("expected A but got %v", o.Mode())
+	}
+}
+
+func randomValue(r *rand.Rand, depth int) *types.Value {
+	switch n := r.Intn(8); {
+	case depth > 0 && n == 0:
+		obj := types.NewObjectValue(nil)
+		for i := r.Intn(4); i > 0; i-- {
+			obj.Object.Set(string(rune('a'+r.Intn(26))), randomValue(r, depth-1))
+		}
+		return obj
+	case depth > 0 && n == 1:
+		arr := make([]*types.Value, r.Intn(4))
+		for i := range arr {
+			arr[i] = randomValue(r, depth-1)
+		}
+		return types.NewArrayValue(arr)
+	case n == 2:
+		return types.NewUintValue(r.Uint64())
+	case n == 3:
+		return types.NewFloatValue(r.NormFloat64())
+	case n == 4:
+		return types.NewStringValue([]byte{byte(r.Intn(256)), byte(r.Intn(256))})
+	case n == 5:
+		return types.NewBoolValue(r.Intn(2) == 0)
+	case n == 6:
+		return types.NewNilValue()
+	default:
+		return types.NewIntValue(r.Int63n(1000) - 500)
+	}
+}
+
+func assertSameResult(t *testing.T, orig, optimized []vm.Op) {
+	t.Helper()
+	want, err := execute(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := execute(optimized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func lex(src string) ([]vm.Op, error) {
+	ops := make([]vm.Op, 0, len(src))
+	l := lexer.NewLexer(bytes.NewReader([]byte(src)))
+	for {
+		tok, err := l.Next()
+		if err == io.EOF {
+			return ops, nil
+		} else if err != nil {
+			return nil, err
+		}
+		ops = append(ops, tok.Op)
+	}
+}
+
+func prettify(t *testing.T, orig []vm.Op) []vm.Op {
+	t.Helper()
+	sw := lexer.NewSliceWriter()
+	err := prettifier.NewPrettifier(sw).WriteOps(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sw.Ops()
+}
+
+func execute(ops []vm.Op) ([]*types.Value, error) {
+	v := vm.NewVM()
+	err := v.FeedMulti(ops)
+	if err != nil {
+		return nil, err
+	}
+	return v.Stack(), nil
+}
+
+func unlex(ops []vm.Op) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	ul := lexer.NewUnlexer(buf)
+	err := ul.WriteOps(ops)
+	if err != nil {
+		return "", err
+	}
+	err = ul.Flush()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -10,74 +10,115 @@ import (
 
 // Preffifier behaves as a lexer.OpWriter and writes some meaningless Ops to its underlying OpWriter in addition to any Ops that are written.
 type Prettifier struct {
-	w    lexer.OpWriter
-	last *vm.Op
+	w       lexer.OpWriter
+	rules   map[ruleKey][]*Rule
+	last    vm.Op
+	hasLast bool
+	one     [1]vm.Op
+	buf     []vm.Op
+	err     error // the error that every write fails with, e.g. because of an invalid theme
 }
 
-// NewPrettifier returns a new Prettifier.
-func NewPrettifier(w lexer.OpWriter) *Prettifier {
-	return &Prettifier{w: w, last: nil}
+// ruleKey identifies rules that may be applied to an Op.
+type ruleKey struct {
+	mode lexer.Mode
+	op   vm.Op
 }
 
-// Write writes op to the underlying OpWriter.
-// It sometimes writes one or more extra Ops to decorate output.
-func (p *Prettifier) Write(op vm.Op) error {
-	var err error
-	if p.last == nil {
-		err = p.w.Write(op)
-		if err != nil {
-			return err
-		}
-	} else {
-		err = p.writeWithDecoration(op, *p.last)
+// PrettifierOption configures a Prettifier.
+type PrettifierOption interface {
+	apply(*Prettifier)
+}
+
+type prettifierOption func(*Prettifier)
+
+func (opt prettifierOption) apply(p *Prettifier) {
+	opt(p)
+}
+
+// WithTheme makes a Prettifier decorate its output with the rules of t instead of `DefaultTheme`.
+// If more than one rule can be applied to an Op, the first one in t.Rules is applied.
+//
+// t is verified by `Theme.Verify` so that the decorations never change the result of the VM.
+// If it fails, every write to the Prettifier fails with the error and nothing is written.
+func WithTheme(t *Theme) PrettifierOption {
+	return prettifierOption(func(p *Prettifier) {
+		err := t.Verify()
 		if err != nil {
-			return err
+			p.err = fmt.Errorf("invalid theme: %w", err)
+			return
 		}
-	}
-	p.last = &op
-	return nil
+		p.setTheme(t)
+	})
 }
 
-func (p *Prettifier) writeWithDecoration(op vm.Op, last vm.Op) error {
-	switch p.Mode() {
-	case lexer.A:
-		return p.writeWithDecorationA(op, last)
-	case lexer.S:
-		return p.writeWithDecorationS(op, last)
-	default:
-		panic(fmt.Errorf("unknown mode: %d", p.Mode()))
+// NewPrettifier returns a new Prettifier.
+func NewPrettifier(w lexer.OpWriter, opts ...PrettifierOption) *Prettifier {
+	p := &Prettifier{w: w}
+	p.setTheme(DefaultTheme())
+	for _, opt := range opts {
+		opt.apply(p)
 	}
+	return p
 }
 
-func (p *Prettifier) writeWithDecorationA(op vm.Op, last vm.Op) error {
-	if last == vm.Bnew && op == vm.Oadd {
-		return p.writeMulti(vm.Bneg, vm.Bneg, vm.Oadd)
-	} else if topShouldBeInt(last) && op == vm.Oadd {
-		return p.writeMulti(vm.Ineg, vm.Ineg, vm.Oadd, vm.Gdup, vm.Gpop)
-	} else {
-		return p.w.Write(op)
+func (p *Prettifier) setTheme(t *Theme) {
+	p.rules = make(map[ruleKey][]*Rule)
+	for i := range t.Rules {
+		r := &t.Rules[i]
+		key := ruleKey{mode: r.Mode, op: r.Op}
+		p.rules[key] = append(p.rules[key], r)
 	}
 }
 
-func (p *Prettifier) writeWithDecorationS(op vm.Op, last vm.Op) error {
-	if last == vm.Ishl && op == vm.Iadd { // Sharrk
-		return p.writeMulti(vm.Ineg, vm.Ineg, vm.Iadd)
-	} else if last == vm.Isht && op == vm.Iadd { // ShaArrk
-		return p.writeMulti(vm.Ineg, vm.Ineg, vm.Iadd)
-	} else if op == vm.Onew { // Samee+
-		return p.writeMulti(vm.Inew, vm.Ishl, vm.Finf, vm.Gpop, vm.Gpop, vm.Onew)
-	} else {
-		return p.w.Write(op)
-	}
+// Write writes op to the underlying OpWriter.
+// It sometimes writes one or more extra Ops to decorate output.
+func (p *Prettifier) Write(op vm.Op) error {
+	p.one[0] = op
+	return p.WriteOps(p.one[:])
 }
 
-func (p *Prettifier) writeMulti(ops ...vm.Op) error {
+// WriteOps writes ops to the underlying OpWriter at once, decorating them in the same way as Write.
+func (p *Prettifier) WriteOps(ops []vm.Op) error {
+	if p.err != nil {
+		return p.err
+	}
+	buf := p.buf[:0]
+	mode := p.w.Mode()
 	for _, op := range ops {
-		err := p.w.Write(op)
-		if err != nil {
-			return err
+		start := len(buf)
+		if p.hasLast {
+			buf = p.decorate(buf, mode, op, p.last)
+		} else {
+			buf = append(buf, op)
+		}
+		for _, written := range buf[start:] {
+			mode = lexer.NextMode(mode, written)
+		}
+		p.last, p.hasLast = op, true
+	}
+	p.buf = buf
+	return p.w.WriteOps(buf)
+}
+
+// decorate appends op and its decoration to buf.
+func (p *Prettifier) decorate(buf []vm.Op, mode lexer.Mode, op vm.Op, last vm.Op) []vm.Op {
+	for _, r := range p.rules[ruleKey{mode: mode, op: op}] {
+		if r.matches(last) {
+			return append(buf, r.Replacement...)
 		}
 	}
+	return append(buf, op)
+}
+
+// WriteComment writes a comment to the underlying OpWriter if it is a `lexer.CommentWriter`. Otherwise the comment is discarded.
+func (p *Prettifier) WriteComment(text string) error {
+	if p.err != nil {
+		return p.err
+	}
+	if cw, ok := p.w.(lexer.CommentWriter); ok {
+		return cw.WriteComment(text)
+	}
 	return nil
 }
 
@@ -85,12 +126,3 @@ func (p *Prettifier) writeMulti(ops ...vm.Op) error {
 func (p *Prettifier) Mode() lexer.Mode {
 	return p.w.Mode()
 }
-
-func topShouldBeInt(op vm.Op) bool {
-	for _, v := range []vm.Op{vm.Inew, vm.Iinc, vm.Ishl, vm.Iadd, vm.Ineg, vm.Isht} {
-		if op == v {
-			return true
-		}
-	}
-	return false
-}
@@ -53,6 +53,32 @@ func TestPrettifierDoesNotChangeSemantics(t *testing.T) {
 	test("~?$#BBeM", "~?$#BBeAAME#")
 }
 
+func TestWriteOpsIsTheSameAsWrite(t *testing.T) {
+	for _, src := range []string{"?SShaShaAk", "?+", "~?$#zM~?$#BuM", "~?$#BBeM?Shak"} {
+		orig, err := lex(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := prettify(orig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sw := lexer.NewSliceWriter()
+		p := NewPrettifier(sw)
+		err = p.WriteOps(orig[:len(orig)/2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.WriteOps(orig[len(orig)/2:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, sw.Ops()); diff != "" {
+			t.Errorf("%s: mismatch (-want +got):\n%s", src, diff)
+		}
+	}
+}
+
 func lex(src string) ([]vm.Op, error) {
 	ops := make([]vm.Op, 0, len(src))
 	l := lexer.NewLexer(bytes.NewReader([]byte(src)))
@@ -67,9 +93,9 @@ func lex(src string) ([]vm.Op, error) {
 	}
 }
 
-func prettify(orig []vm.Op) ([]vm.Op, error) {
+func prettify(orig []vm.Op, opts ...PrettifierOption) ([]vm.Op, error) {
 	sw := lexer.NewSliceWriter()
-	p := NewPrettifier(sw)
+	p := NewPrettifier(sw, opts...)
 	for _, op := range orig {
 		err := p.Write(op)
 		if err != nil {
@@ -99,5 +125,9 @@ func unlex(ops []vm.Op) (string, error) {
 			return "", err
 		}
 	}
+	err := ul.Flush()
+	if err != nil {
+		return "", err
+	}
 	return buf.String(), nil
 }
@@ -0,0 +1,316 @@
+package prettifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// Theme is a set of rules that determine how a Prettifier decorates its output.
+//
+// A theme can be written in JSON as follows:
+//
+//	{
+//	  "rules": [
+//	    {"mode": "A", "last": ["Bnew"], "op": "Oadd", "replacement": ["Bneg", "Bneg", "Oadd"]}
+//	  ]
+//	}
+type Theme struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule replaces an Op with a sequence of Ops that has the same effect.
+// It is applied when the current mode of the output is Mode, the Op that is written is Op, and the Op that was written last is one of Last.
+// If Last is empty, the rule is applied regardless of the last Op, but never to the first Op.
+type Rule struct {
+	Mode        lexer.Mode
+	Last        []vm.Op
+	Op          vm.Op
+	Replacement []vm.Op
+}
+
+// DefaultTheme returns the theme that a Prettifier uses by default.
+func DefaultTheme() *Theme {
+	intOps := []vm.Op{vm.Inew, vm.Iinc, vm.Ishl, vm.Iadd, vm.Ineg, vm.Isht}
+	return &Theme{
+		Rules: []Rule{
+			{Mode: lexer.A, Last: []vm.Op{vm.Bnew}, Op: vm.Oadd, Replacement: []vm.Op{vm.Bneg, vm.Bneg, vm.Oadd}},
+			{Mode: lexer.A, Last: intOps, Op: vm.Oadd, Replacement: []vm.Op{vm.Ineg, vm.Ineg, vm.Oadd, vm.Gdup, vm.Gpop}},
+			// Sharrk
+			{Mode: lexer.S, Last: []vm.Op{vm.Ishl}, Op: vm.Iadd, Replacement: []vm.Op{vm.Ineg, vm.Ineg, vm.Iadd}},
+			// ShaArrk
+			{Mode: lexer.S, Last: []vm.Op{vm.Isht}, Op: vm.Iadd, Replacement: []vm.Op{vm.Ineg, vm.Ineg, vm.Iadd}},
+			// Samee+
+			{Mode: lexer.S, Op: vm.Onew, Replacement: []vm.Op{vm.Inew, vm.Ishl, vm.Finf, vm.Gpop, vm.Gpop, vm.Onew}},
+		},
+	}
+}
+
+// LoadTheme reads a theme written in JSON from r, and verifies it by `Theme.Verify`.
+func LoadTheme(r io.Reader) (*Theme, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var t Theme
+	err := dec.Decode(&t)
+	if err != nil {
+		return nil, err
+	}
+	err = t.Verify()
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// matches reports whether r is applied to op that follows last.
+func (r *Rule) matches(last vm.Op) bool {
+	if len(r.Last) == 0 {
+		return true
+	}
+	for _, op := range r.Last {
+		if op == last {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks that no rule of t changes the result of the VM.
+//
+// For each rule, it runs the last Op followed by Op and the last Op followed by Replacement from various stacks of sample values, and compares the resulting stacks.
+// A replacement may succeed where the original Ops fail, but it must leave the same stack whenever the original Ops succeed.
+func (t *Theme) Verify() error {
+	for i := range t.Rules {
+		err := t.Rules[i].verify()
+		if err != nil {
+			return fmt.Errorf("rule #%d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) verify() error {
+	// If the rule does not depend on the last Op, it is run directly on the sample stacks.
+	prefixes := [][]vm.Op{nil}
+	if len(r.Last) > 0 {
+		prefixes = prefixes[:0]
+		for _, last := range r.Last {
+			prefixes = append(prefixes, []vm.Op{last})
+		}
+	}
+	for _, prefix := range prefixes {
+		orig := append(append([]vm.Op{}, prefix...), r.Op)
+		replaced := append(append([]vm.Op{}, prefix...), r.Replacement...)
+		for _, stack := range sampleStacks() {
+			want, err := run(stack, orig)
+			if err != nil {
+				continue
+			}
+			got, err := run(stack, replaced)
+			if err != nil {
+				return fmt.Errorf("%#v fails where %#v succeeds: %w", replaced, orig, err)
+			}
+			if !sameStack(want, got) {
+				return fmt.Errorf("%#v results in %#v but %#v results in %#v", orig, want, replaced, got)
+			}
+		}
+	}
+	return nil
+}
+
+// maxSampleDepth is the number of sample values on each stack that is used to verify rules.
+const maxSampleDepth = 3
+
+// sampleValues returns values of every kind.
+func sampleValues() []*types.Value {
+	return []*types.Value{
+		types.NewIntValue(0),
+		types.NewIntValue(-3),
+		types.NewIntValue(math.MaxInt64),
+		types.NewUintValue(7),
+		types.NewFloatValue(1.5),
+		types.NewFloatValue(math.Inf(-1)),
+		types.NewStringValue([]byte("ab")),
+		types.NewObjectValue(map[string]*types.Value{"k": types.NewIntValue(1)}),
+		types.NewArrayValue([]*types.Value{types.NewNilValue()}),
+		types.NewBoolValue(true),
+		types.NewNilValue(),
+	}
+}
+
+// sampleStacks returns all stacks that consist of at most maxSampleDepth sample values.
+func sampleStacks() [][]*types.Value {
+	values := sampleValues()
+	stacks := [][]*types.Value{{}}
+	prev := stacks
+	for depth := 1; depth <= maxSampleDepth; depth++ {
+		var next [][]*types.Value
+		for _, stack := range prev {
+			for _, v := range values {
+				s := append(append([]*types.Value{}, stack...), v)
+				next = append(next, s)
+			}
+		}
+		stacks = append(stacks, next...)
+		prev = next
+	}
+	return stacks
+}
+
+// run executes ops on a VM whose stack is initialized with the copies of stack, and returns the resulting stack.
+func run(stack []*types.Value, ops []vm.Op) ([]*types.Value, error) {
+	m := vm.NewVM(vm.WithStackSize(maxSampleDepth + 16))
+	for _, v := range stack {
+		err := m.Push(v.DeepCopy())
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := m.FeedMulti(ops)
+	if err != nil {
+		return nil, err
+	}
+	return m.Stack(), nil
+}
+
+func sameStack(a, b []*types.Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameValue(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameValue reports whether a and b are exactly the same, including the order of keys and the bits of floats.
+func sameValue(a, b *types.Value) bool {
+	if a.Kind != b.Kind {
+		return false
+	}
+	switch a.Kind {
+	case types.Int:
+		return a.Int == b.Int
+	case types.Uint:
+		return a.Uint == b.Uint
+	case types.Float:
+		return math.Float64bits(a.Float) == math.Float64bits(b.Float)
+	case types.String:
+		return string(a.String) == string(b.String)
+	case types.Object:
+		keys := a.Object.Keys()
+		if len(keys) != b.Object.Len() {
+			return false
+		}
+		for i, k := range b.Object.Keys() {
+			if keys[i] != k {
+				return false
+			}
+			x, _ := a.Object.Get(k)
+			y, _ := b.Object.Get(k)
+			if !sameValue(x, y) {
+				return false
+			}
+		}
+		return true
+	case types.Array:
+		if len(a.Array) != len(b.Array) {
+			return false
+		}
+		for i := range a.Array {
+			if !sameValue(a.Array[i], b.Array[i]) {
+				return false
+			}
+		}
+		return true
+	case types.Bool:
+		return a.Bool == b.Bool
+	default:
+		return true
+	}
+}
+
+// ruleJSON is the representation of Rule in JSON.
+type ruleJSON struct {
+	Mode        string   `json:"mode"`
+	Last        []string `json:"last,omitempty"`
+	Op          string   `json:"op"`
+	Replacement []string `json:"replacement"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r Rule) MarshalJSON() ([]byte, error) {
+	var mode string
+	switch r.Mode {
+	case lexer.A:
+		mode = "A"
+	case lexer.S:
+		mode = "S"
+	default:
+		return nil, fmt.Errorf("unknown mode: %d", r.Mode)
+	}
+	return json.Marshal(&ruleJSON{
+		Mode:        mode,
+		Last:        opNames(r.Last),
+		Op:          r.Op.GoString(),
+		Replacement: opNames(r.Replacement),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	var rj ruleJSON
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&rj)
+	if err != nil {
+		return err
+	}
+	switch rj.Mode {
+	case "A":
+		r.Mode = lexer.A
+	case "S":
+		r.Mode = lexer.S
+	default:
+		return fmt.Errorf("unknown mode: %q", rj.Mode)
+	}
+	r.Last, err = parseOps(rj.Last)
+	if err != nil {
+		return err
+	}
+	ops, err := parseOps([]string{rj.Op})
+	if err != nil {
+		return err
+	}
+	r.Op = ops[0]
+	r.Replacement, err = parseOps(rj.Replacement)
+	return err
+}
+
+func opNames(ops []vm.Op) []string {
+	names := make([]string, 0, len(ops))
+	for _, op := range ops {
+		names = append(names, op.GoString())
+	}
+	return names
+}
+
+func parseOps(names []string) ([]vm.Op, error) {
+	var ops []vm.Op
+	for _, name := range names {
+		op, err := vm.ParseOp(name)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
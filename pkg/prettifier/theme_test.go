@@ -0,0 +1,133 @@
+package prettifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+func TestDefaultThemeIsValid(t *testing.T) {
+	err := DefaultTheme().Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadTheme(t *testing.T) {
+	src := `{
+		"rules": [
+			{"mode": "A", "last": ["Inew", "Iinc"], "op": "Iinc", "replacement": ["Iinc", "Ineg", "Ineg"]},
+			{"mode": "S", "op": "Anew", "replacement": ["Nnew", "Gpop", "Anew"]}
+		]
+	}`
+	theme, err := LoadTheme(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Theme{
+		Rules: []Rule{
+			{Mode: lexer.A, Last: []vm.Op{vm.Inew, vm.Iinc}, Op: vm.Iinc, Replacement: []vm.Op{vm.Iinc, vm.Ineg, vm.Ineg}},
+			{Mode: lexer.S, Op: vm.Anew, Replacement: []vm.Op{vm.Nnew, vm.Gpop, vm.Anew}},
+		},
+	}
+	if diff := cmp.Diff(want, theme); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadThemeFromMarshaledTheme(t *testing.T) {
+	src, err := json.Marshal(DefaultTheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	theme, err := LoadTheme(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(DefaultTheme(), theme); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadThemeRejectsInvalidThemes(t *testing.T) {
+	test := func(src string) {
+		_, err := LoadTheme(strings.NewReader(src))
+		if err == nil {
+			t.Errorf("expected error but got nil: %s", src)
+		}
+	}
+	// malformed rules
+	test(`{"rules": [{"mode": "B", "op": "Inew", "replacement": ["Inew"]}]}`)
+	test(`{"rules": [{"mode": "A", "op": "Ixxx", "replacement": ["Inew"]}]}`)
+	test(`{"rules": [{"mode": "A", "op": "Inew", "replacement": ["Inew", "Ixxx"]}]}`)
+	test(`{"rules": [{"mode": "A", "op": "Inew", "replacement": ["Inew"], "unknown": 1}]}`)
+	// rules that change the result
+	test(`{"rules": [{"mode": "A", "op": "Ineg", "replacement": []}]}`)
+	test(`{"rules": [{"mode": "A", "last": ["Bnew"], "op": "Oadd", "replacement": ["Bneg", "Oadd"]}]}`)
+	test(`{"rules": [{"mode": "S", "last": ["Inew"], "op": "Iinc", "replacement": ["Iinc", "Gdup"]}]}`)
+	// a rule without last that only looks right when the top two values are the same
+	test(`{"rules": [{"mode": "A", "op": "Gswp", "replacement": []}]}`)
+	// a rule that fails where the original succeeds
+	test(`{"rules": [{"mode": "A", "last": ["Inew"], "op": "Iinc", "replacement": ["Bneg", "Iinc"]}]}`)
+}
+
+func TestPrettifierWithInvalidThemeFailsToWrite(t *testing.T) {
+	theme := &Theme{
+		Rules: []Rule{
+			{Mode: lexer.A, Op: vm.Ineg, Replacement: []vm.Op{}},
+		},
+	}
+	sw := lexer.NewSliceWriter()
+	p := NewPrettifier(sw, WithTheme(theme))
+	err := p.WriteOps([]vm.Op{vm.Inew, vm.Ineg})
+	if err == nil {
+		t.Fatal("expected error but got nil")
+	}
+	err = p.Write(vm.Inew)
+	if err == nil {
+		t.Fatal("expected error but got nil")
+	}
+	if len(sw.Ops()) != 0 {
+		t.Errorf("expected nothing to be written but got %#v", sw.Ops())
+	}
+}
+
+func TestPrettifierWithTheme(t *testing.T) {
+	theme := &Theme{
+		Rules: []Rule{
+			{Mode: lexer.A, Last: []vm.Op{vm.Inew}, Op: vm.Iinc, Replacement: []vm.Op{vm.Iinc, vm.Ineg, vm.Ineg}},
+			{Mode: lexer.A, Last: []vm.Op{vm.Inew}, Op: vm.Iinc, Replacement: []vm.Op{vm.Iinc, vm.Gdup, vm.Gpop}},
+		},
+	}
+	err := theme.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := func(src string, expected string) {
+		orig, err := lex(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prettified, err := prettify(orig, WithTheme(theme))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := unlex(prettified)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != actual {
+			t.Errorf("expected %#v but got %#v", expected, actual)
+		}
+	}
+	test("Bu", "BuAA")
+	test("Buu", "BuAAu")
+	// The default theme is not used.
+	test("~?$#zM", "~?$#zM")
+}
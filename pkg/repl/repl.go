@@ -0,0 +1,278 @@
+// Package repl executes Watson Representation typed line by line, showing the stack after each line.
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/genkami/watson"
+	"github.com/genkami/watson/pkg/converter/json"
+	"github.com/genkami/watson/pkg/converter/yaml"
+	"github.com/genkami/watson/pkg/debugger"
+	"github.com/genkami/watson/pkg/lexer"
+	"github.com/genkami/watson/pkg/types"
+	"github.com/genkami/watson/pkg/vm"
+)
+
+// commandPrefix starts a command. It is not an instruction in either mode.
+const commandPrefix = '\\'
+
+// ReplOption configures a Repl.
+type ReplOption interface {
+	apply(*Repl)
+}
+
+type replOption func(*Repl)
+
+func (opt replOption) apply(r *Repl) {
+	opt(r)
+}
+
+// WithInitialMode sets the mode in which the first line is lexed. The default is lexer.A.
+func WithInitialMode(mode lexer.Mode) ReplOption {
+	return replOption(func(r *Repl) {
+		r.initialMode = mode
+	})
+}
+
+// WithVMOptions configures the VM that executes the input.
+func WithVMOptions(opts ...vm.VMOption) ReplOption {
+	return replOption(func(r *Repl) {
+		r.vmOpts = append(r.vmOpts, opts...)
+	})
+}
+
+// WithPrompt makes a Repl write a prompt that shows the current mode, such as "A> ", before reading each line.
+// By default no prompt is written, which is suitable for scripts.
+func WithPrompt() ReplOption {
+	return replOption(func(r *Repl) {
+		r.prompt = true
+	})
+}
+
+// Repl reads lines and executes the instructions in them on a VM that persists across lines.
+//
+// Each line is lexed in the current mode, and each instruction is executed as soon as it is read.
+// If an instruction fails, the rest of the line is discarded. After each line that contains instructions, the stack is written from the top.
+// A line that starts with '\' is a command, such as `\undo`. The `\help` command lists the available commands.
+type Repl struct {
+	in          io.Reader
+	out         *bufio.Writer
+	prompt      bool
+	initialMode lexer.Mode
+	vmOpts      []vm.VMOption
+
+	m       *vm.VM
+	mode    lexer.Mode
+	history []step // the instructions that have been executed, which are used to undo them
+	line    int    // the zero-origin number of the current line
+}
+
+// step is an instruction that has been executed.
+type step struct {
+	op   vm.Op
+	mode lexer.Mode // the mode in which op was read
+}
+
+const replHelp = `commands:
+  \undo [N]            undo the last N instructions (default: 1)
+  \mode A|S            switch the mode of the lexer
+  \stack               show the values on the stack from the top
+  \dump [yaml|json]    show the value on the top of the stack (default: yaml)
+  \reset               discard the stack and go back to the initial mode
+  \help                show this message
+  \quit                exit
+`
+
+// NewRepl returns a new Repl that reads lines from in and writes the results to out.
+func NewRepl(in io.Reader, out io.Writer, opts ...ReplOption) *Repl {
+	r := &Repl{in: in, out: bufio.NewWriter(out)}
+	for _, opt := range opts {
+		opt.apply(r)
+	}
+	r.reset()
+	return r
+}
+
+// Mode returns the mode in which the next line is lexed.
+func (r *Repl) Mode() lexer.Mode {
+	return r.mode
+}
+
+// Stack returns the values on the stack from the bottom to the top. They must not be modified.
+func (r *Repl) Stack() []*types.Value {
+	return r.m.Stack()
+}
+
+// Run reads and executes lines until the input ends or `\quit` is given.
+// Errors of instructions and commands are written to the output, and only errors of reading or writing are returned.
+func (r *Repl) Run() error {
+	scanner := bufio.NewScanner(r.in)
+	for ; ; r.line++ {
+		if r.prompt {
+			fmt.Fprintf(r.out, "%s> ", modeName(r.mode))
+		}
+		err := r.out.Flush()
+		if err != nil {
+			return err
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) > 0 && text[0] == commandPrefix {
+			args := strings.Fields(text[1:])
+			if len(args) > 0 && args[0] == "quit" {
+				return r.out.Flush()
+			}
+			err = r.command(args)
+		} else {
+			err = r.eval(scanner.Text())
+		}
+		if err != nil {
+			fmt.Fprintf(r.out, "error: %s\n", err.Error())
+		}
+	}
+}
+
+// eval executes the instructions in text, and writes the stack if there are any.
+func (r *Repl) eval(text string) error {
+	lex := lexer.NewLexer(strings.NewReader(text), lexer.WithInitialLexerMode(r.mode))
+	executed := false
+	for {
+		tok, err := lex.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		executed = true
+		tok.Line = r.line
+		err = r.m.Feed(tok.Op)
+		if err != nil {
+			r.writeStack()
+			return watson.NewDecodeError(tok, r.m.Stack(), err)
+		}
+		r.history = append(r.history, step{op: tok.Op, mode: r.mode})
+		r.mode = lexer.NextMode(r.mode, tok.Op)
+	}
+	if executed {
+		r.writeStack()
+	}
+	return nil
+}
+
+func (r *Repl) command(args []string) error {
+	if len(args) == 0 {
+		return errors.New("empty command (type \\help for the list of commands)")
+	}
+	switch args[0] {
+	case "undo":
+		n := 1
+		if len(args) > 1 {
+			var err error
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number: %q", args[1])
+			}
+		}
+		err := r.undo(n)
+		if err != nil {
+			return err
+		}
+		r.writeStack()
+	case "mode":
+		if len(args) != 2 {
+			return errors.New("usage: \\mode A|S")
+		}
+		switch args[1] {
+		case "A":
+			r.mode = lexer.A
+		case "S":
+			r.mode = lexer.S
+		default:
+			return fmt.Errorf("unknown mode: %q", args[1])
+		}
+	case "stack":
+		r.writeStack()
+	case "dump":
+		format := "yaml"
+		if len(args) > 1 {
+			format = args[1]
+		}
+		return r.dump(format)
+	case "reset":
+		r.reset()
+	case "help":
+		io.WriteString(r.out, replHelp)
+	default:
+		return fmt.Errorf("unknown command: \\%s (type \\help for the list of commands)", args[0])
+	}
+	return nil
+}
+
+func (r *Repl) reset() {
+	r.m = vm.NewVM(r.vmOpts...)
+	r.mode = r.initialMode
+	r.history = r.history[:0]
+}
+
+// undo undoes the last n instructions by executing the rest of them again on a new VM,
+// and moves back to the mode in which the earliest of them was read.
+func (r *Repl) undo(n int) error {
+	if len(r.history) == 0 {
+		return errors.New("nothing to undo")
+	}
+	if n > len(r.history) {
+		n = len(r.history)
+	}
+	rest := r.history[:len(r.history)-n]
+	m := vm.NewVM(r.vmOpts...)
+	for _, s := range rest {
+		// This never fails since the same instructions have been executed before.
+		err := m.Feed(s.op)
+		if err != nil {
+			return err
+		}
+	}
+	r.m = m
+	r.mode = r.history[len(rest)].mode
+	r.history = rest
+	return nil
+}
+
+func (r *Repl) dump(format string) error {
+	v, err := r.m.Top()
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "yaml":
+		return yaml.Decode(r.out, v)
+	case "json":
+		return json.Decode(r.out, v)
+	default:
+		return fmt.Errorf("unknown format: %q", format)
+	}
+}
+
+func (r *Repl) writeStack() {
+	stack := r.m.Stack()
+	if len(stack) == 0 {
+		fmt.Fprintf(r.out, "(empty)\n")
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		fmt.Fprintf(r.out, "[%d] %s\n", i, debugger.Describe(stack[i]))
+	}
+}
+
+func modeName(mode lexer.Mode) string {
+	if mode == lexer.S {
+		return "S"
+	}
+	return "A"
+}
@@ -0,0 +1,134 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/lexer"
+)
+
+func run(t *testing.T, input string, opts ...ReplOption) (*Repl, string) {
+	t.Helper()
+	out := &bytes.Buffer{}
+	r := NewRepl(strings.NewReader(input), out, opts...)
+	err := r.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, out.String()
+}
+
+func TestReplExecutesEachLine(t *testing.T) {
+	input := `Bu
+Bubu a
+  , ; ,
+`
+	want := `[0] Int(1)
+[0] Int(4)
+`
+	_, got := run(t, input)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReplKeepsTheModeAcrossLines(t *testing.T) {
+	// Snew flips the mode, so the second line is lexed in S.
+	r, got := run(t, "?\nSh\n", WithPrompt())
+	want := `A> [0] String("")
+S> [1] Int(1)
+[0] String("")
+S> `
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if r.Mode() != lexer.S {
+		t.Errorf("expected S but got %d", r.Mode())
+	}
+}
+
+func TestReplDiscardsTheRestOfTheLineOnError(t *testing.T) {
+	input := `BaBu
+\stack
+`
+	want := `[0] Int(0)
+error: Iadd: stack is empty at line 1, column 2 (stack: [Int(0)])
+[0] Int(0)
+`
+	_, got := run(t, input)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReplUndoesInstructionsAndModes(t *testing.T) {
+	input := `Bu?
+\undo
+\undo 5
+\undo
+`
+	want := `[1] String("")
+[0] Int(1)
+[0] Int(1)
+(empty)
+error: nothing to undo
+`
+	r, got := run(t, input)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if r.Mode() != lexer.A {
+		t.Errorf("expected A but got %d", r.Mode())
+	}
+}
+
+func TestReplSwitchesModes(t *testing.T) {
+	input := `\mode S
+Sh
+\mode X
+\reset
+Bu
+`
+	want := `[0] Int(1)
+error: unknown mode: "X"
+[0] Int(1)
+`
+	r, got := run(t, input, WithInitialMode(lexer.A))
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if len(r.Stack()) != 1 {
+		t.Errorf("expected 1 value but got %d", len(r.Stack()))
+	}
+}
+
+func TestReplDumpsTheTopValue(t *testing.T) {
+	// {"a": 1}
+	input := `~?Shahaaaaah-Shg
+\dump
+\dump json
+\dump xml
+\quit
+\dump
+`
+	want := `[0] Object{"a": Int(1)}
+a: 1
+{"a":1}
+error: unknown format: "xml"
+`
+	_, got := run(t, input)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReplReportsUnknownCommands(t *testing.T) {
+	_, got := run(t, "\\jump\n")
+	want := "error: unknown command: \\jump (type \\help for the list of commands)\n"
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
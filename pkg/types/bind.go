@@ -222,6 +222,16 @@ func (v *Value) BindByReflection(to reflect.Value) error {
 	return v.bindByReflection(to, newRootPath())
 }
 
+// Cast converts v into a value of type t by the same rules as Bind.
+func (v *Value) Cast(t reflect.Type) (reflect.Value, error) {
+	return v.cast(t, newRootPath())
+}
+
+// CastAt is the same as Cast except that errors report that v is located at the given path.
+func (v *Value) CastAt(t reflect.Type, at Path) (reflect.Value, error) {
+	return v.cast(t, at.path())
+}
+
 func (v *Value) bindByReflection(to reflect.Value, path path) error {
 	if isUnmarshaler(to.Type()) {
 		return v.bindToUnmarshalerByReflection(to, path)
@@ -556,15 +566,17 @@ func (v *Value) addToMap(obj reflect.Value, path path) error {
 			path: path,
 		}
 	}
-	for k, e := range v.Object {
-		key := reflect.ValueOf(k)
-		elem, err := e.cast(elemType, newFieldPath(path, k))
+	var err error
+	v.Object.Range(func(k string, e *Value) bool {
+		var elem reflect.Value
+		elem, err = e.cast(elemType, newFieldPath(path, k))
 		if err != nil {
-			return err
+			return false
 		}
-		obj.SetMapIndex(key, elem)
-	}
-	return nil
+		obj.SetMapIndex(reflect.ValueOf(k), elem)
+		return true
+	})
+	return err
 }
 
 func (v *Value) castToPtr(t reflect.Type, path path) (reflect.Value, error) {
@@ -605,23 +617,25 @@ func (v *Value) castToStruct(t reflect.Type, path path) (reflect.Value, error) {
 	}
 	pobj := reflect.New(t)
 	obj := pobj.Elem()
-	for k, v := range v.Object {
+	var err error
+	v.Object.Range(func(k string, v *Value) bool {
 		tag, ok := findField(k, obj)
 		if !ok {
-			continue
+			return true
 		}
 		if tag.ShouldAlwaysOmit() {
-			continue
+			return true
 		}
 		field := tag.FieldOf(obj)
-		err := v.BindByReflection(field.Addr())
-		if err != nil {
-			return reflect.Value{}, err
-		}
+		err = v.bindByReflection(field.Addr(), newFieldPath(path, k))
+		return err == nil
+	})
+	if err != nil {
+		return reflect.Value{}, err
 	}
 	for _, tag := range inlineFields(obj) {
 		field := tag.FieldOf(obj)
-		err := v.BindByReflection(field.Addr())
+		err := v.bindByReflection(field.Addr(), path)
 		if err != nil {
 			return reflect.Value{}, err
 		}
@@ -644,6 +644,32 @@ func TestBindReturnsErrorWhenTypeMismatchInArrayElem(t *testing.T) {
 	}
 }
 
+func TestBindReturnsErrorWhenTypeMismatchInStructField(t *testing.T) {
+	type Inner struct {
+		Value string `watson:"value"`
+	}
+	type Outer struct {
+		Inner []Inner `watson:"inner"`
+	}
+	var err error
+	var val = types.NewObjectValue(map[string]*types.Value{
+		"inner": types.NewArrayValue([]*types.Value{
+			types.NewObjectValue(map[string]*types.Value{
+				"value": types.NewIntValue(456),
+			}),
+		}),
+	})
+	var bindTo Outer
+	err = val.Bind(&bindTo)
+	if err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+	pat := regexp.MustCompile(`\(at <root>.inner\[0\].value\)`)
+	if !pat.MatchString(err.Error()) {
+		t.Errorf("expected \"%s\" to match /%s/, but it didn't", err.Error(), pat.String())
+	}
+}
+
 func TestBindByReflectionConvertsInt(t *testing.T) {
 	var err error
 	var got int
@@ -0,0 +1,56 @@
+package types
+
+import (
+	"reflect"
+	"sync"
+)
+
+// structCodec holds the metadata of the fields of a struct type, so that the tags of the type are parsed only once.
+type structCodec struct {
+	byKey   map[string]*tag // the first field that has each key, including fields that are always omitted
+	fields  []Field         // the fields that are converted into members of objects
+	inlines []*tag          // the fields that are marked as inline
+}
+
+// structCodecs caches a *structCodec for each struct type. It is safe for concurrent use.
+var structCodecs sync.Map
+
+// codecOf returns the structCodec of a struct type t.
+func codecOf(t reflect.Type) *structCodec {
+	if c, ok := structCodecs.Load(t); ok {
+		return c.(*structCodec)
+	}
+	// Two goroutines may build the codec of the same type at the same time, but only one of them is stored.
+	c, _ := structCodecs.LoadOrStore(t, newStructCodec(t))
+	return c.(*structCodec)
+}
+
+func newStructCodec(t reflect.Type) *structCodec {
+	size := t.NumField()
+	c := &structCodec{
+		byKey:   make(map[string]*tag, size),
+		fields:  make([]Field, 0, size),
+		inlines: make([]*tag, 0),
+	}
+	for i := 0; i < size; i++ {
+		f := t.Field(i)
+		tag := parseTag(&f)
+		key := tag.Key()
+		if _, ok := c.byKey[key]; !ok {
+			c.byKey[key] = tag
+		}
+		if tag.Inline() {
+			c.inlines = append(c.inlines, tag)
+		}
+		if tag.ShouldAlwaysOmit() {
+			continue
+		}
+		c.fields = append(c.fields, Field{
+			Index:     i,
+			Key:       key,
+			OmitEmpty: tag.OmitEmpty(),
+			Inline:    tag.Inline(),
+		})
+	}
+	return c
+}
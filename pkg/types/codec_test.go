@@ -0,0 +1,124 @@
+package types_test
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/types"
+)
+
+type codecTestInner struct {
+	Name string `watson:"name"`
+}
+
+type codecTestStruct struct {
+	ID       int            `watson:"id"`
+	Hidden   string         `watson:"-"`
+	Dup      string         `watson:"dup"`
+	Dup2     string         `watson:"dup"`
+	Optional string         `watson:"optional,omitempty"`
+	Inner    codecTestInner `watson:",inline"`
+	private  int
+}
+
+func TestFieldsReturnsFieldsThatAreConverted(t *testing.T) {
+	want := []types.Field{
+		{Index: 0, Key: "id"},
+		{Index: 2, Key: "dup"},
+		{Index: 3, Key: "dup"},
+		{Index: 4, Key: "optional", OmitEmpty: true},
+		{Index: 5, Key: "inner", Inline: true},
+	}
+	got := types.Fields(reflect.TypeOf(codecTestStruct{}))
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLookupFieldReturnsTheFirstFieldThatHasTheKey(t *testing.T) {
+	typ := reflect.TypeOf(codecTestStruct{})
+	f, ok := types.LookupField(typ, "dup")
+	if !ok {
+		t.Fatal("field not found")
+	}
+	if f.Name != "Dup" {
+		t.Errorf("expected Dup but got %s", f.Name)
+	}
+	for _, key := range []string{"hidden", "private", "unknown"} {
+		if _, ok := types.LookupField(typ, key); ok {
+			t.Errorf("%s: expected not to be found", key)
+		}
+	}
+	if !types.HasInlineFields(typ) {
+		t.Error("expected to have inline fields")
+	}
+	if types.HasInlineFields(reflect.TypeOf(codecTestInner{})) {
+		t.Error("expected not to have inline fields")
+	}
+}
+
+func TestBindAndToValueAreSafeForConcurrentUse(t *testing.T) {
+	type record struct {
+		ID    int    `watson:"id"`
+		Name  string `watson:"name,omitempty"`
+		Inner codecTestInner
+	}
+	want := record{ID: 1, Name: "a", Inner: codecTestInner{Name: "b"}}
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := types.ToValue(&want)
+			if err != nil {
+				errs <- err
+				return
+			}
+			var got record
+			err = v.Bind(&got)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if got != want {
+				errs <- fmt.Errorf("expected %v but got %v", want, got)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func BenchmarkBindStruct(b *testing.B) {
+	v, err := types.ToValue(codecTestStruct{ID: 1, Dup: "a", Optional: "b", Inner: codecTestInner{Name: "c"}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var got codecTestStruct
+		err := v.Bind(&got)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkToValueStruct(b *testing.B) {
+	s := codecTestStruct{ID: 1, Dup: "a", Optional: "b", Inner: codecTestInner{Name: "c"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := types.ToValue(s)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Map is a set of key-value pairs that remembers the order in which its keys were added.
+//
+// Setting a value to a key that already exists replaces its value but keeps its original position.
+// The zero value for Map is an empty Map ready to use.
+type Map struct {
+	keys   []string
+	values map[string]*Value
+}
+
+// NewMap creates a new empty Map.
+func NewMap() *Map {
+	return &Map{values: map[string]*Value{}}
+}
+
+// NewMapFromGo creates a new Map that contains the same key-value pairs as m.
+// Since Go's maps are unordered, keys are added in lexicographical order.
+func NewMapFromGo(m map[string]*Value) *Map {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	obj := &Map{keys: keys, values: make(map[string]*Value, len(m))}
+	for k, v := range m {
+		obj.values[k] = v
+	}
+	return obj
+}
+
+// Len returns the number of keys in m.
+func (m *Map) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.keys)
+}
+
+// Get returns the value associated with key.
+// The second return value reports whether key exists in m.
+func (m *Map) Get(key string) (*Value, bool) {
+	if m == nil {
+		return nil, false
+	}
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// Set associates val with key.
+// If key already exists, its value is replaced and its position is left unchanged; otherwise key is added to the end of m.
+func (m *Map) Set(key string, val *Value) {
+	if m.values == nil {
+		// The zero Map is an empty Map that is ready to use.
+		m.values = map[string]*Value{}
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = val
+}
+
+// Keys returns the keys of m in the order in which they were added.
+func (m *Map) Keys() []string {
+	keys := make([]string, m.Len())
+	if m != nil {
+		copy(keys, m.keys)
+	}
+	return keys
+}
+
+// Range calls f for each key-value pair in the order in which keys were added.
+// If f returns false, Range stops the iteration.
+func (m *Map) Range(f func(key string, val *Value) bool) {
+	if m == nil {
+		return
+	}
+	for _, k := range m.keys {
+		if !f(k, m.values[k]) {
+			return
+		}
+	}
+}
+
+// Clone returns a shallow copy of m.
+func (m *Map) Clone() *Map {
+	clone := &Map{
+		keys:   make([]string, m.Len()),
+		values: make(map[string]*Value, m.Len()),
+	}
+	m.Range(func(k string, v *Value) bool {
+		clone.values[k] = v
+		return true
+	})
+	if m != nil {
+		copy(clone.keys, m.keys)
+	}
+	return clone
+}
+
+// Equal reports whether m and other have the same set of keys and all of their values are equal.
+// Note that the order of keys does not matter.
+func (m *Map) Equal(other *Map) bool {
+	if m.Len() != other.Len() {
+		return false
+	}
+	eq := true
+	m.Range(func(k string, v *Value) bool {
+		w, ok := other.Get(k)
+		eq = ok && v.equal(w)
+		return eq
+	})
+	return eq
+}
+
+func (m *Map) GoString() string {
+	members := make([]string, 0, m.Len())
+	m.Range(func(k string, v *Value) bool {
+		members = append(members, fmt.Sprintf("%#v: %#v", k, v))
+		return true
+	})
+	return fmt.Sprintf("{%s}", strings.Join(members, ", "))
+}
+
+var _ fmt.GoStringer = &Map{}
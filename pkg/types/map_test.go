@@ -0,0 +1,130 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMapRemembersTheOrderOfKeys(t *testing.T) {
+	m := NewMap()
+	m.Set("shrimp", NewIntValue(1))
+	m.Set("ebi", NewIntValue(2))
+	m.Set("tako", NewIntValue(3))
+	want := []string{"shrimp", "ebi", "tako"}
+	if diff := cmp.Diff(want, m.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestZeroMapIsReadyToUse(t *testing.T) {
+	for _, m := range []*Map{{}, new(Map)} {
+		m.Set("shrimp", NewIntValue(1))
+		m.Set("ebi", NewIntValue(2))
+		want := []string{"shrimp", "ebi"}
+		if diff := cmp.Diff(want, m.Keys()); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+		got, ok := m.Get("ebi")
+		if !ok || got.Int != 2 {
+			t.Errorf("expected 2 but got %#v", got)
+		}
+	}
+}
+
+func TestMapSetKeepsThePositionOfExistingKey(t *testing.T) {
+	m := NewMap()
+	m.Set("shrimp", NewIntValue(1))
+	m.Set("ebi", NewIntValue(2))
+	m.Set("shrimp", NewIntValue(3))
+	want := []string{"shrimp", "ebi"}
+	if diff := cmp.Diff(want, m.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	got, ok := m.Get("shrimp")
+	if !ok {
+		t.Fatal("shrimp not found")
+	}
+	if diff := cmp.Diff(NewIntValue(3), got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if m.Len() != 2 {
+		t.Errorf("expected 2 but got %d", m.Len())
+	}
+}
+
+func TestMapGetReturnsFalseIfKeyDoesNotExist(t *testing.T) {
+	m := NewMap()
+	m.Set("shrimp", NewIntValue(1))
+	_, ok := m.Get("ebi")
+	if ok {
+		t.Errorf("expected ebi not to be found")
+	}
+}
+
+func TestMapRangeStopsWhenFReturnsFalse(t *testing.T) {
+	m := NewMap()
+	m.Set("shrimp", NewIntValue(1))
+	m.Set("ebi", NewIntValue(2))
+	m.Set("tako", NewIntValue(3))
+	got := []string{}
+	m.Range(func(k string, v *Value) bool {
+		got = append(got, k)
+		return k != "ebi"
+	})
+	want := []string{"shrimp", "ebi"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewMapFromGoSortsKeys(t *testing.T) {
+	m := NewMapFromGo(map[string]*Value{
+		"shrimp": NewIntValue(1),
+		"ebi":    NewIntValue(2),
+		"tako":   NewIntValue(3),
+	})
+	want := []string{"ebi", "shrimp", "tako"}
+	if diff := cmp.Diff(want, m.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMapCloneDoesNotShareKeys(t *testing.T) {
+	m := NewMap()
+	m.Set("shrimp", NewIntValue(1))
+	clone := m.Clone()
+	clone.Set("ebi", NewIntValue(2))
+	if diff := cmp.Diff([]string{"shrimp"}, m.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"shrimp", "ebi"}, clone.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMapEqualIgnoresTheOrderOfKeys(t *testing.T) {
+	a := NewMap()
+	a.Set("shrimp", NewIntValue(1))
+	a.Set("ebi", NewIntValue(2))
+	b := NewMap()
+	b.Set("ebi", NewIntValue(2))
+	b.Set("shrimp", NewIntValue(1))
+	if !a.Equal(b) {
+		t.Errorf("expected %#v to be equal to %#v", a, b)
+	}
+	b.Set("shrimp", NewIntValue(3))
+	if a.Equal(b) {
+		t.Errorf("expected %#v not to be equal to %#v", a, b)
+	}
+}
+
+func TestDeepCopyPreservesTheOrderOfKeys(t *testing.T) {
+	m := NewMap()
+	m.Set("shrimp", NewIntValue(1))
+	m.Set("ebi", NewIntValue(2))
+	clone := NewOrderedObjectValue(m).DeepCopy()
+	if diff := cmp.Diff([]string{"shrimp", "ebi"}, clone.Object.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -4,6 +4,38 @@ import (
 	"fmt"
 )
 
+// Path is the location of a value in a Value that is being bound, which is reported in errors such as TypeMismatch.
+// The zero value is the path of the Value itself.
+type Path struct {
+	p path
+}
+
+// RootPath returns the path of the Value itself.
+func RootPath() Path {
+	return Path{p: newRootPath()}
+}
+
+// Field returns the path of the member named key in the object at p.
+func (p Path) Field(key string) Path {
+	return Path{p: newFieldPath(p.path(), key)}
+}
+
+// Index returns the path of the i-th element of the array at p.
+func (p Path) Index(i int) Path {
+	return Path{p: newIndexPath(p.path(), i)}
+}
+
+func (p Path) String() string {
+	return p.path().string()
+}
+
+func (p Path) path() path {
+	if p.p == nil {
+		return newRootPath()
+	}
+	return p.p
+}
+
 type path interface {
 	string() string
 }
@@ -30,3 +30,16 @@ func TestIndexPath(t *testing.T) {
 		t.Errorf("expected %#v but got %#v", expected, actual)
 	}
 }
+
+func TestPath(t *testing.T) {
+	expected := "<root>.TheField[1]"
+	actual := RootPath().Field("TheField").Index(1).String()
+	if expected != actual {
+		t.Errorf("expected %#v but got %#v", expected, actual)
+	}
+	expected = "<root>"
+	actual = Path{}.String()
+	if expected != actual {
+		t.Errorf("expected %#v but got %#v", expected, actual)
+	}
+}
@@ -24,30 +24,45 @@ type tag struct {
 }
 
 func findField(key string, obj reflect.Value) (*tag, bool) {
-	t := obj.Type()
-	size := obj.NumField()
-	for i := 0; i < size; i++ {
-		f := t.Field(i)
-		tag := parseTag(&f)
-		if tag.Key() == key {
-			return tag, true
-		}
-	}
-	return nil, false
+	return findFieldOfType(key, obj.Type())
+}
+
+func findFieldOfType(key string, t reflect.Type) (*tag, bool) {
+	tag, ok := codecOf(t).byKey[key]
+	return tag, ok
 }
 
 func inlineFields(obj reflect.Value) []*tag {
-	t := obj.Type()
-	tags := make([]*tag, 0)
-	size := obj.NumField()
-	for i := 0; i < size; i++ {
-		f := t.Field(i)
-		tag := parseTag(&f)
-		if tag.Inline() {
-			tags = append(tags, tag)
-		}
+	return codecOf(obj.Type()).inlines
+}
+
+// LookupField returns the field of a struct type t to which Bind assigns a member named key.
+// It returns false if there is no such field or the field is always omitted.
+func LookupField(t reflect.Type, key string) (reflect.StructField, bool) {
+	tag, ok := findFieldOfType(key, t)
+	if !ok || tag.ShouldAlwaysOmit() {
+		return reflect.StructField{}, false
 	}
-	return tags
+	return *tag.f, true
+}
+
+// Field describes how a field of a struct is converted into a member of an object.
+type Field struct {
+	Index     int    // the index of the field in the struct
+	Key       string // the key of the member
+	OmitEmpty bool   // whether the field is omitted if it is a zero value
+	Inline    bool   // whether the members of the field are added to the object instead of the field itself
+}
+
+// Fields returns the fields of a struct type t that ToValue converts into members of an object, in the order of declaration.
+// The result is shared by all callers and must not be modified.
+func Fields(t reflect.Type) []Field {
+	return codecOf(t).fields
+}
+
+// HasInlineFields reports whether a struct type t has fields that are marked as inline.
+func HasInlineFields(t reflect.Type) bool {
+	return len(codecOf(t).inlines) > 0
 }
 
 func parseTag(f *reflect.StructField) *tag {
@@ -25,9 +25,10 @@ func (val *Value) ToGoObject() interface{} {
 		return string(val.String)
 	case Object:
 		obj := map[string]interface{}{}
-		for k, v := range val.Object {
+		val.Object.Range(func(k string, v *Value) bool {
 			obj[k] = v.ToGoObject()
-		}
+			return true
+		})
 		return obj
 	case Array:
 		arr := make([]interface{}, 0, len(val.Array))
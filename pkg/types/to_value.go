@@ -3,6 +3,7 @@ package types
 import (
 	"fmt"
 	"reflect"
+	"sort"
 )
 
 // ToValue converts an arbitrary value into *Value.
@@ -104,7 +105,8 @@ func stringToValueByReflection(v reflect.Value) (*Value, error) {
 
 func mapToValueByReflection(v reflect.Value) (*Value, error) {
 	var err error
-	obj := map[string]*Value{}
+	keys := make([]string, 0, v.Len())
+	elems := make(map[string]reflect.Value, v.Len())
 	iter := v.MapRange()
 	for iter.Next() {
 		key := iter.Key()
@@ -112,7 +114,14 @@ func mapToValueByReflection(v reflect.Value) (*Value, error) {
 		if !ok {
 			return nil, fmt.Errorf("can't convert %s to string", key.Type().String())
 		}
-		elem := iter.Value()
+		keys = append(keys, k)
+		elems[k] = iter.Value()
+	}
+	// Keys are sorted so that the same map is always converted into the same Value.
+	sort.Strings(keys)
+	obj := NewMap()
+	for _, k := range keys {
+		elem := elems[k]
 		var elemVal *Value
 		if elem.CanInterface() {
 			elemVal, err = ToValue(elem.Interface())
@@ -122,9 +131,9 @@ func mapToValueByReflection(v reflect.Value) (*Value, error) {
 		if err != nil {
 			return nil, err
 		}
-		obj[k] = elemVal
+		obj.Set(k, elemVal)
 	}
-	return NewObjectValue(obj), nil
+	return NewOrderedObjectValue(obj), nil
 }
 
 func sliceOrArrayToValueByReflection(v reflect.Value) (*Value, error) {
@@ -157,29 +166,22 @@ func ptrToValueByReflection(v reflect.Value) (*Value, error) {
 }
 
 func structToValueByReflection(v reflect.Value) (*Value, error) {
-	obj := map[string]*Value{}
+	obj := NewMap()
 	err := addFields(obj, v)
 	if err != nil {
 		return nil, err
 	}
-	return NewObjectValue(obj), nil
+	return NewOrderedObjectValue(obj), nil
 }
 
-func addFields(obj map[string]*Value, v reflect.Value) error {
-	size := v.NumField()
-	t := v.Type()
-	for i := 0; i < size; i++ {
-		field := t.Field(i)
-		tag := parseTag(&field)
-		if tag.ShouldAlwaysOmit() {
-			continue
-		}
-		name := tag.Key()
-		elem := v.Field(i)
-		if tag.OmitEmpty() && elem.IsZero() {
+func addFields(obj *Map, v reflect.Value) error {
+	for _, field := range Fields(v.Type()) {
+		name := field.Key
+		elem := v.Field(field.Index)
+		if field.OmitEmpty && elem.IsZero() {
 			continue
 		}
-		if tag.Inline() {
+		if field.Inline {
 			err := addFields(obj, elem)
 			if err != nil {
 				return err
@@ -189,13 +191,13 @@ func addFields(obj map[string]*Value, v reflect.Value) error {
 			if err != nil {
 				return err
 			}
-			obj[name] = elemVal
+			obj.Set(name, elemVal)
 		} else {
 			elemVal, err := ToValueByReflection(elem)
 			if err != nil {
 				return err
 			}
-			obj[name] = elemVal
+			obj.Set(name, elemVal)
 		}
 	}
 	return nil
@@ -387,6 +387,31 @@ func TestToValueConvertsUntaggedStruct(t *testing.T) {
 	}
 }
 
+func TestToValueKeepsTheOrderOfFields(t *testing.T) {
+	got, err := types.ToValue(&untagged{
+		Name:     "hoge",
+		LongName: "longhoge",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"name", "longname"}
+	if diff := cmp.Diff(want, got.Object.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestToValueSortsKeysOfMap(t *testing.T) {
+	got, err := types.ToValue(map[string]int{"shrimp": 1, "ebi": 2, "tako": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ebi", "shrimp", "tako"}
+	if diff := cmp.Diff(want, got.Object.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestToValueConvertsNestedStruct(t *testing.T) {
 	want := types.NewObjectValue(map[string]*types.Value{
 		"value": types.NewObjectValue(map[string]*types.Value{
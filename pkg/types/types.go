@@ -13,7 +13,7 @@ type Value struct {
 	Uint   uint64
 	Float  float64
 	String []byte
-	Object map[string]*Value
+	Object *Map
 	Array  []*Value
 	Bool   bool
 }
@@ -39,7 +39,14 @@ func NewStringValue(val []byte) *Value {
 }
 
 // NewObjectValue creates a new Value that contains an object.
+// Since Go's maps are unordered, the keys of the object are ordered lexicographically.
+// Use NewOrderedObjectValue to specify the order of keys.
 func NewObjectValue(val map[string]*Value) *Value {
+	return &Value{Kind: Object, Object: NewMapFromGo(val)}
+}
+
+// NewOrderedObjectValue creates a new Value that contains an object whose keys are ordered as in val.
+func NewOrderedObjectValue(val *Map) *Value {
 	return &Value{Kind: Object, Object: val}
 }
 
@@ -77,10 +84,11 @@ func (v *Value) DeepCopy() *Value {
 		clone.String = make([]byte, len(v.String))
 		copy(clone.String, v.String)
 	case Object:
-		clone.Object = map[string]*Value{}
-		for k, v := range v.Object {
-			clone.Object[k] = v.DeepCopy()
-		}
+		clone.Object = NewMap()
+		v.Object.Range(func(k string, v *Value) bool {
+			clone.Object.Set(k, v.DeepCopy())
+			return true
+		})
 	case Array:
 		clone.Array = make([]*Value, 0, len(v.Array))
 		for _, v := range v.Array {
@@ -96,6 +104,74 @@ func (v *Value) DeepCopy() *Value {
 	return clone
 }
 
+// maxSummaryLength is the maximum length of strings that are shown in their summaries.
+const maxSummaryLength = 16
+
+// Summary returns a short description of v, such as `Int(1)` or `Object(3 keys)`, which is used to report errors and traces.
+func (v *Value) Summary() string {
+	switch v.Kind {
+	case Int:
+		return fmt.Sprintf("Int(%d)", v.Int)
+	case Uint:
+		return fmt.Sprintf("Uint(%d)", v.Uint)
+	case Float:
+		return fmt.Sprintf("Float(%g)", v.Float)
+	case String:
+		s := v.String
+		if len(s) > maxSummaryLength {
+			return fmt.Sprintf("String(%q...)", s[:maxSummaryLength])
+		}
+		return fmt.Sprintf("String(%q)", s)
+	case Object:
+		return fmt.Sprintf("Object(%d keys)", v.Object.Len())
+	case Array:
+		return fmt.Sprintf("Array(%d elements)", len(v.Array))
+	case Bool:
+		return fmt.Sprintf("Bool(%t)", v.Bool)
+	default:
+		return fmt.Sprintf("%#v", v.Kind)
+	}
+}
+
+// equal reports whether v and w represent the same value.
+// Objects are considered to be equal regardless of the order of their keys.
+func (v *Value) equal(w *Value) bool {
+	if v == nil || w == nil {
+		return v == w
+	}
+	if v.Kind != w.Kind {
+		return false
+	}
+	switch v.Kind {
+	case Int:
+		return v.Int == w.Int
+	case Uint:
+		return v.Uint == w.Uint
+	case Float:
+		return v.Float == w.Float
+	case String:
+		return string(v.String) == string(w.String)
+	case Object:
+		return v.Object.Equal(w.Object)
+	case Array:
+		if len(v.Array) != len(w.Array) {
+			return false
+		}
+		for i := range v.Array {
+			if !v.Array[i].equal(w.Array[i]) {
+				return false
+			}
+		}
+		return true
+	case Bool:
+		return v.Bool == w.Bool
+	case Nil:
+		return true
+	default:
+		panic(fmt.Errorf("unknown kind: %d", v.Kind))
+	}
+}
+
 func (v *Value) GoString() string {
 	return fmt.Sprintf("{Kind: %#v, Value: %s}", v.Kind, v.goStringValue())
 }
@@ -73,17 +73,18 @@ func TestDeepCopyWithObject(t *testing.T) {
 		t.Errorf("mismatch (-want +got):\n%s", diff)
 	}
 
-	clone.Object["hello"].String[0] = 0x61 // 'a'
+	hello, _ := clone.Object.Get("hello")
+	hello.String[0] = 0x61 // 'a'
 	if diff := cmp.Diff(orig, clone); diff == "" {
 		t.Errorf("clone shares the same reference with its origin")
 	}
 
-	clone.Object["hoge"] = NewStringValue([]byte("fuga"))
+	clone.Object.Set("hoge", NewStringValue([]byte("fuga")))
 	if diff := cmp.Diff(orig, clone); diff == "" {
 		t.Errorf("clone shares the same reference with its origin")
 	}
 
-	clone.Object = map[string]*Value{}
+	clone.Object = NewMap()
 	if diff := cmp.Diff(orig, clone); diff == "" {
 		t.Errorf("DeepCopy returned receiver itself")
 	}
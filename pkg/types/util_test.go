@@ -89,7 +89,7 @@ func (u *customUnmarshaler) UnmarshalWatson(v *types.Value) error {
 	if v.Kind != types.Object {
 		return fmt.Errorf("value is not an Object")
 	}
-	k, ok := v.Object["customKey"]
+	k, ok := v.Object.Get("customKey")
 	if !ok {
 		return fmt.Errorf("value does not have customKey")
 	}
@@ -108,7 +108,7 @@ func (p *primitiveUnmarshaler) UnmarshalWatson(v *types.Value) error {
 	if v.Kind != types.Object {
 		return fmt.Errorf("value is not an Object")
 	}
-	k, ok := v.Object["customKey"]
+	k, ok := v.Object.Get("customKey")
 	if !ok {
 		return fmt.Errorf("value does not have customKey")
 	}
@@ -3,7 +3,6 @@ package vm
 import (
 	"errors"
 	"fmt"
-	"math"
 
 	"github.com/genkami/watson/pkg/types"
 )
@@ -12,46 +11,165 @@ var (
 	ErrStackEmpty               = errors.New("stack is empty")
 	ErrMaximumStackSizeExceeded = errors.New("maximum stack size exceeded")
 	ErrTypeMismatch             = errors.New("type mismatch")
+
+	ErrMaximumInstructionsExceeded  = errors.New("maximum number of instructions exceeded")
+	ErrMaximumAllocationExceeded    = errors.New("maximum allocation exceeded")
+	ErrMaximumStringLengthExceeded  = errors.New("maximum string length exceeded")
+	ErrMaximumContainerSizeExceeded = errors.New("maximum container size exceeded")
+	ErrMaximumDepthExceeded         = errors.New("maximum depth exceeded")
 )
 
+// valueSize is the number of bytes that each value counts as. See WithMaxAllocation.
+const valueSize = 8
+
+const maxInt = int(^uint(0) >> 1)
+
+// slot holds metadata of a value on the stack so that VM can check its limits without traversing the value.
+type slot struct {
+	size  int // the number of bytes that the value occupies; see WithMaxAllocation
+	depth int // the nesting depth of the value; see WithMaxDepth
+
+	// sharesParts reports whether some parts of the value may be referenced from elsewhere.
+	sharesParts bool
+}
+
+// measure computes the metadata of v by traversing it.
+func measure(v *types.Value) slot {
+	s := slot{size: valueSize}
+	switch v.Kind {
+	case types.String:
+		s.size = addSize(s.size, len(v.String))
+	case types.Object:
+		s.depth = 1
+		v.Object.Range(func(k string, elem *types.Value) bool {
+			t := measure(elem)
+			s.size = addSize(s.size, addSize(len(k), t.size))
+			if s.depth < t.depth+1 {
+				s.depth = t.depth + 1
+			}
+			return true
+		})
+	case types.Array:
+		s.depth = 1
+		for _, elem := range v.Array {
+			t := measure(elem)
+			s.size = addSize(s.size, t.size)
+			if s.depth < t.depth+1 {
+				s.depth = t.depth + 1
+			}
+		}
+	}
+	return s
+}
+
+// addSize adds two sizes without overflowing.
+func addSize(a, b int) int {
+	if a > maxInt-b {
+		return maxInt
+	}
+	return a + b
+}
+
+// TypeMismatchError is an error that indicates that an operand of an instruction is not of the expected kind.
+// It can be compared to ErrTypeMismatch by using errors.Is.
+type TypeMismatchError struct {
+	Expected types.Kind // the kind that the instruction expected
+	Actual   types.Kind // the kind of the value that was actually on the stack
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("%s: expected %#v but got %#v", ErrTypeMismatch.Error(), e.Expected, e.Actual)
+}
+
+func (e *TypeMismatchError) Is(target error) bool {
+	return target == ErrTypeMismatch
+}
+
 // Top returns a value in the top of the stack.
 // This returns ErrStackEmpty if the stack is empty.
+//
+// Values on the stack may share their parts with each other until they are modified.
+// Top makes sure that no part of the returned value is referenced from elsewhere, so that modifying one part does not affect the others.
 func (vm *VM) Top() (*types.Value, error) {
 	if vm.sp < 0 {
 		return nil, ErrStackEmpty
 	}
-	return vm.stack[vm.sp], nil
+	v, s := vm.stack[vm.sp], &vm.slots[vm.sp]
+	if s.sharesParts || vm.isShared(v) {
+		shared := v
+		v = v.DeepCopy()
+		vm.stack[vm.sp] = v
+		vm.release(shared)
+		s.sharesParts = false
+	}
+	return v, nil
+}
+
+// Stack returns all values on the stack, from the bottom to the top.
+// Unlike Top, values returned by Stack may share their parts; they must not be modified.
+func (vm *VM) Stack() []*types.Value {
+	stack := make([]*types.Value, vm.sp+1)
+	copy(stack, vm.stack)
+	return stack
+}
+
+// Push pushes v onto the stack as if it were built by executing instructions, so that execution can be resumed with values built elsewhere.
+// v must not be modified after it is pushed.
+func (vm *VM) Push(v *types.Value) error {
+	return vm.push(v)
 }
 
 // Feed takes a op and executes corresponding operation.
 // This can fail in various ways; e.g. type mismatch, stack overflow, etc.
+// If it fails, the stack is left as it was before the operation.
+//
+// A type mismatch is reported as a *TypeMismatchError, so use errors.Is(err, ErrTypeMismatch) rather than comparing err with ErrTypeMismatch.
 func (vm *VM) Feed(op Op) error {
+	if vm.maxInstructions > 0 && vm.executed >= vm.maxInstructions {
+		return ErrMaximumInstructionsExceeded
+	}
+	var err error
+	if vm.observer != nil {
+		err = vm.executeObserved(op)
+	} else {
+		err = vm.execute(op)
+	}
+	if err != nil {
+		return err
+	}
+	vm.executed++
+	return nil
+}
+
+// execute executes op, and restores the stack if it fails.
+func (vm *VM) execute(op Op) error {
+	// No operation pops more than three values, so it is sufficient to save them to restore the stack.
+	sp, allocated := vm.sp, vm.allocated
+	base := sp - 2
+	if base < 0 {
+		base = 0
+	}
+	var saved [3]*types.Value
+	var savedSlots [3]slot
+	n := copy(saved[:], vm.stack[base:sp+1])
+	copy(savedSlots[:], vm.slots[base:sp+1])
+	err := vm.feed(op)
+	if err != nil {
+		for i := sp + 1; i <= vm.sp; i++ {
+			vm.stack[i] = nil
+		}
+		copy(vm.stack[base:], saved[:n])
+		copy(vm.slots[base:], savedSlots[:n])
+		vm.sp = sp
+		vm.allocated = allocated
+	}
+	return err
+}
+
+func (vm *VM) feed(op Op) error {
 	switch op {
-	case Inew:
-		return vm.feedInew()
-	case Iinc:
-		return vm.feedIinc()
-	case Ishl:
-		return vm.feedIshl()
-	case Iadd:
-		return vm.feedIadd()
-	case Ineg:
-		return vm.feedIneg()
-	case Isht:
-		return vm.feedIsht()
-	case Itof:
-		return vm.feedItof()
-	case Itou:
-		return vm.feedItou()
-	case Finf:
-		return vm.feedFinf()
-	case Fnan:
-		return vm.feedFnan()
-	case Fneg:
-		return vm.feedFneg()
-	case Snew:
-		return vm.feedSnew()
 	case Sadd:
+		// Sadd is not executed as other scalar instructions since strings are shared and limited in their length.
 		return vm.feedSadd()
 	case Onew:
 		return vm.feedOnew()
@@ -61,21 +179,17 @@ func (vm *VM) Feed(op Op) error {
 		return vm.feedAnew()
 	case Aadd:
 		return vm.feedAadd()
-	case Bnew:
-		return vm.feedBnew()
-	case Bneg:
-		return vm.feedBneg()
-	case Nnew:
-		return vm.feedNnew()
 	case Gdup:
 		return vm.feedGdup()
 	case Gpop:
 		return vm.feedGpop()
 	case Gswp:
 		return vm.feedGswp()
-	default:
-		panic(fmt.Errorf("invalid opcode: %d", op))
 	}
+	if s := LookupScalarOp(op); s != nil {
+		return vm.feedScalar(s)
+	}
+	panic(fmt.Errorf("invalid opcode: %d", op))
 }
 
 // FeedMulti takes a series of Ops and executes them sequentially.
@@ -89,208 +203,293 @@ func (vm *VM) FeedMulti(ops []Op) error {
 	return nil
 }
 
-func (vm *VM) feedInew() error {
-	return vm.pushInt(0)
-}
-
-func (vm *VM) feedIinc() error {
-	v, err := vm.popInt()
+func (vm *VM) feedSadd() error {
+	s := scalarOps[Sadd]
+	err := s.Check(vm.sp+1, vm.kindAt)
 	if err != nil {
 		return err
 	}
-	return vm.pushInt(v + 1)
-}
-
-func (vm *VM) feedIshl() error {
-	v, err := vm.popInt()
-	if err != nil {
-		return err
+	n, _ := vm.pop()
+	sv, ss, _ := vm.popSlot()
+	if vm.maxStringLength > 0 && len(sv.String) >= vm.maxStringLength {
+		return ErrMaximumStringLengthExceeded
 	}
-	return vm.pushInt(v << 1)
-}
-
-func (vm *VM) feedIadd() error {
-	b, err := vm.popInt()
+	// Appending a byte does not copy the whole string in most cases, so only the new byte is counted.
+	err = vm.allocate(valueSize + 1)
 	if err != nil {
 		return err
 	}
-	a, err := vm.popInt()
+	ss.size = addSize(ss.size, 1)
+	x := *sv
+	if vm.isShared(sv) {
+		vm.release(sv)
+		x.String = append(make([]byte, 0, len(x.String)+1), x.String...)
+	}
+	s.Apply(&x, n)
+	err = vm.pushSlot(&x, ss)
 	if err != nil {
 		return err
 	}
-	return vm.pushInt(a + b)
+	vm.release(n)
+	return nil
 }
 
-func (vm *VM) feedIneg() error {
-	v, err := vm.popInt()
-	if err != nil {
-		return err
-	}
-	return vm.pushInt(-v)
+func (vm *VM) feedOnew() error {
+	return vm.pushObject(types.NewMap())
 }
 
-func (vm *VM) feedIsht() error {
-	b, err := vm.popInt()
+func (vm *VM) feedOadd() error {
+	v, vs, err := vm.popSlot()
 	if err != nil {
 		return err
 	}
-	a, err := vm.popInt()
+	kv, err := vm.pop()
 	if err != nil {
 		return err
 	}
-	if b >= 0 {
-		return vm.pushInt(a << b)
-	} else {
-		return vm.pushInt(a >> -b)
+	if kv.Kind != types.String {
+		return &TypeMismatchError{Expected: types.String, Actual: kv.Kind}
 	}
-}
-
-func (vm *VM) feedItof() error {
-	n, err := vm.popInt()
+	ov, os, err := vm.popSlot()
 	if err != nil {
 		return err
 	}
-	return vm.pushFloat(math.Float64frombits(uint64(n)))
-}
-
-func (vm *VM) feedItou() error {
-	n, err := vm.popInt()
-	if err != nil {
-		return err
+	if ov.Kind != types.Object {
+		return &TypeMismatchError{Expected: types.Object, Actual: ov.Kind}
 	}
-	return vm.pushUint(uint64(n))
-}
-
-func (vm *VM) feedFinf() error {
-	return vm.pushFloat(math.Inf(1))
-}
-
-func (vm *VM) feedFnan() error {
-	return vm.pushFloat(math.NaN())
-}
-
-func (vm *VM) feedFneg() error {
-	x, err := vm.popFloat()
+	o := ov.Object
+	key := string(kv.String)
+	_, exists := o.Get(key)
+	if !exists && vm.maxContainerLen > 0 && o.Len() >= vm.maxContainerLen {
+		return ErrMaximumContainerSizeExceeded
+	}
+	err = vm.checkDepth(vs.depth + 1)
 	if err != nil {
 		return err
 	}
-	return vm.pushFloat(-x)
-}
-
-func (vm *VM) feedSnew() error {
-	return vm.pushString([]byte{})
-}
-
-func (vm *VM) feedSadd() error {
-	n, err := vm.popInt()
+	err = vm.allocate(addSize(vs.size, len(key)))
 	if err != nil {
 		return err
 	}
-	s, err := vm.popString()
+	if !exists {
+		os.size = addSize(os.size, len(key))
+	}
+	// The size of an overwritten value is still counted; this only makes the estimate larger.
+	os.size = addSize(os.size, vs.size)
+	if os.depth < vs.depth+1 {
+		os.depth = vs.depth + 1
+	}
+	if vm.isShared(ov) {
+		vm.release(ov)
+		vm.markSharing(ov)
+		o = o.Clone()
+		os.sharesParts = true
+	}
+	if vs.sharesParts || vm.isShared(v) {
+		os.sharesParts = true
+	}
+	o.Set(key, v)
+	err = vm.pushSlot(types.NewOrderedObjectValue(o), os)
 	if err != nil {
 		return err
 	}
-	t := append(s, byte(n))
-	return vm.pushString(t)
+	vm.release(kv)
+	vm.moved(v)
+	return nil
 }
 
-func (vm *VM) feedOnew() error {
-	return vm.pushObject(map[string]*types.Value{})
+func (vm *VM) feedAnew() error {
+	return vm.pushArray([]*types.Value{})
 }
 
-func (vm *VM) feedOadd() error {
-	v, err := vm.pop()
+func (vm *VM) feedAadd() error {
+	x, xs, err := vm.popSlot()
 	if err != nil {
 		return err
 	}
-	k, err := vm.popString()
+	av, as, err := vm.popSlot()
 	if err != nil {
 		return err
 	}
-	o, err := vm.popObject()
+	if av.Kind != types.Array {
+		return &TypeMismatchError{Expected: types.Array, Actual: av.Kind}
+	}
+	a := av.Array
+	if vm.maxContainerLen > 0 && len(a) >= vm.maxContainerLen {
+		return ErrMaximumContainerSizeExceeded
+	}
+	err = vm.checkDepth(xs.depth + 1)
 	if err != nil {
 		return err
 	}
-	o[string(k)] = v.DeepCopy()
-	return vm.pushObject(o)
-}
-
-func (vm *VM) feedAnew() error {
-	return vm.pushArray([]*types.Value{})
-}
-
-func (vm *VM) feedAadd() error {
-	x, err := vm.pop()
+	err = vm.allocate(xs.size)
 	if err != nil {
 		return err
 	}
-	a, err := vm.popArray()
+	as.size = addSize(as.size, xs.size)
+	if as.depth < xs.depth+1 {
+		as.depth = xs.depth + 1
+	}
+	if vm.isShared(av) {
+		vm.release(av)
+		vm.markSharing(av)
+		a = append(make([]*types.Value, 0, len(a)+1), a...)
+		as.sharesParts = true
+	}
+	if xs.sharesParts || vm.isShared(x) {
+		as.sharesParts = true
+	}
+	a = append(a, x)
+	err = vm.pushSlot(types.NewArrayValue(a), as)
 	if err != nil {
 		return err
 	}
-	a = append(a, x.DeepCopy())
-	return vm.pushArray(a)
-}
-
-func (vm *VM) feedBnew() error {
-	return vm.pushBool(false)
+	vm.moved(x)
+	return nil
 }
 
-func (vm *VM) feedBneg() error {
-	v, err := vm.popBool()
+func (vm *VM) feedGdup() error {
+	v, vs, err := vm.popSlot()
 	if err != nil {
 		return err
 	}
-	return vm.pushBool(!v)
-}
-
-func (vm *VM) feedNnew() error {
-	return vm.pushNil()
-}
-
-func (vm *VM) feedGdup() error {
-	v, err := vm.pop()
+	err = vm.pushSlot(v, vs)
 	if err != nil {
 		return err
 	}
-	err = vm.push(v)
+	err = vm.allocate(vs.size)
 	if err != nil {
 		return err
 	}
-	return vm.push(v.DeepCopy())
+	err = vm.pushSlot(v, vs)
+	if err != nil {
+		return err
+	}
+	vm.retain(v)
+	return nil
 }
 
 func (vm *VM) feedGpop() error {
-	_, err := vm.pop()
-	return err
+	v, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	vm.release(v)
+	return nil
 }
 
 func (vm *VM) feedGswp() error {
-	a, err := vm.pop()
+	a, as, err := vm.popSlot()
 	if err != nil {
 		return err
 	}
-	b, err := vm.pop()
+	b, bs, err := vm.popSlot()
 	if err != nil {
 		return err
 	}
-	err = vm.push(a)
+	err = vm.pushSlot(a, as)
 	if err != nil {
 		return err
 	}
-	return vm.push(b)
+	return vm.pushSlot(b, bs)
 }
 
 //
 // Miscellaneous functions
 //
 
+// isShared reports whether v is referenced from more than one place.
+func (vm *VM) isShared(v *types.Value) bool {
+	return vm.refs[v] > 1
+}
+
+// retain records that a new reference to v is created.
+func (vm *VM) retain(v *types.Value) {
+	n, ok := vm.refs[v]
+	if !ok {
+		n = 1
+	}
+	vm.refs[v] = n + 1
+}
+
+// release records that a reference to v on the stack is removed.
+func (vm *VM) release(v *types.Value) {
+	n, ok := vm.refs[v]
+	if !ok {
+		return
+	}
+	if n <= 2 || !vm.onStack(v) {
+		delete(vm.refs, v)
+	} else {
+		vm.refs[v] = n - 1
+	}
+}
+
+// moved records that a reference to v is moved from the stack into a container.
+//
+// Values in containers are never modified in place, so v need not be copied once there are no references to it on the stack.
+// Forgetting them keeps refs as small as the stack.
+func (vm *VM) moved(v *types.Value) {
+	if _, ok := vm.refs[v]; ok && !vm.onStack(v) {
+		delete(vm.refs, v)
+	}
+}
+
+// onStack reports whether v is on the stack.
+func (vm *VM) onStack(v *types.Value) bool {
+	for i := 0; i <= vm.sp; i++ {
+		if vm.stack[i] == v {
+			return true
+		}
+	}
+	return false
+}
+
+// markSharing records that the parts of v are going to be shared with a shallow copy of v,
+// so that the values on the stack that are v are deep-copied by Top.
+func (vm *VM) markSharing(v *types.Value) {
+	for i := 0; i <= vm.sp; i++ {
+		if vm.stack[i] == v {
+			vm.slots[i].sharesParts = true
+		}
+	}
+}
+
+// allocate records that n bytes are going to be allocated.
+func (vm *VM) allocate(n int) error {
+	allocated := addSize(vm.allocated, n)
+	if vm.maxAllocation > 0 && allocated > vm.maxAllocation {
+		return ErrMaximumAllocationExceeded
+	}
+	vm.allocated = allocated
+	return nil
+}
+
+func (vm *VM) checkDepth(depth int) error {
+	if vm.maxDepth > 0 && depth > vm.maxDepth {
+		return ErrMaximumDepthExceeded
+	}
+	return nil
+}
+
+// push pushes a newly allocated value.
 func (vm *VM) push(v *types.Value) error {
+	s := measure(v)
+	err := vm.allocate(s.size)
+	if err != nil {
+		return err
+	}
+	return vm.pushSlot(v, s)
+}
+
+// pushSlot pushes a value whose metadata is already known.
+func (vm *VM) pushSlot(v *types.Value, s slot) error {
 	if len(vm.stack)-1 <= vm.sp {
 		return ErrMaximumStackSizeExceeded
 	}
 	vm.sp++
 	vm.stack[vm.sp] = v
+	vm.slots[vm.sp] = s
 	return nil
 }
 
@@ -310,8 +509,8 @@ func (vm *VM) pushString(val []byte) error {
 	return vm.push(types.NewStringValue(val))
 }
 
-func (vm *VM) pushObject(val map[string]*types.Value) error {
-	return vm.push(types.NewObjectValue(val))
+func (vm *VM) pushObject(val *types.Map) error {
+	return vm.push(types.NewOrderedObjectValue(val))
 }
 
 func (vm *VM) pushArray(val []*types.Value) error {
@@ -327,13 +526,18 @@ func (vm *VM) pushNil() error {
 }
 
 func (vm *VM) pop() (*types.Value, error) {
+	v, _, err := vm.popSlot()
+	return v, err
+}
+
+func (vm *VM) popSlot() (*types.Value, slot, error) {
 	if vm.sp < 0 {
-		return nil, ErrStackEmpty
+		return nil, slot{}, ErrStackEmpty
 	}
-	top := vm.stack[vm.sp]
+	top, s := vm.stack[vm.sp], vm.slots[vm.sp]
 	vm.stack[vm.sp] = nil
 	vm.sp--
-	return top, nil
+	return top, s, nil
 }
 
 func (vm *VM) popInt() (int64, error) {
@@ -342,7 +546,7 @@ func (vm *VM) popInt() (int64, error) {
 		return 0, err
 	}
 	if v.Kind != types.Int {
-		return 0, ErrTypeMismatch
+		return 0, &TypeMismatchError{Expected: types.Int, Actual: v.Kind}
 	}
 	return v.Int, nil
 }
@@ -353,51 +557,18 @@ func (vm *VM) popFloat() (float64, error) {
 		return 0, err
 	}
 	if v.Kind != types.Float {
-		return 0, ErrTypeMismatch
+		return 0, &TypeMismatchError{Expected: types.Float, Actual: v.Kind}
 	}
 	return v.Float, nil
 }
 
-func (vm *VM) popString() ([]byte, error) {
-	v, err := vm.pop()
-	if err != nil {
-		return nil, err
-	}
-	if v.Kind != types.String {
-		return nil, ErrTypeMismatch
-	}
-	return v.String, nil
-}
-
-func (vm *VM) popObject() (map[string]*types.Value, error) {
-	v, err := vm.pop()
-	if err != nil {
-		return nil, err
-	}
-	if v.Kind != types.Object {
-		return nil, ErrTypeMismatch
-	}
-	return v.Object, nil
-}
-
-func (vm *VM) popArray() ([]*types.Value, error) {
-	v, err := vm.pop()
-	if err != nil {
-		return nil, err
-	}
-	if v.Kind != types.Array {
-		return nil, ErrTypeMismatch
-	}
-	return v.Array, nil
-}
-
 func (vm *VM) popBool() (bool, error) {
 	v, err := vm.pop()
 	if err != nil {
 		return false, err
 	}
 	if v.Kind != types.Bool {
-		return false, ErrTypeMismatch
+		return false, &TypeMismatchError{Expected: types.Bool, Actual: v.Kind}
 	}
 	return v.Bool, nil
 }
@@ -1,6 +1,7 @@
 package vm
 
 import (
+	"errors"
 	"math"
 	"testing"
 
@@ -67,7 +68,7 @@ func TestFeedIincFailsWhenTypeMismatch(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Iinc)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -130,7 +131,7 @@ func TestFeedIshlFailsWhenTypeMismatch(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Ishl)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -202,7 +203,7 @@ func TestFeedIaddFailsWhenArg1IsNotInteger(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Iadd)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -220,7 +221,7 @@ func TestFeedIaddFailsWhenArg2IsNotInteger(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Iadd)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -272,7 +273,7 @@ func TestFeedInegFailsWhenArg1IsNotInteger(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Ineg)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -377,7 +378,7 @@ func TestFeedIshtFailsWhenArg1IsNotInteger(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Isht)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -395,7 +396,7 @@ func TestFeedIshtFailsWhenArg2IsNotInteger(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Isht)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -447,7 +448,7 @@ func TestFeedItofFailsWhenArg1IsNotInteger(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Itof)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -499,7 +500,7 @@ func TestFeedItouFailsWhenArg1IsNotInteger(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Itou)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -626,7 +627,7 @@ func TestFeedFnegFailsWhenArg1IsNotFloat(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Fneg)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -721,7 +722,7 @@ func TestFeedSaddFailsWhenArg1IsNotInteger(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Sadd)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -739,7 +740,7 @@ func TestFeedSaddFailsWhenArg2IsNotString(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Sadd)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -771,9 +772,9 @@ func TestFeedOaddAddsAKeyValuePairToAnObject(t *testing.T) {
 	var err error
 	vm := NewVM()
 
-	err = vm.pushObject(map[string]*types.Value{
+	err = vm.pushObject(types.NewMapFromGo(map[string]*types.Value{
 		"hello": types.NewStringValue([]byte("world")),
-	})
+	}))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -812,31 +813,40 @@ func TestFeedOaddAddsACopyOfAValue(t *testing.T) {
 	var err error
 	vm := NewVM()
 
-	err = vm.pushObject(map[string]*types.Value{
-		"hello": types.NewStringValue([]byte("world")),
+	addedVal := types.NewMapFromGo(map[string]*types.Value{
+		"name": types.NewStringValue([]byte("taro")),
+		"age":  types.NewIntValue(20),
 	})
+	err = vm.pushObject(addedVal)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = vm.pushString([]byte("user"))
+	// The added value is kept at the bottom of the stack.
+	err = vm.Feed(Gdup)
 	if err != nil {
 		t.Fatal(err)
 	}
-	addedVal := map[string]*types.Value{
-		"name": types.NewStringValue([]byte("taro")),
-		"age":  types.NewIntValue(20),
+	err = vm.pushObject(types.NewMapFromGo(map[string]*types.Value{
+		"hello": types.NewStringValue([]byte("world")),
+	}))
+	if err != nil {
+		t.Fatal(err)
 	}
-	err = vm.pushObject(addedVal)
+	err = vm.Feed(Gswp)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = vm.Feed(Oadd)
+	err = vm.pushString([]byte("user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vm.FeedMulti([]Op{Gswp, Oadd})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if vm.sp != 0 {
-		t.Fatalf("stack pointer mismatch: expected %d, got %d", 0, vm.sp)
+	if vm.sp != 1 {
+		t.Fatalf("stack pointer mismatch: expected %d, got %d", 1, vm.sp)
 	}
 
 	want := types.NewObjectValue(map[string]*types.Value{
@@ -855,9 +865,46 @@ func TestFeedOaddAddsACopyOfAValue(t *testing.T) {
 		t.Errorf("mismatch (-want +got):\n%s", diff)
 	}
 
-	got.Object["user"].Object["name"] = types.NewStringValue([]byte("jiro"))
-	if diff := cmp.Diff(addedVal, got.Object["user"].Object); diff == "" {
-		t.Errorf("the added value does not seem to be a clone of the value on the stack")
+	user, _ := got.Object.Get("user")
+	user.Object.Set("name", types.NewStringValue([]byte("jiro")))
+	if diff := cmp.Diff(types.NewOrderedObjectValue(addedVal), vm.Stack()[0]); diff != "" {
+		t.Errorf("the added value does not seem to be a clone of the value on the stack (-want +got):\n%s", diff)
+	}
+}
+
+func TestFeedOaddPreservesTheOrderOfKeys(t *testing.T) {
+	var err error
+	vm := NewVM()
+
+	err = vm.Feed(Onew)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, k := range []string{"shrimp", "ebi", "tako", "ebi"} {
+		err = vm.pushString([]byte(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = vm.pushInt(int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = vm.Feed(Oadd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := vm.Top()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"shrimp", "ebi", "tako"}, got.Object.Keys()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	ebi, _ := got.Object.Get("ebi")
+	if diff := cmp.Diff(types.NewIntValue(3), ebi); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
 	}
 }
 
@@ -906,9 +953,9 @@ func TestFeedOaddFailsWhenArg2IsNotString(t *testing.T) {
 	var err error
 	vm := NewVM()
 
-	err = vm.pushObject(map[string]*types.Value{
+	err = vm.pushObject(types.NewMapFromGo(map[string]*types.Value{
 		"hello": types.NewStringValue([]byte("world")),
-	})
+	}))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -921,7 +968,7 @@ func TestFeedOaddFailsWhenArg2IsNotString(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Oadd)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -943,7 +990,7 @@ func TestFeedOaddFailsWhenArg3IsNotString(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Oadd)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -1010,25 +1057,30 @@ func TestFeedAaddAppendsACopyOfAValue(t *testing.T) {
 	var err error
 	vm := NewVM()
 
-	err = vm.pushArray([]*types.Value{types.NewStringValue([]byte("hello"))})
+	addedVal := types.NewMapFromGo(map[string]*types.Value{
+		"name": types.NewStringValue([]byte("taro")),
+		"age":  types.NewIntValue(20),
+	})
+	err = vm.pushObject(addedVal)
 	if err != nil {
 		t.Fatal(err)
 	}
-	addedVal := map[string]*types.Value{
-		"name": types.NewStringValue([]byte("taro")),
-		"age":  types.NewIntValue(20),
+	// The added value is kept at the bottom of the stack.
+	err = vm.Feed(Gdup)
+	if err != nil {
+		t.Fatal(err)
 	}
-	err = vm.pushObject(addedVal)
+	err = vm.pushArray([]*types.Value{types.NewStringValue([]byte("hello"))})
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = vm.Feed(Aadd)
+	err = vm.FeedMulti([]Op{Gswp, Aadd})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if vm.sp != 0 {
-		t.Fatalf("stack pointer mismatch: expected %d, got %d", 0, vm.sp)
+	if vm.sp != 1 {
+		t.Fatalf("stack pointer mismatch: expected %d, got %d", 1, vm.sp)
 	}
 
 	want := types.NewArrayValue([]*types.Value{
@@ -1047,9 +1099,9 @@ func TestFeedAaddAppendsACopyOfAValue(t *testing.T) {
 		t.Errorf("mismatch (-want +got):\n%s", diff)
 	}
 
-	got.Array[1].Object["name"] = types.NewStringValue([]byte("jiro"))
-	if diff := cmp.Diff(addedVal, got.Array[1].Object); diff == "" {
-		t.Errorf("the added value does not seem to be a clone of the value on the stack")
+	got.Array[1].Object.Set("name", types.NewStringValue([]byte("jiro")))
+	if diff := cmp.Diff(types.NewOrderedObjectValue(addedVal), vm.Stack()[0]); diff != "" {
+		t.Errorf("the added value does not seem to be a clone of the value on the stack (-want +got):\n%s", diff)
 	}
 }
 
@@ -1090,7 +1142,7 @@ func TestFeedAaddFailsIfArg2IsNotArray(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Aadd)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -1165,7 +1217,7 @@ func TestFeedBnegFailsWhenArg1IsNotBool(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = vm.Feed(Bneg)
-	if err != ErrTypeMismatch {
+	if !errors.Is(err, ErrTypeMismatch) {
 		t.Fatal(err)
 	}
 }
@@ -1231,9 +1283,9 @@ func TestGdupPushesACopy(t *testing.T) {
 	var err error
 	vm := NewVM()
 
-	err = vm.pushObject(map[string]*types.Value{
+	err = vm.pushObject(types.NewMapFromGo(map[string]*types.Value{
 		"hello": types.NewStringValue([]byte("world")),
-	})
+	}))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1243,30 +1295,46 @@ func TestGdupPushesACopy(t *testing.T) {
 	}
 
 	if vm.sp != 1 {
-		t.Fatalf("stack pointer mismatch: expected %d, got %d", 0, vm.sp)
+		t.Fatalf("stack pointer mismatch: expected %d, got %d", 1, vm.sp)
 	}
 
 	want := types.NewObjectValue(map[string]*types.Value{
 		"hello": types.NewStringValue([]byte("world")),
 	})
-	clone, err := vm.pop()
+	clone, err := vm.Top()
 	if err != nil {
 		t.Fatal(err)
 	}
 	if diff := cmp.Diff(want, clone); diff != "" {
 		t.Errorf("mismatch (-want +got):\n%s", diff)
 	}
-	orig, err := vm.pop()
+
+	clone.Object.Set("ebi", types.NewStringValue([]byte("shrimp")))
+	if diff := cmp.Diff(want, vm.Stack()[0]); diff != "" {
+		t.Errorf("Gdup does not seem to copy arg1 (-want +got):\n%s", diff)
+	}
+}
+
+func TestTopDoesNotShareChildrenWithTheOriginalOfAClone(t *testing.T) {
+	var err error
+	vm := NewVM()
+	err = vm.FeedMulti([]Op{Anew, Anew, Aadd, Gdup, Inew, Aadd, Gswp})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if diff := cmp.Diff(want, orig); diff != "" {
-		t.Errorf("mismatch (-want +got):\n%s", diff)
+
+	got, err := vm.Top()
+	if err != nil {
+		t.Fatal(err)
 	}
+	got.Array[0].Array = append(got.Array[0].Array, types.NewNilValue())
 
-	clone.Object["ebi"] = types.NewStringValue([]byte("shrimp"))
-	if diff := cmp.Diff(clone, orig); diff == "" {
-		t.Errorf("Gdup does not seem to copy arg1")
+	want := types.NewArrayValue([]*types.Value{
+		types.NewArrayValue([]*types.Value{}),
+		types.NewIntValue(0),
+	})
+	if diff := cmp.Diff(want, vm.Stack()[0]); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
 	}
 }
 
@@ -1416,3 +1484,420 @@ func TestFeedMultiExecutesOpsSequentially(t *testing.T) {
 		t.Errorf("mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestFeedReportsTheKindsOfTypeMismatch(t *testing.T) {
+	var err error
+	vm := NewVM()
+	err = vm.FeedMulti([]Op{Inew, Snew, Iadd})
+	var mismatch *TypeMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected TypeMismatchError but got %v", err)
+	}
+
+	want := &TypeMismatchError{Expected: types.Int, Actual: types.String}
+	if diff := cmp.Diff(want, mismatch); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFeedLeavesTheStackUnchangedOnError(t *testing.T) {
+	var err error
+	vm := NewVM()
+	err = vm.FeedMulti([]Op{Bnew, Inew, Snew, Inew, Iinc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := vm.Stack()
+
+	err = vm.Feed(Oadd)
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected ErrTypeMismatch but got %v", err)
+	}
+
+	got := vm.Stack()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFeedLeavesTheStackUnchangedOnOverflow(t *testing.T) {
+	var err error
+	vm := NewVM(WithStackSize(2))
+	err = vm.FeedMulti([]Op{Bnew, Inew})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := vm.Stack()
+
+	err = vm.Feed(Gdup)
+	if err != ErrMaximumStackSizeExceeded {
+		t.Fatalf("expected ErrMaximumStackSizeExceeded but got %v", err)
+	}
+
+	got := vm.Stack()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStackReturnsValuesFromTheBottom(t *testing.T) {
+	var err error
+	vm := NewVM()
+	err = vm.FeedMulti([]Op{Inew, Bnew})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*types.Value{types.NewIntValue(0), types.NewBoolValue(false)}
+	got := vm.Stack()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFeedFailsWhenTooManyInstructionsAreExecuted(t *testing.T) {
+	var err error
+	vm := NewVM(WithMaxInstructions(3))
+	err = vm.FeedMulti([]Op{Inew, Iinc, Iinc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vm.Feed(Iinc)
+	if err != ErrMaximumInstructionsExceeded {
+		t.Fatalf("expected ErrMaximumInstructionsExceeded but got %v", err)
+	}
+}
+
+func TestFeedDoesNotCountFailedInstructions(t *testing.T) {
+	var err error
+	vm := NewVM(WithMaxInstructions(2))
+	err = vm.Feed(Iinc)
+	if err != ErrStackEmpty {
+		t.Fatalf("expected ErrStackEmpty but got %v", err)
+	}
+	err = vm.FeedMulti([]Op{Inew, Iinc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vm.Feed(Iinc)
+	if err != ErrMaximumInstructionsExceeded {
+		t.Fatalf("expected ErrMaximumInstructionsExceeded but got %v", err)
+	}
+}
+
+func TestFeedFailsWhenTooManyBytesAreAllocated(t *testing.T) {
+	var err error
+	// Doubling an array in each iteration would take exponential time without the limit.
+	ops := []Op{Anew}
+	for i := 0; i < 100; i++ {
+		ops = append(ops, Gdup, Aadd)
+	}
+	vm := NewVM(WithMaxAllocation(1 << 20))
+	err = vm.FeedMulti(ops)
+	if err != ErrMaximumAllocationExceeded {
+		t.Fatalf("expected ErrMaximumAllocationExceeded but got %v", err)
+	}
+	if vm.allocated > 1<<20 {
+		t.Errorf("allocated %d bytes, which exceeds the limit", vm.allocated)
+	}
+}
+
+func TestFeedSaddCountsOnlyTheAppendedByte(t *testing.T) {
+	var err error
+	ops := []Op{Snew}
+	for i := 0; i < 1000; i++ {
+		ops = append(ops, Inew, Sadd)
+	}
+	vm := NewVM(WithMaxAllocation(100 * 1000))
+	err = vm.FeedMulti(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFeedFailsWhenAStringIsTooLong(t *testing.T) {
+	var err error
+	vm := NewVM(WithMaxStringLength(2))
+	err = vm.FeedMulti([]Op{Snew, Inew, Sadd, Inew, Sadd, Inew})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vm.Feed(Sadd)
+	if err != ErrMaximumStringLengthExceeded {
+		t.Fatalf("expected ErrMaximumStringLengthExceeded but got %v", err)
+	}
+}
+
+func TestFeedFailsWhenAnArrayIsTooLarge(t *testing.T) {
+	var err error
+	vm := NewVM(WithMaxContainerSize(2))
+	err = vm.FeedMulti([]Op{Anew, Inew, Aadd, Inew, Aadd, Inew})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vm.Feed(Aadd)
+	if err != ErrMaximumContainerSizeExceeded {
+		t.Fatalf("expected ErrMaximumContainerSizeExceeded but got %v", err)
+	}
+}
+
+func TestFeedFailsWhenAnObjectIsTooLarge(t *testing.T) {
+	var err error
+	vm := NewVM(WithMaxContainerSize(1))
+	err = vm.FeedMulti([]Op{Onew, Snew, Inew, Oadd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Overwriting an existing key does not make the object larger.
+	err = vm.FeedMulti([]Op{Snew, Nnew, Oadd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vm.FeedMulti([]Op{Snew, Inew, Sadd, Inew})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vm.Feed(Oadd)
+	if err != ErrMaximumContainerSizeExceeded {
+		t.Fatalf("expected ErrMaximumContainerSizeExceeded but got %v", err)
+	}
+}
+
+func TestFeedFailsWhenAValueIsTooDeep(t *testing.T) {
+	var err error
+	vm := NewVM(WithMaxDepth(2))
+	err = vm.FeedMulti([]Op{Anew, Anew, Anew, Aadd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vm.Feed(Aadd)
+	if err != ErrMaximumDepthExceeded {
+		t.Fatalf("expected ErrMaximumDepthExceeded but got %v", err)
+	}
+}
+
+func TestFeedTracksTheDepthOfPushedValues(t *testing.T) {
+	var err error
+	vm := NewVM(WithMaxDepth(2))
+	err = vm.pushObject(types.NewMapFromGo(map[string]*types.Value{
+		"nested": types.NewObjectValue(map[string]*types.Value{}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vm.FeedMulti([]Op{Anew, Gswp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vm.Feed(Aadd)
+	if err != ErrMaximumDepthExceeded {
+		t.Fatalf("expected ErrMaximumDepthExceeded but got %v", err)
+	}
+}
+
+func TestFeedOaddDoesNotAffectADuplicatedObject(t *testing.T) {
+	var err error
+	vm := NewVM()
+	err = vm.FeedMulti([]Op{Onew, Gdup, Snew, Inew, Oadd})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*types.Value{
+		types.NewObjectValue(map[string]*types.Value{}),
+		types.NewObjectValue(map[string]*types.Value{"": types.NewIntValue(0)}),
+	}
+	got := vm.Stack()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFeedOaddDoesNotAffectAnAddedValue(t *testing.T) {
+	var err error
+	vm := NewVM()
+	// Adds an array to an object, and then modifies a duplicate of the array.
+	err = vm.FeedMulti([]Op{Anew, Gdup, Onew, Gswp, Snew, Gswp, Oadd, Gswp, Inew, Aadd})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*types.Value{
+		types.NewObjectValue(map[string]*types.Value{"": types.NewArrayValue([]*types.Value{})}),
+		types.NewArrayValue([]*types.Value{types.NewIntValue(0)}),
+	}
+	got := vm.Stack()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFeedAaddDoesNotAffectADuplicatedArray(t *testing.T) {
+	var err error
+	vm := NewVM()
+	err = vm.FeedMulti([]Op{Anew, Gdup, Inew, Aadd})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*types.Value{
+		types.NewArrayValue([]*types.Value{}),
+		types.NewArrayValue([]*types.Value{types.NewIntValue(0)}),
+	}
+	got := vm.Stack()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFeedAaddDoesNotAffectAnAddedValue(t *testing.T) {
+	var err error
+	vm := NewVM()
+	// Adds an array to another array, and then modifies a duplicate of the former.
+	err = vm.FeedMulti([]Op{Anew, Gdup, Anew, Gswp, Aadd, Gswp, Inew, Aadd})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*types.Value{
+		types.NewArrayValue([]*types.Value{types.NewArrayValue([]*types.Value{})}),
+		types.NewArrayValue([]*types.Value{types.NewIntValue(0)}),
+	}
+	got := vm.Stack()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFeedSaddDoesNotAffectADuplicatedString(t *testing.T) {
+	var err error
+	vm := NewVM()
+	err = vm.FeedMulti([]Op{Snew, Inew, Sadd, Gdup, Inew, Iinc, Sadd, Gswp, Inew, Sadd})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*types.Value{
+		types.NewStringValue([]byte{0, 1}),
+		types.NewStringValue([]byte{0, 0}),
+	}
+	got := vm.Stack()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTopReturnsAValueWithoutSharedParts(t *testing.T) {
+	var err error
+	vm := NewVM()
+	// Builds [[], [[]]] in which all empty arrays are the same value.
+	err = vm.FeedMulti([]Op{Anew, Gdup, Aadd, Gdup, Aadd})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := vm.Top()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Array[0].Array = append(got.Array[0].Array, types.NewIntValue(0))
+
+	want := types.NewArrayValue([]*types.Value{
+		types.NewArrayValue([]*types.Value{types.NewIntValue(0)}),
+		types.NewArrayValue([]*types.Value{types.NewArrayValue([]*types.Value{})}),
+	})
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestVMForgetsReferencesThatAreNotOnTheStack(t *testing.T) {
+	var err error
+	vm := NewVM()
+	err = vm.Feed(Anew)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		// Adds [x, x] and x + x to the array, where x is duplicated.
+		ops := []Op{Inew, Gdup, Anew, Gswp, Aadd, Gswp, Aadd, Aadd, Inew, Gdup, Iadd, Aadd}
+		for _, op := range ops {
+			err = vm.Feed(op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(vm.refs) > vm.sp+1 {
+				t.Fatalf("refs has %d entries while the stack has %d values", len(vm.refs), vm.sp+1)
+			}
+		}
+	}
+	if len(vm.refs) != 0 {
+		t.Errorf("expected no references but got %d", len(vm.refs))
+	}
+}
+
+func feedBenchmark(b *testing.B, ops []Op) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		vm := NewVM()
+		err := vm.FeedMulti(ops)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = vm.Top()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// keyOps returns ops that push a string representation of n.
+func keyOps(n int) []Op {
+	ops := []Op{Snew}
+	for ; n > 0; n /= 10 {
+		ops = append(ops, Inew)
+		for i := 0; i < n%10; i++ {
+			ops = append(ops, Iinc)
+		}
+		ops = append(ops, Sadd)
+	}
+	return ops
+}
+
+func BenchmarkFeedLargeArray(b *testing.B) {
+	ops := []Op{Anew}
+	for i := 0; i < 10000; i++ {
+		ops = append(ops, Onew, Snew, Inew, Oadd, Aadd)
+	}
+	feedBenchmark(b, ops)
+}
+
+func BenchmarkFeedLargeObject(b *testing.B) {
+	ops := []Op{Onew}
+	for i := 0; i < 10000; i++ {
+		ops = append(ops, keyOps(i)...)
+		ops = append(ops, Anew, Inew, Aadd, Oadd)
+	}
+	feedBenchmark(b, ops)
+}
+
+func BenchmarkFeedDeeplyNestedArray(b *testing.B) {
+	ops := []Op{}
+	for i := 0; i < 1000; i++ {
+		ops = append(ops, Anew)
+	}
+	for i := 0; i < 999; i++ {
+		ops = append(ops, Aadd)
+	}
+	feedBenchmark(b, ops)
+}
+
+func BenchmarkFeedLargeObjectWithDuplicates(b *testing.B) {
+	// This is the same as what prettifier.Prettifier generates for Oadd.
+	ops := []Op{Onew}
+	for i := 0; i < 10000; i++ {
+		ops = append(ops, keyOps(i)...)
+		ops = append(ops, Inew, Oadd, Gdup, Gpop)
+	}
+	feedBenchmark(b, ops)
+}
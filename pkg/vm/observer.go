@@ -0,0 +1,87 @@
+package vm
+
+import (
+	"github.com/genkami/watson/pkg/types"
+)
+
+// Observer is notified of each instruction that a VM executes. See WithObserver.
+//
+// The values passed to Observer are the ones on the stack of the VM, so they must not be modified.
+// Since the VM modifies containers in place unless they are shared, the containers that an instruction pops may already have been modified in AfterOp;
+// e.g. the object popped by Oadd is the same one as the object pushed by it. Inspect operands in BeforeOp if their previous states are needed.
+type Observer interface {
+	// BeforeOp is called before op is executed.
+	// sp is the index of the top of the stack (-1 if the stack is empty), and operands are the values that op pops, from the bottom to the top.
+	// If there are not enough values on the stack, operands only contains the ones on it.
+	BeforeOp(op Op, sp int, operands []*types.Value)
+
+	// AfterOp is called after op is executed.
+	// sp is the index of the top of the stack after the execution, and popped and pushed are the values that op popped and pushed, from the bottom to the top.
+	// If op fails, err is the error that Feed returns, and popped and pushed are empty since the stack is left as it was before op.
+	AfterOp(op Op, sp int, popped, pushed []*types.Value, err error)
+}
+
+// WithObserver makes a VM call o before and after each instruction.
+// o is not called for an instruction that exceeds the limit set by WithMaxInstructions, since it is not executed at all.
+func WithObserver(o Observer) VMOption {
+	return vmOption(func(v *VM) {
+		v.observer = o
+	})
+}
+
+// arity is the number of values that an Op pops and pushes.
+type arity struct {
+	pops, pushes int
+}
+
+var arities = [numOps]arity{
+	Inew: {0, 1},
+	Iinc: {1, 1},
+	Ishl: {1, 1},
+	Iadd: {2, 1},
+	Ineg: {1, 1},
+	Isht: {2, 1},
+	Itof: {1, 1},
+	Itou: {1, 1},
+	Finf: {0, 1},
+	Fnan: {0, 1},
+	Fneg: {1, 1},
+	Snew: {0, 1},
+	Sadd: {2, 1},
+	Onew: {0, 1},
+	Oadd: {3, 1},
+	Anew: {0, 1},
+	Aadd: {2, 1},
+	Bnew: {0, 1},
+	Bneg: {1, 1},
+	Nnew: {0, 1},
+	Gdup: {1, 2},
+	Gpop: {1, 0},
+	Gswp: {2, 2},
+}
+
+// executeObserved executes op in the same way as execute, and notifies the observer.
+func (vm *VM) executeObserved(op Op) error {
+	var a arity
+	if 0 <= op && op < numOps {
+		a = arities[op]
+	}
+	sp := vm.sp
+	n := a.pops
+	if n > sp+1 {
+		n = sp + 1
+	}
+	var operands [3]*types.Value
+	copy(operands[:], vm.stack[sp+1-n:sp+1])
+	vm.observer.BeforeOp(op, sp, operands[:n])
+
+	err := vm.execute(op)
+	if err != nil {
+		vm.observer.AfterOp(op, vm.sp, nil, nil, err)
+		return err
+	}
+	var pushed [2]*types.Value
+	m := copy(pushed[:], vm.stack[vm.sp+1-a.pushes:vm.sp+1])
+	vm.observer.AfterOp(op, vm.sp, operands[:n], pushed[:m], nil)
+	return nil
+}
@@ -0,0 +1,135 @@
+package vm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/genkami/watson/pkg/types"
+)
+
+type event struct {
+	Op       Op
+	Before   bool
+	SP       int
+	Operands []string
+	Popped   []string
+	Pushed   []string
+	Err      error
+}
+
+// recorder is an Observer that records summaries of the values so that they are compared after execution.
+type recorder struct {
+	events []event
+}
+
+func (r *recorder) BeforeOp(op Op, sp int, operands []*types.Value) {
+	r.events = append(r.events, event{Op: op, Before: true, SP: sp, Operands: summaries(operands)})
+}
+
+func (r *recorder) AfterOp(op Op, sp int, popped, pushed []*types.Value, err error) {
+	r.events = append(r.events, event{Op: op, SP: sp, Popped: summaries(popped), Pushed: summaries(pushed), Err: err})
+}
+
+func summaries(vals []*types.Value) []string {
+	s := make([]string, 0, len(vals))
+	for _, v := range vals {
+		s = append(s, v.Summary())
+	}
+	return s
+}
+
+func TestObserverIsCalledBeforeAndAfterEachOp(t *testing.T) {
+	r := &recorder{}
+	vm := NewVM(WithObserver(r))
+	err := vm.FeedMulti([]Op{Inew, Iinc, Snew, Gswp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []event{
+		{Op: Inew, Before: true, SP: -1, Operands: []string{}},
+		{Op: Inew, SP: 0, Popped: []string{}, Pushed: []string{"Int(0)"}},
+		{Op: Iinc, Before: true, SP: 0, Operands: []string{"Int(0)"}},
+		{Op: Iinc, SP: 0, Popped: []string{"Int(0)"}, Pushed: []string{"Int(1)"}},
+		{Op: Snew, Before: true, SP: 0, Operands: []string{}},
+		{Op: Snew, SP: 1, Popped: []string{}, Pushed: []string{`String("")`}},
+		{Op: Gswp, Before: true, SP: 1, Operands: []string{"Int(1)", `String("")`}},
+		{Op: Gswp, SP: 1, Popped: []string{"Int(1)", `String("")`}, Pushed: []string{`String("")`, "Int(1)"}},
+	}
+	if diff := cmp.Diff(want, r.events); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestObserverIsNotifiedOfFailures(t *testing.T) {
+	r := &recorder{}
+	vm := NewVM(WithObserver(r))
+	err := vm.FeedMulti([]Op{Nnew, Iinc})
+	if err == nil {
+		t.Fatal("expected error but got nil")
+	}
+	got := r.events[len(r.events)-1]
+	if got.Err != err || got.SP != 0 || len(got.Popped) != 0 || len(got.Pushed) != 0 {
+		t.Errorf("unexpected event: %#v", got)
+	}
+}
+
+func TestObservedArityMatchesTheStack(t *testing.T) {
+	// Each Op is executed on a stack that has enough values of the right kinds.
+	setups := map[Op][]Op{
+		Iinc: {Inew}, Ishl: {Inew}, Iadd: {Inew, Inew}, Ineg: {Inew}, Isht: {Inew, Inew}, Itof: {Inew}, Itou: {Inew},
+		Fneg: {Finf}, Sadd: {Snew, Inew}, Oadd: {Onew, Snew, Nnew}, Aadd: {Anew, Nnew},
+		Bneg: {Bnew}, Gdup: {Nnew}, Gpop: {Nnew}, Gswp: {Nnew, Bnew},
+	}
+	for _, op := range AllOps() {
+		r := &recorder{}
+		vm := NewVM(WithObserver(r))
+		err := vm.FeedMulti(append(setups[op], op))
+		if err != nil {
+			t.Fatalf("%#v: %s", op, err)
+		}
+		before, after := r.events[len(r.events)-2], r.events[len(r.events)-1]
+		if len(before.Operands) != len(after.Popped) {
+			t.Errorf("%#v: expected %d operands but %d values are popped", op, len(before.Operands), len(after.Popped))
+		}
+		if before.SP-len(after.Popped)+len(after.Pushed) != after.SP {
+			t.Errorf("%#v: popped %d and pushed %d but sp moved from %d to %d", op, len(after.Popped), len(after.Pushed), before.SP, after.SP)
+		}
+	}
+}
+
+func TestTracerWritesEachOp(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tracer := NewTracer(buf)
+	vm := NewVM(WithObserver(tracer))
+	ops := []Op{Inew, Iinc, Onew, Iadd}
+	for i, op := range ops {
+		tracer.SetPosition("test.watson", 0, i)
+		err := vm.Feed(op)
+		if op == Iadd && err == nil {
+			t.Fatal("expected error but got nil")
+		}
+	}
+	want := `test.watson:1:1: Inew [] -> [Int(0)] (sp=0)
+test.watson:1:2: Iinc [Int(0)] -> [Int(1)] (sp=0)
+test.watson:1:3: Onew [] -> [Object(0 keys)] (sp=1)
+test.watson:1:4: Iadd [Int(1), Object(0 keys)] failed: type mismatch: expected Int but got Object
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTracerShowsOperandsBeforeTheyAreModified(t *testing.T) {
+	buf := &bytes.Buffer{}
+	vm := NewVM(WithObserver(NewTracer(buf)))
+	err := vm.FeedMulti([]Op{Anew, Nnew, Aadd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Aadd [Array(0 elements), Nil] -> [Array(1 elements)] (sp=0)\n"
+	if got := buf.String(); !bytes.HasSuffix([]byte(got), []byte(want)) {
+		t.Errorf("expected %q to end with %q", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package vm
+
+import (
+	"math"
+
+	"github.com/genkami/watson/pkg/types"
+)
+
+// ScalarOp is the semantics of an instruction that pops and pushes only scalars, i.e. values other than objects and arrays.
+// VM executes such instructions according to their ScalarOps, so that others that execute instructions on their own representation of values can share them.
+type ScalarOp struct {
+	// Operands are the kinds of the values that the instruction pops, from the bottom to the top.
+	Operands []types.Kind
+
+	// Apply turns x into the value that the instruction pushes.
+	// x is initially a copy of the first operand, or the zero Value if there are no operands, and y is the second operand if any.
+	// Note that Sadd appends to x.String in place; it must be copied beforehand if it is shared.
+	Apply func(x, y *types.Value)
+}
+
+var scalarOps = [numOps]*ScalarOp{
+	Inew: {nil, func(x, _ *types.Value) { x.Kind = types.Int }},
+	Iinc: {[]types.Kind{types.Int}, func(x, _ *types.Value) { x.Int++ }},
+	Ishl: {[]types.Kind{types.Int}, func(x, _ *types.Value) { x.Int <<= 1 }},
+	Iadd: {[]types.Kind{types.Int, types.Int}, func(x, y *types.Value) { x.Int += y.Int }},
+	Ineg: {[]types.Kind{types.Int}, func(x, _ *types.Value) { x.Int = -x.Int }},
+	Isht: {[]types.Kind{types.Int, types.Int}, func(x, y *types.Value) {
+		if y.Int >= 0 {
+			x.Int <<= y.Int
+		} else {
+			x.Int >>= -y.Int
+		}
+	}},
+	Itof: {[]types.Kind{types.Int}, func(x, _ *types.Value) {
+		*x = types.Value{Kind: types.Float, Float: math.Float64frombits(uint64(x.Int))}
+	}},
+	Itou: {[]types.Kind{types.Int}, func(x, _ *types.Value) { *x = types.Value{Kind: types.Uint, Uint: uint64(x.Int)} }},
+	Finf: {nil, func(x, _ *types.Value) { *x = types.Value{Kind: types.Float, Float: math.Inf(1)} }},
+	Fnan: {nil, func(x, _ *types.Value) { *x = types.Value{Kind: types.Float, Float: math.NaN()} }},
+	Fneg: {[]types.Kind{types.Float}, func(x, _ *types.Value) { x.Float = -x.Float }},
+	Snew: {nil, func(x, _ *types.Value) { *x = types.Value{Kind: types.String, String: []byte{}} }},
+	Sadd: {[]types.Kind{types.String, types.Int}, func(x, y *types.Value) { x.String = append(x.String, byte(y.Int)) }},
+	Bnew: {nil, func(x, _ *types.Value) { x.Kind = types.Bool }},
+	Bneg: {[]types.Kind{types.Bool}, func(x, _ *types.Value) { x.Bool = !x.Bool }},
+	Nnew: {nil, func(x, _ *types.Value) { x.Kind = types.Nil }},
+}
+
+// LookupScalarOp returns the semantics of op, or nil if op is not an instruction that pops and pushes only scalars.
+func LookupScalarOp(op Op) *ScalarOp {
+	if op < 0 || numOps <= op {
+		return nil
+	}
+	return scalarOps[op]
+}
+
+// Check returns the error with which the instruction fails, or nil if it succeeds,
+// when the stack has n values and kind(i) is the kind of the i-th value from the top.
+func (s *ScalarOp) Check(n int, kind func(i int) types.Kind) error {
+	for i := range s.Operands {
+		if n <= i {
+			return ErrStackEmpty
+		}
+		want := s.Operands[len(s.Operands)-1-i]
+		if got := kind(i); got != want {
+			return &TypeMismatchError{Expected: want, Actual: got}
+		}
+	}
+	return nil
+}
+
+// feedScalar executes an instruction whose semantics is s.
+func (vm *VM) feedScalar(s *ScalarOp) error {
+	err := s.Check(vm.sp+1, vm.kindAt)
+	if err != nil {
+		return err
+	}
+	var x types.Value
+	var y *types.Value
+	var operands [2]*types.Value
+	n := copy(operands[:], vm.stack[vm.sp+1-len(s.Operands):vm.sp+1])
+	switch n {
+	case 2:
+		x, y = *operands[0], operands[1]
+	case 1:
+		x = *operands[0]
+	}
+	for i := 0; i < n; i++ {
+		vm.pop()
+	}
+	s.Apply(&x, y)
+	err = vm.push(&x)
+	if err != nil {
+		return err
+	}
+	// The operands are not on the stack any more unless they are duplicated.
+	for _, v := range operands[:n] {
+		vm.release(v)
+	}
+	return nil
+}
+
+// kindAt returns the kind of the i-th value from the top of the stack.
+func (vm *VM) kindAt(i int) types.Kind {
+	return vm.stack[vm.sp-i].Kind
+}
@@ -0,0 +1,34 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/genkami/watson/pkg/types"
+)
+
+func TestLookupScalarOpReturnsNilForInstructionsOnContainersOrTheStack(t *testing.T) {
+	for _, op := range []Op{Onew, Oadd, Anew, Aadd, Gdup, Gpop, Gswp, Op(-1), numOps} {
+		if s := LookupScalarOp(op); s != nil {
+			t.Errorf("%#v: expected nil but got %#v", op, s)
+		}
+	}
+	for _, op := range []Op{Inew, Iadd, Itof, Fnan, Sadd, Bneg, Nnew} {
+		if s := LookupScalarOp(op); s == nil {
+			t.Errorf("%#v: expected a ScalarOp but got nil", op)
+		}
+	}
+}
+
+func TestCheckReportsTheTopFirst(t *testing.T) {
+	kinds := []types.Kind{types.Int, types.Nil} // from the top
+	kind := func(i int) types.Kind { return kinds[i] }
+	err := LookupScalarOp(Sadd).Check(len(kinds), kind)
+	want := &TypeMismatchError{Expected: types.String, Actual: types.Nil}
+	if e, ok := err.(*TypeMismatchError); !ok || *e != *want {
+		t.Errorf("expected %v but got %v", want, err)
+	}
+	err = LookupScalarOp(Sadd).Check(1, kind)
+	if err != ErrStackEmpty {
+		t.Errorf("expected ErrStackEmpty but got %v", err)
+	}
+}
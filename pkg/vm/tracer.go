@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/genkami/watson/pkg/types"
+)
+
+// Tracer is an Observer that writes a line for each instruction that a VM executes, like this:
+//
+//	example.watson:1:3: Iadd [Int(1), Int(2)] -> [Int(3)] (sp=0)
+//	example.watson:1:4: Oadd [Int(3), Nil] failed: type mismatch: expected Object but got Int
+//
+// Each line consists of the position of the instruction (if it is set by SetPosition), the instruction,
+// the values that it pops and the ones that it pushes, and the index of the top of the stack after the execution.
+type Tracer struct {
+	w        io.Writer
+	hasPos   bool
+	fileName string
+	line     int
+	column   int
+	operands string // the summary of the operands of the current instruction
+	err      error
+}
+
+// NewTracer returns a new Tracer that writes to w.
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{w: w}
+}
+
+// SetPosition sets the position of the next instruction, which is written at the beginning of its line.
+// line and column are zero-origin like the ones of `lexer.Token`, and they are written as one-origin numbers.
+func (t *Tracer) SetPosition(fileName string, line, column int) {
+	t.hasPos = true
+	t.fileName = fileName
+	t.line = line
+	t.column = column
+}
+
+// BeforeOp implements Observer.
+func (t *Tracer) BeforeOp(op Op, sp int, operands []*types.Value) {
+	// Operands are summarized here since they may be modified by op.
+	t.operands = summarizeAll(operands)
+}
+
+// AfterOp implements Observer.
+func (t *Tracer) AfterOp(op Op, sp int, popped, pushed []*types.Value, err error) {
+	if t.err != nil {
+		return
+	}
+	var b strings.Builder
+	if t.hasPos {
+		if t.fileName != "" {
+			fmt.Fprintf(&b, "%s:", t.fileName)
+		}
+		fmt.Fprintf(&b, "%d:%d: ", t.line+1, t.column+1)
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "%#v %s failed: %s\n", op, t.operands, err.Error())
+	} else {
+		fmt.Fprintf(&b, "%#v %s -> %s (sp=%d)\n", op, t.operands, summarizeAll(pushed), sp)
+	}
+	_, t.err = io.WriteString(t.w, b.String())
+}
+
+// Err returns the first error that occurred while writing the trace. Once an error occurs, the Tracer stops writing.
+func (t *Tracer) Err() error {
+	return t.err
+}
+
+func summarizeAll(vals []*types.Value) string {
+	summaries := make([]string, 0, len(vals))
+	for _, v := range vals {
+		summaries = append(summaries, v.Summary())
+	}
+	return "[" + strings.Join(summaries, ", ") + "]"
+}
@@ -14,7 +14,23 @@ const (
 // VM is a virtual machine that consists of a stack of values and a pointer to the top of the stack.
 type VM struct {
 	stack []*types.Value
+	slots []slot // metadata of the values on the stack; slots[i] corresponds to stack[i]
 	sp    int
+
+	// Values are not copied when they are duplicated or added to other values; instead they are copied when they are modified.
+	// refs holds the number of references to each value that is referenced from more than one place.
+	refs map[*types.Value]int
+
+	maxInstructions int
+	maxAllocation   int
+	maxStringLength int
+	maxContainerLen int
+	maxDepth        int
+
+	executed  int // the number of instructions executed so far
+	allocated int // the number of bytes allocated so far
+
+	observer Observer
 }
 
 // VMOption provides the way to build VMs with custom configurations.
@@ -38,16 +54,70 @@ func WithStackSize(size int) VMOption {
 	})
 }
 
+// WithMaxInstructions limits the number of instructions that a VM can execute.
+// Once the VM has executed n instructions, Feed fails with ErrMaximumInstructionsExceeded.
+// Instructions that fail are not counted, since they leave the stack as it was.
+// If n is less than or equal to zero, the number of instructions is not limited, which is the default.
+func WithMaxInstructions(n int) VMOption {
+	return vmOption(func(v *VM) {
+		v.maxInstructions = n
+	})
+}
+
+// WithMaxAllocation limits the total number of bytes that a VM can allocate.
+// If an instruction would make the total exceed n, Feed fails with ErrMaximumAllocationExceeded.
+// If n is less than or equal to zero, the allocation is not limited, which is the default.
+//
+// The number of bytes is a rough estimate that does not depend on the platform:
+// every value counts as 8 bytes, and strings and keys of objects additionally count as their length in bytes.
+// Instructions that duplicate values, such as Gdup, count the size of the duplicated value
+// even though VM actually shares it until it is modified, so that the limit also bounds the size of the resulting value.
+func WithMaxAllocation(n int) VMOption {
+	return vmOption(func(v *VM) {
+		v.maxAllocation = n
+	})
+}
+
+// WithMaxStringLength limits the length of strings that a VM can build.
+// If an instruction would make a string longer than n bytes, Feed fails with ErrMaximumStringLengthExceeded.
+// If n is less than or equal to zero, the length is not limited, which is the default.
+func WithMaxStringLength(n int) VMOption {
+	return vmOption(func(v *VM) {
+		v.maxStringLength = n
+	})
+}
+
+// WithMaxContainerSize limits the number of elements of arrays and the number of keys of objects that a VM can build.
+// If an instruction would make an array or an object larger than n, Feed fails with ErrMaximumContainerSizeExceeded.
+// If n is less than or equal to zero, the size is not limited, which is the default.
+func WithMaxContainerSize(n int) VMOption {
+	return vmOption(func(v *VM) {
+		v.maxContainerLen = n
+	})
+}
+
+// WithMaxDepth limits the nesting depth of values that a VM can build.
+// The depth of an empty array or object is 1, and the depth of a non-empty one is one more than the maximum depth of its elements.
+// Other values have the depth of 0.
+// If an instruction would make a value deeper than n, Feed fails with ErrMaximumDepthExceeded.
+// If n is less than or equal to zero, the depth is not limited, which is the default.
+func WithMaxDepth(n int) VMOption {
+	return vmOption(func(v *VM) {
+		v.maxDepth = n
+	})
+}
+
 // Returns a new VM with its stack allocated.
 // For more details see VMOption.
 func NewVM(opts ...VMOption) *VM {
-	vm := &VM{sp: -1}
+	vm := &VM{sp: -1, refs: map[*types.Value]int{}}
 	for _, opt := range opts {
 		opt.apply(vm)
 	}
 	if len(vm.stack) == 0 {
 		vm.stack = make([]*types.Value, DefaultStackSize)
 	}
+	vm.slots = make([]slot, len(vm.stack))
 	return vm
 }
 
@@ -161,3 +231,13 @@ func (op Op) GoString() string {
 }
 
 var _ fmt.GoStringer = Op(0)
+
+// ParseOp returns the Op whose name is name, such as "Inew". The name is the one returned by GoString.
+func ParseOp(name string) (Op, error) {
+	for op := Op(0); op < numOps; op++ {
+		if op.GoString() == name {
+			return op, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown instruction: %q", name)
+}
@@ -31,3 +31,19 @@ func TestGoStringIsDefinedForAllOps(t *testing.T) {
 		op.GoString()
 	}
 }
+
+func TestParseOpIsTheInverseOfGoString(t *testing.T) {
+	for _, op := range AllOps() {
+		got, err := ParseOp(op.GoString())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != op {
+			t.Errorf("expected %#v but got %#v", op, got)
+		}
+	}
+	_, err := ParseOp("Ixxx")
+	if err == nil {
+		t.Error("expected error but got nil")
+	}
+}
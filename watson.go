@@ -3,11 +3,11 @@ package watson
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 
 	"github.com/genkami/watson/pkg/dumper"
 	"github.com/genkami/watson/pkg/lexer"
-	"github.com/genkami/watson/pkg/types"
 	"github.com/genkami/watson/pkg/vm"
 )
 
@@ -52,36 +52,158 @@ func Unmarshal(buf []byte, v interface{}) error {
 
 // Encoder writes Watson values to a given io.Writer.
 type Encoder struct {
-	d *dumper.Dumper
+	u        *lexer.Unlexer
+	l        *lexer.LayoutWriter // this is created lazily so that it can be configured before encoding
+	keyOrder func(a, b string) bool
+	delim    byte
+	hasDelim bool
+	wrap     int
+	breaks   bool
+	parallel int
 }
 
 // NewEncoder creates a new Encoder that writes to w.
 func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{
-		d: dumper.NewDumper(lexer.NewUnlexer(w)),
+		u: lexer.NewUnlexer(w),
 	}
 }
 
+// SetKeyOrder sets the order in which members of objects are written.
+// less reports whether the key a should be written before the key b.
+// If less is nil, which is the default, members are written in the order in which they appear in the converted Value,
+// that is, fields of structs are written in the order of their declaration and keys of maps are written in lexicographical order.
+//
+// See watson/pkg/dumper for more details.
+func (e *Encoder) SetKeyOrder(less func(a, b string) bool) {
+	e.keyOrder = less
+}
+
+// SetDelimiter makes Encode write delim after each value, so that the output becomes a stream of records that can be decoded one by one
+// by a Decoder with the same delimiter. Each record starts with the initial mode of the lexer.
+// lexer.DefaultDelimiter ('\n') is a good choice for most cases.
+//
+// This panics if delim corresponds to an Op. See Decoder.SetDelimiter for more details.
+func (e *Encoder) SetDelimiter(delim byte) {
+	if !lexer.IsDelimiter(delim) {
+		panic(fmt.Errorf("can't use %q as a delimiter since it corresponds to an Op", delim))
+	}
+	e.delim = delim
+	e.hasDelim = true
+}
+
+// SetWrapColumn makes Encode break lines so that each line has at most col characters.
+// If col is less than or equal to zero, which is the default, lines are not wrapped.
+//
+// Line breaks do not change the decoded values, but they can't be used together with '\n' as a delimiter.
+// This must be called before the first call to Encode. See lexer.LayoutWriter for more details.
+func (e *Encoder) SetWrapColumn(col int) {
+	e.wrap = col
+}
+
+// SetTopLevelBreaks makes Encode start a new line after each member of the outermost object or array if enabled is true.
+//
+// Like SetWrapColumn, this can't be used together with '\n' as a delimiter, and must be called before the first call to Encode.
+func (e *Encoder) SetTopLevelBreaks(enabled bool) {
+	e.breaks = enabled
+}
+
+// SetParallelism makes Encode convert the members of the outermost object or array on n goroutines.
+// The output is the same regardless of n. If n is less than or equal to one, which is the default, Encode does not start any goroutines.
+//
+// Encode converts v into a types.Value first if n is greater than one. See dumper.WithParallelism for more details.
+func (e *Encoder) SetParallelism(n int) {
+	e.parallel = n
+}
+
 // Encode writes the Watson encoding of v to the underlying io.Writer.
+//
+// Encode writes v while walking it, without converting it into a types.Value first (unless SetParallelism is called), so that it does not need memory proportional to the size of v.
+// So if it fails, e.g. because v contains a value that can't be converted, a part of v may have been written and the output should be discarded.
 func (e *Encoder) Encode(v interface{}) error {
-	val, err := types.ToValue(v)
+	w, err := e.writer()
 	if err != nil {
 		return err
 	}
-	return e.d.Dump(val)
+	d := dumper.NewDumper(w, dumper.WithKeyOrder(e.keyOrder), dumper.WithParallelism(e.parallel))
+	err = d.DumpGo(v)
+	if err != nil {
+		return err
+	}
+	if e.hasDelim {
+		err = w.WriteDelimiter(e.delim)
+		if err != nil {
+			return err
+		}
+	}
+	return e.u.Flush()
+}
+
+// encoderWriter is what Encoder writes Ops to.
+type encoderWriter interface {
+	lexer.OpWriter
+	WriteDelimiter(delim byte) error
+}
+
+// writer returns the writer that Encode writes to, or an error if it can't break lines.
+func (e *Encoder) writer() (encoderWriter, error) {
+	if e.wrap <= 0 && !e.breaks {
+		return e.u, nil
+	}
+	if e.hasDelim && e.delim == '\n' {
+		return nil, fmt.Errorf("can't break lines since %q is used as a delimiter", e.delim)
+	}
+	if e.l == nil {
+		opts := []lexer.LayoutOption{lexer.WithWrapColumn(e.wrap)}
+		if e.breaks {
+			opts = append(opts, lexer.WithTopLevelBreaks())
+		}
+		e.l = lexer.NewLayoutWriter(e.u, opts...)
+	}
+	return e.l, nil
 }
 
+// tokenBufferSize is the number of tokens that Decoder reads at once.
+const tokenBufferSize = 256
+
 // Decoder reads and decodes Watson values from a given io.Reader.
 type Decoder struct {
-	l         *lexer.Lexer
+	r         io.Reader
+	l         *lexer.Lexer // this is created lazily so that it can be configured before decoding
+	toks      []lexer.Token
+	pos       int  // toks[pos:n] are the tokens that are read but not executed yet
+	n         int  // the number of valid tokens in toks
+	skip      bool // whether the rest of the current record should be skipped since decoding it failed
+	delim     byte
+	hasDelim  bool
 	stackSize int
+
+	maxInstructions int
+	maxAllocation   int
+	maxStringLength int
+	maxContainerLen int
+	maxDepth        int
 }
 
 // NewDecoder creates a new Decoder that reads from r.
 func NewDecoder(r io.Reader) *Decoder {
 	return &Decoder{
-		l: lexer.NewLexer(r),
+		r:    r,
+		toks: make([]lexer.Token, tokenBufferSize),
+	}
+}
+
+// SetDelimiter makes the Decoder regard its input as a stream of records that are separated by delim.
+// Each record contains a single value and starts with the initial mode of the lexer, like the output of an Encoder with the same delimiter.
+// Then each call to Decode reads the next record, and More reports whether there are records left. Empty records are ignored.
+//
+// This must be called before the first call to Decode or More. This panics if delim corresponds to an Op.
+func (d *Decoder) SetDelimiter(delim byte) {
+	if !lexer.IsDelimiter(delim) {
+		panic(fmt.Errorf("can't use %q as a delimiter since it corresponds to an Op", delim))
 	}
+	d.delim = delim
+	d.hasDelim = true
 }
 
 // SetStackSize sets the stack size of underlying Watson VM.
@@ -91,24 +213,143 @@ func (d *Decoder) SetStacksize(size int) {
 	d.stackSize = size
 }
 
+// SetMaxInstructions limits the number of instructions that Decode executes.
+// Setting limits is recommended when decoding untrusted input.
+//
+// See vm.WithMaxInstructions for more details.
+func (d *Decoder) SetMaxInstructions(n int) {
+	d.maxInstructions = n
+}
+
+// SetMaxAllocation limits the number of bytes that Decode allocates.
+//
+// See vm.WithMaxAllocation for more details.
+func (d *Decoder) SetMaxAllocation(n int) {
+	d.maxAllocation = n
+}
+
+// SetMaxStringLength limits the length of strings that Decode builds.
+//
+// See vm.WithMaxStringLength for more details.
+func (d *Decoder) SetMaxStringLength(n int) {
+	d.maxStringLength = n
+}
+
+// SetMaxContainerSize limits the size of arrays and objects that Decode builds.
+//
+// See vm.WithMaxContainerSize for more details.
+func (d *Decoder) SetMaxContainerSize(n int) {
+	d.maxContainerLen = n
+}
+
+// SetMaxDepth limits the nesting depth of values that Decode builds.
+//
+// See vm.WithMaxDepth for more details.
+func (d *Decoder) SetMaxDepth(n int) {
+	d.maxDepth = n
+}
+
 // Decode reads a Watson value from the underlying io.Reader and converts it into v.
+//
+// If the Decoder has a delimiter, Decode reads only the next record and returns io.EOF if there are no more records.
+// Otherwise it reads the whole input.
+//
+// If an instruction fails, Decode returns a *DecodeError that tells where the instruction is.
+//
+// Unless limits are set, Decode binds objects and arrays directly to v while executing instructions instead of building the whole Value first.
+// It builds Values only for the parts of the input that need them, e.g. those bound to interface{} or types.Unmarshaler, or those moved by Gdup or Gswp.
 func (d *Decoder) Decode(v interface{}) error {
-	m := vm.NewVM(vm.WithStackSize(d.stackSize))
+	if d.skip {
+		d.skip = false
+		err := d.skipRecord()
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	// Limits are enforced only by VM, so values are bound directly only if no limits are set.
+	direct := d.maxInstructions <= 0 && d.maxAllocation <= 0 && d.maxStringLength <= 0 && d.maxContainerLen <= 0 && d.maxDepth <= 0
+	m := newBinder(v, direct, d.stackSize,
+		vm.WithMaxInstructions(d.maxInstructions),
+		vm.WithMaxAllocation(d.maxAllocation),
+		vm.WithMaxStringLength(d.maxStringLength),
+		vm.WithMaxContainerSize(d.maxContainerLen),
+		vm.WithMaxDepth(d.maxDepth),
+	)
+	empty := true
 	for {
-		tok, err := d.l.Next()
-		if err == io.EOF {
+		err := d.fill()
+		if err == lexer.ErrEndOfRecord {
+			if empty {
+				continue
+			}
+			break
+		} else if err == io.EOF {
 			break
 		} else if err != nil {
 			return err
 		}
-		err = m.Feed(tok.Op)
+		empty = false
+		for ; d.pos < d.n; d.pos++ {
+			tok := &d.toks[d.pos]
+			err = m.Feed(tok.Op)
+			if err != nil {
+				d.pos++
+				d.skip = d.hasDelim
+				return NewDecodeError(tok, m.Stack(), err)
+			}
+		}
+	}
+	if empty && d.hasDelim {
+		return io.EOF
+	}
+	return m.Bind()
+}
+
+// More reports whether there is another value to decode.
+// This is mainly useful when the Decoder has a delimiter.
+func (d *Decoder) More() bool {
+	if d.skip {
+		d.skip = false
+		err := d.skipRecord()
 		if err != nil {
-			return err
+			return err != io.EOF
 		}
 	}
-	top, err := m.Top()
-	if err != nil {
-		return err
+	for {
+		err := d.fill()
+		if err != lexer.ErrEndOfRecord {
+			// Other errors than io.EOF are reported by the next call to Decode.
+			return err != io.EOF
+		}
+	}
+}
+
+// fill makes sure that there are tokens that are not executed yet, or returns the reason why there are none.
+func (d *Decoder) fill() error {
+	if d.pos < d.n {
+		return nil
+	}
+	if d.l == nil {
+		opts := []lexer.LexerOption{}
+		if d.hasDelim {
+			opts = append(opts, lexer.WithDelimiter(d.delim))
+		}
+		d.l = lexer.NewLexer(d.r, opts...)
+	}
+	n, err := d.l.ReadTokens(d.toks)
+	d.pos, d.n = 0, n
+	return err
+}
+
+// skipRecord discards the rest of the current record.
+func (d *Decoder) skipRecord() error {
+	for {
+		d.pos = d.n
+		err := d.fill()
+		if err == lexer.ErrEndOfRecord {
+			return nil
+		} else if err != nil {
+			return err
+		}
 	}
-	return top.Bind(v)
 }
@@ -1,7 +1,11 @@
 package watson_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -58,6 +62,56 @@ func TestEncodeAndDecode(t *testing.T) {
 	}
 }
 
+func TestMarshalIsDeterministic(t *testing.T) {
+	v := map[string]int{}
+	for i := 0; i < 100; i++ {
+		v[fmt.Sprintf("key%d", i)] = i
+	}
+	first, err := watson.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		got, err := watson.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, got) {
+			t.Fatalf("expected %s but got %s", first, got)
+		}
+	}
+}
+
+func TestEncoderWithKeyOrder(t *testing.T) {
+	want := map[string]int{"a": 1, "bb": 2, "ccc": 3}
+	reversed := func(a, b string) bool {
+		return a > b
+	}
+	sorted := bytes.NewBuffer(nil)
+	err := watson.NewEncoder(sorted).Encode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	enc := watson.NewEncoder(buf)
+	enc.SetKeyOrder(reversed)
+	err = enc.Encode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sorted.Bytes(), buf.Bytes()) {
+		t.Errorf("expected the output to be affected by the key order")
+	}
+	var got map[string]int
+	err = watson.Unmarshal(buf.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func encodeThenDecode(in interface{}, out interface{}) error {
 	encoded, err := watson.Marshal(in)
 	if err != nil {
@@ -65,3 +119,220 @@ func encodeThenDecode(in interface{}, out interface{}) error {
 	}
 	return watson.Unmarshal(encoded, out)
 }
+
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestEncoderBuffersItsOutput(t *testing.T) {
+	w := &countingWriter{}
+	enc := watson.NewEncoder(w)
+	err := enc.Encode([]string{"hello", "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Errorf("expected a single write but got %d", w.writes)
+	}
+
+	var got []string
+	err = watson.Unmarshal(w.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"hello", "world"}, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	users := make([]User, 1000)
+	for i := range users {
+		users[i] = User{FullName: fmt.Sprintf("User %d", i), Age: i}
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := watson.Marshal(users)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEncoderAndDecoderWithDelimiter(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := watson.NewEncoder(buf)
+	enc.SetDelimiter('\n')
+	want := []interface{}{"hello", map[string]interface{}{"a": int64(1)}, []interface{}{true, nil}}
+	for _, v := range want {
+		err := enc.Encode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := bytes.Count(buf.Bytes(), []byte("\n")); n != len(want) {
+		t.Fatalf("expected %d records but got %d: %q", len(want), n, buf.String())
+	}
+
+	dec := watson.NewDecoder(buf)
+	dec.SetDelimiter('\n')
+	got := make([]interface{}, 0)
+	for dec.More() {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	var v interface{}
+	if err := dec.Decode(&v); err != io.EOF {
+		t.Errorf("expected io.EOF but got %v", err)
+	}
+}
+
+func TestEncoderWithParallelismWritesNothingWhenEncodeFails(t *testing.T) {
+	for _, parallelism := range []int{2, 4} {
+		buf := bytes.NewBuffer(nil)
+		enc := watson.NewEncoder(buf)
+		enc.SetParallelism(parallelism)
+		// The string flips the mode before the channel is found.
+		bad := []interface{}{"a", make(chan int)}
+		err := enc.Encode(bad)
+		if err == nil {
+			t.Fatal("expected error but got nil")
+		}
+		good := map[string]string{"a": "b"}
+		err = enc.Encode(good)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := bytes.NewBuffer(nil)
+		err = watson.NewEncoder(want).Encode(good)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want.String(), buf.String()); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestEncoderWithParallelismWritesTheSameOutput(t *testing.T) {
+	users := make([]User, 0, 50)
+	for i := 0; i < 50; i++ {
+		users = append(users, User{FullName: fmt.Sprintf("user%d", i), Age: 20 + i})
+	}
+	want := bytes.NewBuffer(nil)
+	err := watson.NewEncoder(want).Encode(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := bytes.NewBuffer(nil)
+	enc := watson.NewEncoder(got)
+	enc.SetParallelism(4)
+	err = enc.Encode(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want.String(), got.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEncoderWithLayout(t *testing.T) {
+	want := make([]User, 0, 5)
+	for i := 0; i < 5; i++ {
+		want = append(want, User{FullName: fmt.Sprintf("user%d", i), Age: 20 + i})
+	}
+	buf := bytes.NewBuffer(nil)
+	enc := watson.NewEncoder(buf)
+	enc.SetWrapColumn(100)
+	enc.SetTopLevelBreaks(true)
+	err := enc.Encode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) < len(want) {
+		t.Errorf("expected at least %d lines but got %d: %q", len(want), len(lines), buf.String())
+	}
+	for _, line := range lines {
+		if len(line) > 100 {
+			t.Errorf("too long line: %q", line)
+		}
+	}
+
+	var got []User
+	err = watson.NewDecoder(buf).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEncoderWithLayoutRejectsNewlineAsDelimiter(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := watson.NewEncoder(buf)
+	enc.SetDelimiter('\n')
+	enc.SetWrapColumn(80)
+	err := enc.Encode("hello")
+	if err == nil {
+		t.Fatal("expected error but got nil")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing to be written but got %q", buf.String())
+	}
+}
+
+func TestDecoderWithDelimiterSkipsEmptyRecords(t *testing.T) {
+	dec := watson.NewDecoder(strings.NewReader("\n\nBu\n  \n\nBuu"))
+	dec.SetDelimiter('\n')
+	got := make([]int, 0)
+	for dec.More() {
+		var n int
+		err := dec.Decode(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, n)
+	}
+	if diff := cmp.Diff([]int{1, 2}, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDecoderWithDelimiterContinuesAfterAnError(t *testing.T) {
+	// The second record fails at "a" (Iadd), and the rest of it must be skipped.
+	dec := watson.NewDecoder(strings.NewReader("Bu\nBaBuu\nBuuu\n"))
+	dec.SetDelimiter('\n')
+	var n int
+	err := dec.Decode(&n)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 but got %d, %v", n, err)
+	}
+	err = dec.Decode(&n)
+	var decErr *watson.DecodeError
+	if !errors.As(err, &decErr) || decErr.Line != 1 || decErr.Column != 1 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = dec.Decode(&n)
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 but got %d, %v", n, err)
+	}
+	if dec.More() {
+		t.Errorf("expected no more records")
+	}
+}